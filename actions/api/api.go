@@ -9,6 +9,8 @@ import (
 	apirouter "github.com/mrz1836/go-api-router"
 	"github.com/mrz1836/go-api/config"
 	"github.com/mrz1836/go-api/jobs"
+	"github.com/mrz1836/go-api/metrics"
+	"github.com/mrz1836/go-api/tracing"
 	"github.com/mrz1836/go-logger"
 )
 
@@ -23,10 +25,30 @@ func RegisterRoutes(router *apirouter.Router) {
 	router.HTTPRouter.OPTIONS("/", router.SetCrossOriginHeaders)
 
 	// Set the health request (used for load balancers)
-	router.HTTPRouter.GET("/"+config.HealthRequestPath, router.Request(health))
+	router.HTTPRouter.GET("/"+config.HealthRequestPath, router.Request(healthCheck))
 	router.HTTPRouter.OPTIONS("/"+config.HealthRequestPath, router.SetCrossOriginHeaders)
 	router.HTTPRouter.HEAD("/"+config.HealthRequestPath, router.SetCrossOriginHeaders)
 
+	// Set the liveness request (process is up and serving)
+	router.HTTPRouter.GET("/"+config.HealthLivePath, router.Request(healthLive))
+	router.HTTPRouter.OPTIONS("/"+config.HealthLivePath, router.SetCrossOriginHeaders)
+
+	// Set the readiness request (all dependencies are reachable)
+	router.HTTPRouter.GET("/"+config.HealthReadyPath, router.Request(healthReady))
+	router.HTTPRouter.OPTIONS("/"+config.HealthReadyPath, router.SetCrossOriginHeaders)
+
+	// Set the metrics request (only if not served on the admin port)
+	if config.Values.Metrics.Enabled && len(config.Values.Metrics.AdminPort) == 0 {
+		if config.Values.Metrics.RequireAuth {
+			router.HTTPRouter.GET("/"+config.MetricsRequestPath, router.BasicAuth(metricsRequest, config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+		} else {
+			router.HTTPRouter.GET("/"+config.MetricsRequestPath, metricsRequest)
+		}
+	}
+
+	// Set the response cache purge request (all cached responses, or by tag)
+	router.HTTPRouter.DELETE("/"+config.ResponseCachePath, router.BasicAuth(router.Request(purgeResponseCache), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+
 	// Set the 404 handler (any request not detected)
 	router.HTTPRouter.NotFound = http.HandlerFunc(notFound)
 
@@ -37,8 +59,13 @@ func RegisterRoutes(router *apirouter.Router) {
 // loadService will load all dependencies for the service
 func loadService() {
 
-	// Load jobs or services
-	jobs.RunExampleJob(true, 5)
+	// Register the readiness checks
+	registerHealthChecks()
+
+	// Load jobs or services (schedules are in the config jobs section)
+	if err := jobs.StartUp(); err != nil {
+		logger.Data(2, logger.ERROR, "error loading jobs: "+err.Error())
+	}
 
 	// Done!
 	logger.Data(2, logger.DEBUG, config.ServiceModeAPI+" dependencies loaded!")
@@ -50,20 +77,20 @@ func index(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 	apirouter.ReturnResponse(w, req, http.StatusOK, returnResponse)
 }
 
-// health basic request to return a health response
-func health(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
-	w.WriteHeader(http.StatusOK)
+// metricsRequest returns all metrics in the Prometheus text format
+func metricsRequest(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	metrics.Handler().ServeHTTP(w, req)
 }
 
 // notFound handles all 404 requests
 func notFound(w http.ResponseWriter, req *http.Request) {
 	// w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("404 occurred: %s", req.RequestURI), "Whoops - this request is not recognized", http.StatusNotFound, http.StatusNotFound, "")
+	apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("404 occurred: %s", req.RequestURI), "Whoops - this request is not recognized", http.StatusNotFound, http.StatusNotFound, tracing.ErrorData(req.Context()))
 	apirouter.ReturnResponse(w, req, apiError.Code, apiError)
 }
 
 // notAllowed handles all 405 requests
 func notAllowed(w http.ResponseWriter, req *http.Request) {
-	apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("405 occurred: %s method: %s", req.RequestURI, req.Method), "Whoops - this method is not allowed", http.StatusMethodNotAllowed, http.StatusMethodNotAllowed, "")
+	apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("405 occurred: %s method: %s", req.RequestURI, req.Method), "Whoops - this method is not allowed", http.StatusMethodNotAllowed, http.StatusMethodNotAllowed, tracing.ErrorData(req.Context()))
 	apirouter.ReturnResponse(w, req, apiError.Code, apiError)
 }
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	apirouter "github.com/mrz1836/go-api-router"
+	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-api/database"
+	"github.com/mrz1836/go-api/health"
+	"github.com/mrz1836/go-api/notifications"
+	"github.com/mrz1836/go-api/tracing"
+	"github.com/mrz1836/go-cache"
+)
+
+// Health check names
+const (
+	healthCheckCache         = "cache"
+	healthCheckDatabaseRead  = "database_read"
+	healthCheckDatabaseWrite = "database_write"
+	healthCheckEmail         = "email"
+)
+
+// registerHealthChecks will register the default readiness checks
+func registerHealthChecks() {
+
+	// Read database
+	health.Register(healthCheckDatabaseRead, config.HealthCheckTimeout, func(ctx context.Context) error {
+		if database.ReadDatabase == nil {
+			return errors.New("read database is not connected")
+		}
+		return database.ReadDatabase.GetReadDatabase().PingContext(ctx)
+	})
+
+	// Write database
+	health.Register(healthCheckDatabaseWrite, config.HealthCheckTimeout, func(ctx context.Context) error {
+		if database.WriteDatabase == nil {
+			return errors.New("write database is not connected")
+		}
+		return database.WriteDatabase.GetWriteDatabase().PingContext(ctx)
+	})
+
+	// Cache (only if enabled)
+	if config.Values.CacheEnabled {
+		health.Register(healthCheckCache, config.HealthCheckTimeout, func(ctx context.Context) (err error) {
+			ctx, span := tracing.StartSpan(ctx, "redis ping")
+			err = cache.Ping(ctx, config.Values.Cache.Client)
+			tracing.EndSpan(span, err)
+			return
+		})
+	}
+
+	// Email service configuration
+	health.Register(healthCheckEmail, config.HealthCheckTimeout, notifications.CheckEmailService)
+}
+
+// healthCheck basic request to return a health response
+func healthCheck(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	w.WriteHeader(http.StatusOK)
+}
+
+// healthLive returns a response if the process is running (no dependencies are checked)
+func healthLive(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	apirouter.ReturnResponse(w, req, http.StatusOK, map[string]interface{}{"status": health.StatusUp})
+}
+
+// healthReady runs all registered checks and returns the status of each component
+func healthReady(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+
+	// Run all the checks
+	report := health.Check(req.Context())
+
+	// Not ready if any component is down
+	statusCode := http.StatusOK
+	if !report.Healthy() {
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	apirouter.ReturnResponse(w, req, statusCode, report)
+}
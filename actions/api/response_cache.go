@@ -0,0 +1,40 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	apirouter "github.com/mrz1836/go-api-router"
+	"github.com/mrz1836/go-api/responsecache"
+	"github.com/mrz1836/go-api/tracing"
+)
+
+// purgeResponseCache removes cached responses by tag (?tags=persons,other) or all cached responses
+func purgeResponseCache(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+
+	// Get the tags (none will flush everything)
+	var tags []string
+	for _, tag := range strings.Split(apirouter.GetParams(req).GetString("tags"), ",") {
+		if tag = strings.TrimSpace(tag); len(tag) > 0 {
+			tags = append(tags, tag)
+		}
+	}
+
+	// Purge the tags or flush
+	var err error
+	if len(tags) > 0 {
+		err = responsecache.Purge(req.Context(), tags...)
+	} else {
+		tags = []string{responsecache.TagAll}
+		err = responsecache.Flush(req.Context())
+	}
+	if err != nil {
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error purging response cache: %s", err.Error()), "unable to purge the response cache", http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	}
+
+	apirouter.ReturnResponse(w, req, http.StatusOK, map[string]interface{}{"purged": tags})
+}
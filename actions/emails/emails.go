@@ -0,0 +1,131 @@
+// Package emails are the admin actions for the email outbox (inspect and resend), the email templates (preview and test-send),
+// the provider webhooks (bounces, complaints and opens) and the development mailbox
+package emails
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	apirouter "github.com/mrz1836/go-api-router"
+	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-api/notifications"
+	"github.com/mrz1836/go-api/tracing"
+)
+
+// Outbox list limits
+const (
+	defaultLimit = 50
+	maxLimit     = 500
+)
+
+// RegisterRoutes register all the package specific routes
+func RegisterRoutes(router *apirouter.Router) {
+	router.HTTPRouter.GET("/emails/outbox", router.BasicAuth(router.Request(listOutbox), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+	router.HTTPRouter.GET("/emails/outbox/:id", router.BasicAuth(router.Request(getOutboxEmail), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+	router.HTTPRouter.POST("/emails/outbox/:id/resend", router.BasicAuth(router.Request(resendOutboxEmail), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+	router.HTTPRouter.GET("/emails/providers", router.BasicAuth(router.Request(listProviders), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+	router.HTTPRouter.OPTIONS("/emails/outbox", router.SetCrossOriginHeaders)
+	router.HTTPRouter.GET("/emails/templates", router.BasicAuth(router.Request(listTemplates), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+	router.HTTPRouter.POST("/emails/templates/preview", router.BasicAuth(router.Request(previewTemplate), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+	router.HTTPRouter.POST("/emails/templates/test", router.BasicAuth(router.Request(testSendTemplate), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+	router.HTTPRouter.OPTIONS("/emails/templates", router.SetCrossOriginHeaders)
+	router.HTTPRouter.GET("/emails/events", router.BasicAuth(router.Request(listEvents), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+	router.HTTPRouter.GET("/emails/suppressions", router.BasicAuth(router.Request(listSuppressions), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+	router.HTTPRouter.DELETE("/emails/suppressions/:email", router.BasicAuth(router.Request(deleteSuppression), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+
+	// Provider webhooks (verified by each provider's signature, the raw body is needed so the params are not parsed)
+	router.HTTPRouter.POST("/emails/webhooks/postmark", postmarkWebhook)
+	router.HTTPRouter.POST("/emails/webhooks/mandrill", mandrillWebhook)
+	router.HTTPRouter.HEAD("/emails/webhooks/mandrill", mandrillWebhookCheck)
+	router.HTTPRouter.POST("/emails/webhooks/ses", sesWebhook)
+
+	// Template gallery and mailbox (development only)
+	if config.Values.Environment == config.EnvironmentDevelopment {
+		router.HTTPRouter.GET("/dev/emails", router.BasicAuth(router.Request(templateGallery), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+		router.HTTPRouter.GET("/dev/emails/preview", router.BasicAuth(router.Request(galleryPreview), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+		router.HTTPRouter.GET("/dev/mailbox", router.BasicAuth(router.Request(listMailbox), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+		router.HTTPRouter.GET("/dev/mailbox/:id", router.BasicAuth(router.Request(getMailboxMessage), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+		router.HTTPRouter.DELETE("/dev/mailbox", router.BasicAuth(router.Request(clearMailbox), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+	}
+}
+
+// listOutbox returns the latest outbox emails (?status=failed&person_id=1&limit=50)
+func listOutbox(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+
+	// Get the filters
+	params := apirouter.GetParams(req)
+	limit := params.GetInt("limit")
+	if limit <= 0 || limit > maxLimit {
+		limit = defaultLimit
+	}
+
+	// Get the emails
+	emails, err := notifications.GetOutboxEmails(req.Context(), params.GetString("status"), params.GetUint64("person_id"), limit)
+	if err != nil {
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error getting outbox emails: %s", err.Error()), "unable to list outbox emails", http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	}
+
+	apirouter.ReturnResponse(w, req, http.StatusOK, emails)
+}
+
+// getOutboxEmail returns an outbox email, its delivery log and its webhook events
+func getOutboxEmail(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+
+	// Get the email
+	id, _ := strconv.ParseUint(ps.ByName("id"), 10, 64)
+	email, err := notifications.GetOutboxEmail(req.Context(), id)
+	if err != nil {
+		returnOutboxError(w, req, err, "unable to get outbox email")
+		return
+	}
+
+	// Get the delivery log
+	var deliveries []*notifications.EmailDelivery
+	if deliveries, err = notifications.GetEmailDeliveries(req.Context(), id); err != nil {
+		returnOutboxError(w, req, err, "unable to get email deliveries")
+		return
+	}
+
+	// Get the webhook events
+	var events []*notifications.EmailEvent
+	if events, err = notifications.GetEmailEvents(req.Context(), id, 0, "", maxLimit); err != nil {
+		returnOutboxError(w, req, err, "unable to get email events")
+		return
+	}
+
+	apirouter.ReturnResponse(w, req, http.StatusOK, map[string]interface{}{"deliveries": deliveries, "email": email, "events": events})
+}
+
+// resendOutboxEmail queues a sent, failed or skipped email again (sent by the next email-outbox run)
+func resendOutboxEmail(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id, _ := strconv.ParseUint(ps.ByName("id"), 10, 64)
+	if err := notifications.ResendOutboxEmail(req.Context(), id); err != nil {
+		returnOutboxError(w, req, err, "unable to resend outbox email")
+		return
+	}
+	apirouter.ReturnResponse(w, req, http.StatusAccepted, map[string]interface{}{"id": id, "status": notifications.OutboxStatusPending})
+}
+
+// listProviders returns the health and circuit breaker of each email provider (in failover order)
+func listProviders(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	providers := notifications.ProvidersHealth()
+	if providers == nil {
+		providers = []notifications.ProviderHealth{}
+	}
+	apirouter.ReturnResponse(w, req, http.StatusOK, providers)
+}
+
+// returnOutboxError returns a 404 for unknown emails, otherwise a 417
+func returnOutboxError(w http.ResponseWriter, req *http.Request, err error, publicMessage string) {
+	status := http.StatusExpectationFailed
+	if errors.Is(err, notifications.ErrOutboxEmailNotFound) {
+		status = http.StatusNotFound
+	}
+	apiError := apirouter.ErrorFromRequest(req, err.Error(), publicMessage, status, status, tracing.ErrorData(req.Context()))
+	apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+}
@@ -0,0 +1,50 @@
+package emails
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"path/filepath"
+
+	"github.com/julienschmidt/httprouter"
+	apirouter "github.com/mrz1836/go-api-router"
+	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-api/notifications"
+	"github.com/mrz1836/go-api/tracing"
+)
+
+// templateGallery lists every template with a preview (development only, parsed on each request to pick up changes)
+func templateGallery(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+
+	// Load the gallery page
+	gallery, err := template.ParseFiles(filepath.Join(config.GetCurrentDir(), "..", "static", "views", "dev", "email_gallery.html"))
+	if err != nil {
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error loading gallery: %s", err.Error()), "unable to load the email gallery", http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_ = gallery.Execute(w, notifications.Templates())
+}
+
+// galleryPreview renders a template with the sample data (?name=persons/example_email&locale=es&format=html)
+func galleryPreview(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+
+	// Get the parameters
+	params := apirouter.GetParams(req)
+	format := params.GetString("format")
+	if len(format) == 0 {
+		format = formatHTML
+	}
+
+	// Render the template
+	rendered, err := notifications.Preview(params.GetString("name"), params.GetString("locale"), nil)
+	if err != nil {
+		returnTemplateError(w, req, err, "unable to preview template")
+		return
+	}
+
+	writeRendered(w, req, rendered, format)
+}
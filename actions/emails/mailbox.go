@@ -0,0 +1,84 @@
+package emails
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"path/filepath"
+
+	"github.com/julienschmidt/httprouter"
+	apirouter "github.com/mrz1836/go-api-router"
+	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-api/notifications"
+	"github.com/mrz1836/go-api/tracing"
+)
+
+// listMailbox lists the emails kept by the file and memory providers (?format=json, development only)
+func listMailbox(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+
+	// Get the messages
+	messages, err := notifications.MailboxMessages()
+	if err != nil {
+		returnMailboxError(w, req, err, "unable to list mailbox")
+		return
+	}
+
+	// JSON list
+	if apirouter.GetParams(req).GetString("format") == "json" {
+		apirouter.ReturnResponse(w, req, http.StatusOK, messages)
+		return
+	}
+
+	// Load the mailbox page (parsed on each request to pick up changes)
+	var page *template.Template
+	if page, err = template.ParseFiles(filepath.Join(config.GetCurrentDir(), "..", "static", "views", "dev", "mailbox.html")); err != nil {
+		returnMailboxError(w, req, fmt.Errorf("error loading mailbox page: %w", err), "unable to load the mailbox")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_ = page.Execute(w, messages)
+}
+
+// getMailboxMessage returns an email from the mailbox (?format=html|text|json)
+func getMailboxMessage(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+
+	// Get the message
+	message, err := notifications.MailboxMessageByID(ps.ByName("id"))
+	if err != nil {
+		returnMailboxError(w, req, err, "unable to get mailbox message")
+		return
+	}
+
+	// Default is the html body
+	format := apirouter.GetParams(req).GetString("format")
+	if len(format) == 0 {
+		format = formatHTML
+	}
+	if format != formatHTML && format != formatText {
+		apirouter.ReturnResponse(w, req, http.StatusOK, message)
+		return
+	}
+	writeRendered(w, req, &notifications.RenderedEmail{HTML: message.HTML, Subject: message.Subject, Text: message.Text}, format)
+}
+
+// clearMailbox removes every email from the mailbox
+func clearMailbox(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if err := notifications.ClearMailbox(); err != nil {
+		returnMailboxError(w, req, err, "unable to clear mailbox")
+		return
+	}
+	apirouter.ReturnResponse(w, req, http.StatusOK, map[string]interface{}{"cleared": true})
+}
+
+// returnMailboxError returns a 404 for unknown messages, otherwise a 417
+func returnMailboxError(w http.ResponseWriter, req *http.Request, err error, publicMessage string) {
+	status := http.StatusExpectationFailed
+	if errors.Is(err, notifications.ErrMailboxMessageNotFound) {
+		status = http.StatusNotFound
+	}
+	apiError := apirouter.ErrorFromRequest(req, err.Error(), publicMessage, status, status, tracing.ErrorData(req.Context()))
+	apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+}
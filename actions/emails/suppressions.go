@@ -0,0 +1,68 @@
+package emails
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	apirouter "github.com/mrz1836/go-api-router"
+	"github.com/mrz1836/go-api/notifications"
+	"github.com/mrz1836/go-api/tracing"
+)
+
+// listEvents returns the latest webhook events (?outbox_id=1&person_id=1&email=a@example.com&limit=50)
+func listEvents(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+
+	// Get the filters
+	params := apirouter.GetParams(req)
+	limit := params.GetInt("limit")
+	if limit <= 0 || limit > maxLimit {
+		limit = defaultLimit
+	}
+
+	// Get the events
+	events, err := notifications.GetEmailEvents(req.Context(), params.GetUint64("outbox_id"), params.GetUint64("person_id"), params.GetString("email"), limit)
+	if err != nil {
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error getting email events: %s", err.Error()), "unable to list email events", http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	}
+
+	apirouter.ReturnResponse(w, req, http.StatusOK, events)
+}
+
+// listSuppressions returns the latest suppressed addresses (?limit=50)
+func listSuppressions(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+
+	// Get the limit
+	limit := apirouter.GetParams(req).GetInt("limit")
+	if limit <= 0 || limit > maxLimit {
+		limit = defaultLimit
+	}
+
+	// Get the suppressions
+	suppressions, err := notifications.GetSuppressions(req.Context(), limit)
+	if err != nil {
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error getting email suppressions: %s", err.Error()), "unable to list email suppressions", http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	}
+
+	apirouter.ReturnResponse(w, req, http.StatusOK, suppressions)
+}
+
+// deleteSuppression allows sending to the address again
+func deleteSuppression(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	email := ps.ByName("email")
+	if err := notifications.UnsuppressEmail(req.Context(), email); err != nil {
+		status := http.StatusExpectationFailed
+		if errors.Is(err, notifications.ErrSuppressionNotFound) {
+			status = http.StatusNotFound
+		}
+		apiError := apirouter.ErrorFromRequest(req, err.Error(), "unable to remove email suppression", status, status, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	}
+	apirouter.ReturnResponse(w, req, http.StatusOK, map[string]interface{}{"email": email, "suppressed": false})
+}
@@ -0,0 +1,111 @@
+package emails
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	apirouter "github.com/mrz1836/go-api-router"
+	"github.com/mrz1836/go-api/notifications"
+	"github.com/mrz1836/go-api/tracing"
+)
+
+// Preview formats (the default is json with html, text and subject)
+const (
+	formatHTML = "html"
+	formatText = "text"
+)
+
+// listTemplates returns every loaded template with its locales and data type
+func listTemplates(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	apirouter.ReturnResponse(w, req, http.StatusOK, notifications.Templates())
+}
+
+// previewTemplate renders a template with the sample data or the supplied data (name, locale, data, format)
+func previewTemplate(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+
+	// Get the parameters
+	params := apirouter.GetParams(req)
+	data, err := dataParam(params.Values)
+	if err != nil {
+		returnTemplateError(w, req, err, "unable to preview template")
+		return
+	}
+
+	// Render the template
+	var rendered *notifications.RenderedEmail
+	if rendered, err = notifications.Preview(params.GetString("name"), params.GetString("locale"), data); err != nil {
+		returnTemplateError(w, req, err, "unable to preview template")
+		return
+	}
+
+	writeRendered(w, req, rendered, params.GetString("format"))
+}
+
+// testSendTemplate renders a template and sends it to an allowlisted address (name, locale, data, to)
+func testSendTemplate(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+
+	// Get the parameters
+	params := apirouter.GetParams(req)
+	data, err := dataParam(params.Values)
+	if err != nil {
+		returnTemplateError(w, req, err, "unable to send test email")
+		return
+	}
+
+	// Render and send
+	to := params.GetString("to")
+	var rendered *notifications.RenderedEmail
+	if rendered, err = notifications.SendTest(req.Context(), params.GetString("name"), params.GetString("locale"), to, data); err != nil {
+		returnTemplateError(w, req, err, "unable to send test email")
+		return
+	}
+
+	apirouter.ReturnResponse(w, req, http.StatusOK, map[string]interface{}{"subject": rendered.Subject, "to": to})
+}
+
+// dataParam returns the supplied data as JSON (a JSON string or an object in a JSON body)
+func dataParam(values map[string]interface{}) ([]byte, error) {
+	switch data := values["data"].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if len(data) == 0 {
+			return nil, nil
+		}
+		return []byte(data), nil
+	default:
+		return json.Marshal(data)
+	}
+}
+
+// writeRendered writes the rendered email as html, text or json
+func writeRendered(w http.ResponseWriter, req *http.Request, rendered *notifications.RenderedEmail, format string) {
+	switch format {
+	case formatHTML:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(rendered.HTML))
+	case formatText:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(rendered.Text))
+	default:
+		apirouter.ReturnResponse(w, req, http.StatusOK, rendered)
+	}
+}
+
+// returnTemplateError returns a 404 for unknown templates, 403 for recipients not in the allowlist, otherwise a 400
+func returnTemplateError(w http.ResponseWriter, req *http.Request, err error, publicMessage string) {
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, notifications.ErrTemplateNotDefined), errors.Is(err, notifications.ErrTemplateNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, notifications.ErrRecipientNotAllowed):
+		status = http.StatusForbidden
+	}
+	apiError := apirouter.ErrorFromRequest(req, err.Error(), fmt.Sprintf("%s: %s", publicMessage, err.Error()), status, status, tracing.ErrorData(req.Context()))
+	apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+}
@@ -0,0 +1,117 @@
+package emails
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	apirouter "github.com/mrz1836/go-api-router"
+	"github.com/mrz1836/go-api/notifications"
+	"github.com/mrz1836/go-api/tracing"
+)
+
+// maxWebhookBody is the largest webhook payload that is read (Mandrill batches up to 1000 events)
+const maxWebhookBody = 10 << 20
+
+// postmarkWebhook records a Postmark event (basic auth is set in the webhook url)
+func postmarkWebhook(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+
+	// Check the credentials
+	user, password, _ := req.BasicAuth()
+	if !notifications.VerifyPostmarkAuth(user, password) {
+		returnWebhookError(w, req, notifications.ErrWebhookUnauthorized)
+		return
+	}
+
+	// Record the event
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxWebhookBody))
+	if err != nil {
+		returnWebhookError(w, req, fmt.Errorf("%w: %s", notifications.ErrWebhookPayload, err.Error()))
+		return
+	}
+	var recorded int
+	if recorded, err = notifications.RecordPostmarkEvent(req.Context(), body); err != nil {
+		returnWebhookError(w, req, err)
+		return
+	}
+
+	apirouter.ReturnResponse(w, req, http.StatusOK, map[string]interface{}{"recorded": recorded})
+}
+
+// mandrillWebhook records the Mandrill events (signed with X-Mandrill-Signature)
+func mandrillWebhook(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+
+	// Read the form and check the signature
+	req.Body = http.MaxBytesReader(w, req.Body, maxWebhookBody)
+	if err := req.ParseForm(); err != nil {
+		returnWebhookError(w, req, fmt.Errorf("%w: %s", notifications.ErrWebhookPayload, err.Error()))
+		return
+	}
+	if !notifications.VerifyMandrillSignature(req.Header.Get("X-Mandrill-Signature"), req.PostForm) {
+		returnWebhookError(w, req, notifications.ErrWebhookUnauthorized)
+		return
+	}
+
+	// Record the events
+	recorded, err := notifications.RecordMandrillEvents(req.Context(), req.PostForm)
+	if err != nil {
+		returnWebhookError(w, req, err)
+		return
+	}
+
+	apirouter.ReturnResponse(w, req, http.StatusOK, map[string]interface{}{"recorded": recorded})
+}
+
+// mandrillWebhookCheck answers the HEAD request Mandrill sends when the webhook is added
+func mandrillWebhookCheck(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	w.WriteHeader(http.StatusOK)
+}
+
+// sesWebhook records the SES events delivered by SNS (and confirms the SNS subscription)
+func sesWebhook(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+
+	// Decode the SNS message (sent as text/plain)
+	message := new(notifications.SNSMessage)
+	if err := json.NewDecoder(io.LimitReader(req.Body, maxWebhookBody)).Decode(message); err != nil {
+		returnWebhookError(w, req, fmt.Errorf("%w: %s", notifications.ErrWebhookPayload, err.Error()))
+		return
+	}
+
+	// Check the topic and the signature
+	if err := notifications.VerifySNSMessage(req.Context(), message); err != nil {
+		returnWebhookError(w, req, err)
+		return
+	}
+
+	// Confirm the subscription or record the events
+	recorded := 0
+	var err error
+	switch message.Type {
+	case notifications.SNSTypeSubscriptionConfirmation:
+		err = notifications.ConfirmSNSSubscription(req.Context(), message)
+	case notifications.SNSTypeNotification:
+		recorded, err = notifications.RecordSESEvents(req.Context(), message)
+	}
+	if err != nil {
+		returnWebhookError(w, req, err)
+		return
+	}
+
+	apirouter.ReturnResponse(w, req, http.StatusOK, map[string]interface{}{"recorded": recorded})
+}
+
+// returnWebhookError returns a 401 for signatures, 400 for payloads, otherwise a 417 (the provider retries)
+func returnWebhookError(w http.ResponseWriter, req *http.Request, err error) {
+	status := http.StatusExpectationFailed
+	switch {
+	case errors.Is(err, notifications.ErrWebhookUnauthorized):
+		status = http.StatusUnauthorized
+	case errors.Is(err, notifications.ErrWebhookPayload):
+		status = http.StatusBadRequest
+	}
+	apiError := apirouter.ErrorFromRequest(req, err.Error(), "unable to record webhook", status, status, tracing.ErrorData(req.Context()))
+	apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+}
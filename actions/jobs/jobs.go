@@ -0,0 +1,128 @@
+// Package jobs are the admin actions for the scheduled jobs (list, trigger, pause, resume and reschedule)
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	apirouter "github.com/mrz1836/go-api-router"
+	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-api/jobs"
+	"github.com/mrz1836/go-api/models"
+	"github.com/mrz1836/go-api/tracing"
+)
+
+// recentRuns is the amount of job runs returned with a job
+const recentRuns = 20
+
+// RegisterRoutes register all the package specific routes
+func RegisterRoutes(router *apirouter.Router) {
+	router.HTTPRouter.GET("/jobs", router.BasicAuth(router.Request(listJobs), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+	router.HTTPRouter.GET("/jobs/:name", router.BasicAuth(router.Request(getJob), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+	router.HTTPRouter.PUT("/jobs/:name", router.BasicAuth(router.Request(rescheduleJob), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+	router.HTTPRouter.POST("/jobs/:name/run", router.BasicAuth(router.Request(triggerJob), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+	router.HTTPRouter.POST("/jobs/:name/pause", router.BasicAuth(router.Request(pauseJob), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+	router.HTTPRouter.POST("/jobs/:name/resume", router.BasicAuth(router.Request(resumeJob), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+	router.HTTPRouter.OPTIONS("/jobs", router.SetCrossOriginHeaders)
+}
+
+// listJobs returns the schedule of all jobs
+func listJobs(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	apirouter.ReturnResponse(w, req, http.StatusOK, jobs.DefaultRegistry.List())
+}
+
+// getJob returns the schedule and the recent runs of a job
+func getJob(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+
+	// Get the job
+	info, err := jobs.DefaultRegistry.Get(ps.ByName("name"))
+	if err != nil {
+		returnJobError(w, req, err, "unable to get job")
+		return
+	}
+
+	// Get the recent runs
+	var runs []*models.JobRun
+	if runs, err = models.GetJobRuns(req.Context(), info.Name, recentRuns); err != nil {
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error getting job runs: %s", err.Error()), "unable to get job runs", http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	}
+
+	apirouter.ReturnResponse(w, req, http.StatusOK, map[string]interface{}{"job": info, "runs": runs})
+}
+
+// rescheduleJob changes the spec of a job (IE: spec=@every 10m)
+func rescheduleJob(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+
+	// Get the spec
+	spec := apirouter.GetParams(req).GetString("spec")
+	if len(spec) == 0 {
+		apiError := apirouter.ErrorFromRequest(req, "missing field: spec", "error rescheduling job - missing field: spec", http.StatusBadRequest, http.StatusBadRequest, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	}
+
+	// Change the schedule
+	name := ps.ByName("name")
+	if err := jobs.DefaultRegistry.Reschedule(req.Context(), name, spec); err != nil {
+		returnJobError(w, req, err, "unable to reschedule job")
+		return
+	}
+
+	returnJob(w, req, name)
+}
+
+// triggerJob runs a job now (in the background)
+func triggerJob(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	name := ps.ByName("name")
+	if err := jobs.DefaultRegistry.Trigger(name); err != nil {
+		returnJobError(w, req, err, "unable to run job")
+		return
+	}
+	apirouter.ReturnResponse(w, req, http.StatusAccepted, map[string]string{"job": name, "status": models.JobRunStatusRunning})
+}
+
+// pauseJob stops scheduled runs of a job
+func pauseJob(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	name := ps.ByName("name")
+	if err := jobs.DefaultRegistry.Pause(req.Context(), name); err != nil {
+		returnJobError(w, req, err, "unable to pause job")
+		return
+	}
+	returnJob(w, req, name)
+}
+
+// resumeJob starts scheduled runs of a job again
+func resumeJob(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	name := ps.ByName("name")
+	if err := jobs.DefaultRegistry.Resume(req.Context(), name); err != nil {
+		returnJobError(w, req, err, "unable to resume job")
+		return
+	}
+	returnJob(w, req, name)
+}
+
+// returnJob returns the current schedule of a job
+func returnJob(w http.ResponseWriter, req *http.Request, name string) {
+	info, err := jobs.DefaultRegistry.Get(name)
+	if err != nil {
+		returnJobError(w, req, err, "unable to get job")
+		return
+	}
+	apirouter.ReturnResponse(w, req, http.StatusOK, info)
+}
+
+// returnJobError returns a 404 for unknown jobs, a 409 if the job is running, otherwise a 400 (IE: invalid spec)
+func returnJobError(w http.ResponseWriter, req *http.Request, err error, publicMessage string) {
+	status := http.StatusBadRequest
+	if errors.Is(err, jobs.ErrNotFound) {
+		status = http.StatusNotFound
+	} else if errors.Is(err, jobs.ErrAlreadyRunning) {
+		status = http.StatusConflict
+	}
+	apiError := apirouter.ErrorFromRequest(req, err.Error(), publicMessage+": "+err.Error(), status, status, tracing.ErrorData(req.Context()))
+	apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+}
@@ -0,0 +1,57 @@
+package persons
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	apirouter "github.com/mrz1836/go-api-router"
+	"github.com/mrz1836/go-api/models"
+	"github.com/mrz1836/go-api/tracing"
+)
+
+// History page limits
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+// personHistory is the response for a page of the person's changes
+type personHistory struct {
+	Changes    []*models.PersonAudit `json:"changes"`
+	NextBefore uint64                `json:"next_before,omitempty"` // Send as before for the next page (not set on the last page)
+}
+
+// getPersonHistory returns the person's changes, newest first (?limit=50&before=<id>, the history is kept after a purge)
+func getPersonHistory(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+
+	// Get the page
+	params := apirouter.GetParams(req)
+	limit := params.GetInt("limit")
+	if limit <= 0 || limit > maxHistoryLimit {
+		limit = defaultHistoryLimit
+	}
+	id, _ := strconv.ParseUint(ps.ByName("id"), 10, 64)
+	if id == 0 {
+		apiError := apirouter.ErrorFromRequest(req, "missing field: id", "error getting person history - missing field: id", http.StatusBadRequest, http.StatusBadRequest, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	}
+
+	// Get the changes
+	changes, err := models.GetPersonHistory(req.Context(), id, params.GetUint64("before"), limit)
+	if err != nil {
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error getting person history: %s", err.Error()), "unable to get person history", http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	}
+
+	// A full page could have more
+	history := &personHistory{Changes: changes}
+	if len(changes) == limit {
+		history.NextBefore = changes[len(changes)-1].ID
+	}
+
+	apirouter.ReturnResponse(w, req, http.StatusOK, history)
+}
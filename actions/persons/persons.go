@@ -2,10 +2,10 @@
 package persons
 
 import (
-	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/julienschmidt/httprouter"
 	apirouter "github.com/mrz1836/go-api-router"
@@ -13,14 +13,44 @@ import (
 	"github.com/mrz1836/go-api/database"
 	"github.com/mrz1836/go-api/models"
 	"github.com/mrz1836/go-api/models/schema"
+	"github.com/mrz1836/go-api/tracing"
+	"github.com/volatiletech/null/v8"
 )
 
 // RegisterRoutes register all the package specific routes
 func RegisterRoutes(router *apirouter.Router) {
+	router.HTTPRouter.GET("/persons", router.BasicAuth(router.Request(listPersons), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
 	router.HTTPRouter.POST("/persons", router.BasicAuth(router.Request(createPerson), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
 	router.HTTPRouter.PUT("/persons", router.BasicAuth(router.Request(updatePerson), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
 	router.HTTPRouter.DELETE("/persons", router.BasicAuth(router.Request(deletePerson), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
 	router.HTTPRouter.OPTIONS("/persons", router.SetCrossOriginHeaders)
+	router.HTTPRouter.POST("/persons/:id/restore", router.BasicAuth(router.Request(restorePerson), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+	router.HTTPRouter.DELETE("/persons/:id/purge", router.BasicAuth(router.Request(purgePerson), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+	router.HTTPRouter.GET("/persons/:id/history", router.BasicAuth(router.Request(getPersonHistory), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+	router.HTTPRouter.GET("/persons/:id/preferences", router.BasicAuth(router.Request(getPreferences), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+	router.HTTPRouter.PUT("/persons/:id/preferences", router.BasicAuth(router.Request(updatePreference), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+	router.HTTPRouter.GET("/persons/:id/push-subscriptions", router.BasicAuth(router.Request(listPushSubscriptions), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+	router.HTTPRouter.POST("/persons/:id/push-subscriptions", router.BasicAuth(router.Request(createPushSubscription), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+	router.HTTPRouter.DELETE("/persons/:id/push-subscriptions", router.BasicAuth(router.Request(deletePushSubscription), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+
+	// Unsubscribe links (signed token, no login needed)
+	router.HTTPRouter.GET("/unsubscribe", router.Request(unsubscribe))
+	router.HTTPRouter.POST("/unsubscribe", router.Request(unsubscribe))
+}
+
+// listPersons returns all persons that are not deleted
+func listPersons(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+
+	// Get the models
+	persons, err := models.GetPersons(req.Context())
+	if err != nil {
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error getting persons: %s", err.Error()), "unable to list persons", http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	}
+
+	// This should not fail on the encode
+	_ = apirouter.ReturnJSONEncode(w, http.StatusOK, json.NewEncoder(w), persons, models.PersonAllFields)
 }
 
 // createPerson makes a new model
@@ -37,31 +67,24 @@ func createPerson(w http.ResponseWriter, req *http.Request, _ httprouter.Params)
 	person.FirstName = params.GetString(schema.PersonColumns.FirstName)
 	person.MiddleName = params.GetString(schema.PersonColumns.MiddleName)
 	person.LastName = params.GetString(schema.PersonColumns.LastName)
+	person.Locale = params.GetString(schema.PersonColumns.Locale)
+	person.Phone = params.GetString(schema.PersonColumns.Phone)
 
 	// Check missing value
 	if len(person.Email) == 0 {
-		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("missing field: %s", schema.PersonColumns.Email), fmt.Sprintf("error creating person - missing field: %s", schema.PersonColumns.Email), http.StatusBadRequest, http.StatusBadRequest, "")
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("missing field: %s", schema.PersonColumns.Email), fmt.Sprintf("error creating person - missing field: %s", schema.PersonColumns.Email), http.StatusBadRequest, http.StatusBadRequest, tracing.ErrorData(req.Context()))
 		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
 		return
 	}
 
 	// Get existing person?
-	existingPerson, err := models.GetPersonByEmail(person.Email)
+	existingPerson, err := models.GetPersonByEmail(req.Context(), person.Email)
 	if err != nil {
-		apiError := apirouter.ErrorFromRequest(req, "error getting existing person", fmt.Sprintf("error getting existing offer: %s", err.Error()), http.StatusExpectationFailed, http.StatusExpectationFailed, "")
+		apiError := apirouter.ErrorFromRequest(req, "error getting existing person", fmt.Sprintf("error getting existing offer: %s", err.Error()), http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
 		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
 		return
 	} else if existingPerson != nil && existingPerson.IsDeleted.Bool {
-		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("person has been deleted: %s", person.Email), "account has been disabled", http.StatusExpectationFailed, http.StatusExpectationFailed, "")
-		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
-		return
-	}
-
-	// Start a new transaction
-	var tx *sql.Tx
-	tx, _, err = database.NewTx(config.DatabaseDefaultTxTimeout)
-	if err != nil {
-		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error creating tx: %s", err.Error()), "error creating person", http.StatusExpectationFailed, http.StatusExpectationFailed, "")
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("person has been deleted: %s", person.Email), "account has been disabled", http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
 		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
 		return
 	}
@@ -73,19 +96,39 @@ func createPerson(w http.ResponseWriter, req *http.Request, _ httprouter.Params)
 		return
 	}
 
+	// Start a new transaction
+	var tx *database.Tx
+	tx, _, err = database.NewTx(config.DatabaseDefaultTxTimeout)
+	if err != nil {
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error creating tx: %s", err.Error()), "error creating person", http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	}
+
 	// Save will insert a new person since we are creating a new model
-	_, err = person.Save(models.PersonCreateColumns, tx)
+	_, err = person.Save(req.Context(), models.PersonCreateColumns, tx)
 	if err != nil {
-		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error creating person: %s", err.Error()), fmt.Sprintf("error creating person: %s", err.Error()), http.StatusExpectationFailed, http.StatusExpectationFailed, "")
+		_ = tx.Rollback()
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error creating person: %s", err.Error()), fmt.Sprintf("error creating person: %s", err.Error()), http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
 		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
 		return
 	}
 
+	// Queue the example email (off by default, only sent if the person is committed)
+	if config.Values.Email.ExampleEmail {
+		if err = person.QueueExampleEmail(req.Context(), tx); err != nil {
+			_ = tx.Rollback()
+			apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error queueing person email: %s", err.Error()), "error creating person", http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
+			apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+			return
+		}
+	}
+
 	// Commit!
 	err = tx.Commit()
 	if err != nil {
 		_ = tx.Rollback()
-		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error creating person: %s", err.Error()), "error creating person", http.StatusExpectationFailed, http.StatusExpectationFailed, "")
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error creating person: %s", err.Error()), "error creating person", http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
 		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
 		return
 	}
@@ -100,19 +143,33 @@ func updatePerson(w http.ResponseWriter, req *http.Request, _ httprouter.Params)
 	// Get the parameters
 	params := apirouter.GetParams(req)
 
-	// Get the model by ID
-	id := params.GetUint64(schema.PersonColumns.ID)
+	// Start a new transaction
+	tx, _, err := database.NewTx(config.DatabaseDefaultTxTimeout)
+	if err != nil {
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error updating person: %s", err.Error()), "error updating person", http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	}
 
-	person, err := models.GetPersonByID(id)
+	// Get the model by ID (locked until the commit)
+	id := params.GetUint64(schema.PersonColumns.ID)
+	person, err := models.GetPersonForUpdate(req.Context(), tx, id)
 	if err != nil {
-		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error getting related person: %s", err.Error()), "unable to update person", http.StatusExpectationFailed, http.StatusExpectationFailed, "")
+		_ = tx.Rollback()
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error getting related person: %s", err.Error()), "unable to update person", http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	} else if person == nil {
+		_ = tx.Rollback()
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("person not found: %d", id), "person not found", http.StatusNotFound, http.StatusNotFound, tracing.ErrorData(req.Context()))
 		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
 		return
 	}
 
 	// Test to see if deleted
 	if person.IsDeleted.Bool {
-		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("person is marked as deleted: %d", id), "unable to update a deleted record", http.StatusExpectationFailed, http.StatusExpectationFailed, "")
+		_ = tx.Rollback()
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("person is marked as deleted: %d", id), "unable to update a deleted record", http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
 		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
 		return
 	}
@@ -120,20 +177,22 @@ func updatePerson(w http.ResponseWriter, req *http.Request, _ httprouter.Params)
 	// Set the first name
 	person.FirstName = params.GetString(schema.PersonColumns.FirstName)
 
-	// Start a new transaction
-	var tx *sql.Tx
-	tx, _, err = database.NewTx(config.DatabaseDefaultTxTimeout)
-	if err != nil {
-		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error updating person: %s", err.Error()), "error updating person", http.StatusExpectationFailed, http.StatusExpectationFailed, "")
-		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
-		return
+	// Set the locale (if changed)
+	if locale := params.GetString(schema.PersonColumns.Locale); len(locale) > 0 {
+		person.Locale = locale
+	}
+
+	// Set the phone (if changed)
+	if phone := params.GetString(schema.PersonColumns.Phone); len(phone) > 0 {
+		person.Phone = phone
 	}
 
 	// Save will update an exiting person
 	// var affected int64
-	_, err = person.Save(models.PersonUpdateColumns, tx)
+	_, err = person.Save(req.Context(), models.PersonUpdateColumns, tx)
 	if err != nil {
-		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error saving person: %s", err.Error()), fmt.Sprintf("error updating person: %s", err.Error()), http.StatusExpectationFailed, http.StatusExpectationFailed, "")
+		_ = tx.Rollback()
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error saving person: %s", err.Error()), fmt.Sprintf("error updating person: %s", err.Error()), http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
 		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
 		return
 	}
@@ -142,7 +201,7 @@ func updatePerson(w http.ResponseWriter, req *http.Request, _ httprouter.Params)
 	err = tx.Commit()
 	if err != nil {
 		_ = tx.Rollback()
-		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error in commit creating person: %s", err.Error()), "error creating person", http.StatusExpectationFailed, http.StatusExpectationFailed, "")
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error in commit creating person: %s", err.Error()), "error creating person", http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
 		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
 		return
 	}
@@ -157,47 +216,101 @@ func deletePerson(w http.ResponseWriter, req *http.Request, _ httprouter.Params)
 	// Get the parameters
 	params := apirouter.GetParams(req)
 
-	// Get the model by ID
-	id := params.GetUint64(schema.PersonColumns.ID)
+	// Start a new transaction
+	tx, _, err := database.NewTx(config.DatabaseDefaultTxTimeout)
+	if err != nil {
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error creating tx: %s", err.Error()), "error deleting person", http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	}
 
-	person, err := models.GetPersonByID(id)
+	// Get the model by ID (locked until the commit)
+	id := params.GetUint64(schema.PersonColumns.ID)
+	person, err := models.GetPersonForUpdate(req.Context(), tx, id)
 	if err != nil {
-		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error getting related person: %s", err.Error()), "unable to delete person", http.StatusExpectationFailed, http.StatusExpectationFailed, "")
+		_ = tx.Rollback()
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error getting related person: %s", err.Error()), "unable to delete person", http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	} else if person == nil {
+		_ = tx.Rollback()
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("person not found: %d", id), "person not found", http.StatusNotFound, http.StatusNotFound, tracing.ErrorData(req.Context()))
 		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
 		return
 	}
 
 	// Already deleted?
 	if person.IsDeleted.Bool {
+		_ = tx.Rollback()
 		_ = apirouter.ReturnJSONEncode(w, http.StatusOK, json.NewEncoder(w), person, models.PersonAllFields)
 		return
 	}
 
 	// Not deleted, let's update
-	person.IsDeleted.Bool = true
+	person.IsDeleted = null.BoolFrom(true)
+
+	// Save will update an exiting person
+	_, err = person.Save(req.Context(), models.PersonDeleteColumns, tx)
+	if err != nil {
+		_ = tx.Rollback()
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error saving person: %s", err.Error()), fmt.Sprintf("error deleting person: %s", err.Error()), http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	}
+
+	// Commit
+	err = tx.Commit()
+	if err != nil {
+		_ = tx.Rollback()
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error in commit: %s", err.Error()), "error deleting person", http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	}
+
+	// This should not fail on the encode
+	_ = apirouter.ReturnJSONEncode(w, http.StatusOK, json.NewEncoder(w), person, models.PersonAllFields)
+}
+
+// restorePerson will mark a deleted record as not deleted
+func restorePerson(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 
 	// Start a new transaction
-	var tx *sql.Tx
-	tx, _, err = database.NewTx(config.DatabaseDefaultTxTimeout)
+	tx, _, err := database.NewTx(config.DatabaseDefaultTxTimeout)
 	if err != nil {
-		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error creating tx: %s", err.Error()), "error deleting person", http.StatusExpectationFailed, http.StatusExpectationFailed, "")
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error creating tx: %s", err.Error()), "error restoring person", http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
 		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
 		return
 	}
 
-	// Save will update an exiting person
-	_, err = person.Save(models.PersonDeleteColumns, tx)
+	// Get the model by ID (locked until the commit)
+	id, _ := strconv.ParseUint(ps.ByName("id"), 10, 64)
+	person, err := models.GetPersonForUpdate(req.Context(), tx, id)
 	if err != nil {
-		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error saving person: %s", err.Error()), fmt.Sprintf("error deleting person: %s", err.Error()), http.StatusExpectationFailed, http.StatusExpectationFailed, "")
+		_ = tx.Rollback()
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error getting related person: %s", err.Error()), "unable to restore person", http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	} else if person == nil {
+		_ = tx.Rollback()
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("person not found: %d", id), "person not found", http.StatusNotFound, http.StatusNotFound, tracing.ErrorData(req.Context()))
 		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
 		return
 	}
 
-	// Commit
-	err = tx.Commit()
+	// Not deleted?
+	if !person.IsDeleted.Bool {
+		_ = tx.Rollback()
+		_ = apirouter.ReturnJSONEncode(w, http.StatusOK, json.NewEncoder(w), person, models.PersonAllFields)
+		return
+	}
+
+	// Restore and commit
+	if _, err = person.Restore(req.Context(), tx); err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
 		_ = tx.Rollback()
-		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error in commit: %s", err.Error()), "error deleting person", http.StatusExpectationFailed, http.StatusExpectationFailed, "")
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error restoring person: %s", err.Error()), fmt.Sprintf("error restoring person: %s", err.Error()), http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
 		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
 		return
 	}
@@ -205,3 +318,48 @@ func deletePerson(w http.ResponseWriter, req *http.Request, _ httprouter.Params)
 	// This should not fail on the encode
 	_ = apirouter.ReturnJSONEncode(w, http.StatusOK, json.NewEncoder(w), person, models.PersonAllFields)
 }
+
+// purgePerson removes a deleted record for good
+func purgePerson(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+
+	// Start a new transaction
+	tx, _, err := database.NewTx(config.DatabaseDefaultTxTimeout)
+	if err != nil {
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error creating tx: %s", err.Error()), "error purging person", http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	}
+
+	// Get the model by ID (locked until the commit)
+	id, _ := strconv.ParseUint(ps.ByName("id"), 10, 64)
+	person, err := models.GetPersonForUpdate(req.Context(), tx, id)
+	if err != nil {
+		_ = tx.Rollback()
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error getting related person: %s", err.Error()), "unable to purge person", http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	} else if person == nil {
+		_ = tx.Rollback()
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("person not found: %d", id), "person not found", http.StatusNotFound, http.StatusNotFound, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	} else if !person.IsDeleted.Bool {
+		_ = tx.Rollback()
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("person is not deleted: %d", id), "only a deleted person can be purged", http.StatusBadRequest, http.StatusBadRequest, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	}
+
+	// Purge and commit
+	if err = person.Purge(req.Context(), tx); err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error purging person: %s", err.Error()), "error purging person", http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	}
+
+	apirouter.ReturnResponse(w, req, http.StatusOK, map[string]interface{}{schema.PersonColumns.ID: id, "purged": true})
+}
@@ -0,0 +1,143 @@
+package persons
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	apirouter "github.com/mrz1836/go-api-router"
+	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-api/models"
+	"github.com/mrz1836/go-api/notifications"
+	"github.com/mrz1836/go-api/tracing"
+)
+
+// unsubscribePage is the data for the unsubscribe page
+type unsubscribePage struct {
+	Category     string
+	Error        string
+	Token        string
+	Unsubscribed bool
+}
+
+// getPreferences returns the person's notification preferences (every category)
+func getPreferences(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+
+	// Get the person
+	person := preferencesPerson(w, req, ps)
+	if person == nil {
+		return
+	}
+
+	// Get the preferences
+	preferences, err := notifications.GetPreferences(req.Context(), person.ID)
+	if err != nil {
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error getting preferences: %s", err.Error()), "unable to get notification preferences", http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	}
+
+	apirouter.ReturnResponse(w, req, http.StatusOK, preferences)
+}
+
+// updatePreference subscribes or unsubscribes the person from a category and sets its channels (category, subscribed, channels)
+func updatePreference(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+
+	// Get the person
+	person := preferencesPerson(w, req, ps)
+	if person == nil {
+		return
+	}
+
+	// Get the parameters
+	params := apirouter.GetParams(req)
+	category := params.GetString("category")
+	subscribed, ok := params.GetBoolOk("subscribed")
+	channels := params.GetStringSlice("channels")
+	if !ok && len(channels) == 0 {
+		apiError := apirouter.ErrorFromRequest(req, "missing field: subscribed or channels", "error updating preferences - missing field: subscribed or channels", http.StatusBadRequest, http.StatusBadRequest, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	}
+
+	// Save the preference
+	var err error
+	if ok {
+		err = notifications.SetPreference(req.Context(), person.ID, category, subscribed, notifications.PreferenceSourceAPI)
+	}
+	if err == nil && len(channels) > 0 {
+		err = notifications.SetChannels(req.Context(), person.ID, category, channels, notifications.PreferenceSourceAPI)
+	}
+	if err != nil {
+		status := http.StatusExpectationFailed
+		if errors.Is(err, notifications.ErrUnknownCategory) || errors.Is(err, notifications.ErrCategoryRequired) ||
+			errors.Is(err, notifications.ErrUnknownChannel) {
+			status = http.StatusBadRequest
+		}
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error saving preference: %s", err.Error()), fmt.Sprintf("error updating preferences: %s", err.Error()), status, status, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	}
+
+	// Return all the preferences
+	getPreferences(w, req, ps)
+}
+
+// preferencesPerson gets the person from the path (writes the error response and returns nil if not found or deleted)
+func preferencesPerson(w http.ResponseWriter, req *http.Request, ps httprouter.Params) *models.Person {
+	id, _ := strconv.ParseUint(ps.ByName("id"), 10, 64)
+	person, err := models.GetPersonByID(req.Context(), id)
+	if err != nil {
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error getting related person: %s", err.Error()), "unable to get person", http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return nil
+	} else if person == nil || person.IsDeleted.Bool {
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("person not found: %d", id), "person not found", http.StatusNotFound, http.StatusNotFound, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return nil
+	}
+	return person
+}
+
+// unsubscribe shows the unsubscribe confirmation (GET) or unsubscribes (POST, including RFC 8058 one-click) using the signed token
+func unsubscribe(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+
+	// Check the token (no login needed)
+	page := &unsubscribePage{Token: apirouter.GetParams(req).GetString("token")}
+	personID, category, err := notifications.ParseUnsubscribeToken(page.Token)
+	page.Category = category
+	status := http.StatusOK
+
+	// Only a POST unsubscribes (link scanners follow GET links)
+	switch {
+	case errors.Is(err, notifications.ErrUnsubscribeTokenExpired):
+		page.Error, status = "This unsubscribe link has expired, please use the link in a newer email.", http.StatusBadRequest
+	case err != nil:
+		page.Error, status = "This unsubscribe link is not valid.", http.StatusBadRequest
+	case req.Method == http.MethodPost:
+		if err = notifications.SetPreference(req.Context(), personID, category, false, notifications.PreferenceSourceUnsubscribe); err != nil {
+			page.Error, status = "We were unable to unsubscribe you, please try again.", http.StatusExpectationFailed
+			if errors.Is(err, notifications.ErrUnknownCategory) || errors.Is(err, notifications.ErrCategoryRequired) {
+				page.Error, status = "These emails can not be unsubscribed.", http.StatusBadRequest
+			}
+		} else {
+			page.Unsubscribed = true
+		}
+	}
+
+	// Load the page (parsed on each request like the other views)
+	view, parseErr := template.ParseFiles(filepath.Join(config.GetCurrentDir(), "..", "static", "views", "unsubscribe.html"))
+	if parseErr != nil {
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error loading unsubscribe page: %s", parseErr.Error()), "unable to load the unsubscribe page", http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_ = view.Execute(w, page)
+}
@@ -0,0 +1,88 @@
+package persons
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	apirouter "github.com/mrz1836/go-api-router"
+	"github.com/mrz1836/go-api/notifications"
+	"github.com/mrz1836/go-api/tracing"
+)
+
+// pushSubscriptions is the response for the person's browser subscriptions
+type pushSubscriptions struct {
+	PublicKey     string                            `json:"public_key"` // VAPID key the browsers subscribe with
+	Subscriptions []*notifications.PushSubscription `json:"subscriptions"`
+}
+
+// listPushSubscriptions returns the person's browser subscriptions and the VAPID public key
+func listPushSubscriptions(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+
+	// Get the person
+	person := preferencesPerson(w, req, ps)
+	if person == nil {
+		return
+	}
+
+	// Get the subscriptions
+	subscriptions, err := notifications.GetPushSubscriptions(req.Context(), person.ID)
+	if err != nil {
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error getting push subscriptions: %s", err.Error()), "unable to get push subscriptions", http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	}
+
+	apirouter.ReturnResponse(w, req, http.StatusOK, &pushSubscriptions{PublicKey: notifications.PushPublicKey(), Subscriptions: subscriptions})
+}
+
+// createPushSubscription saves a browser subscription for the person (endpoint, p256dh, auth)
+func createPushSubscription(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+
+	// Get the person
+	person := preferencesPerson(w, req, ps)
+	if person == nil {
+		return
+	}
+
+	// Save the subscription
+	params := apirouter.GetParams(req)
+	subscription := &notifications.PushSubscription{
+		Auth:     params.GetString("auth"),
+		Endpoint: params.GetString("endpoint"),
+		P256dh:   params.GetString("p256dh"),
+		PersonID: person.ID,
+	}
+	if err := notifications.SavePushSubscription(req.Context(), subscription); err != nil {
+		status := http.StatusExpectationFailed
+		if errors.Is(err, notifications.ErrPushSubscriptionInvalid) {
+			status = http.StatusBadRequest
+		}
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error saving push subscription: %s", err.Error()), fmt.Sprintf("error saving push subscription: %s", err.Error()), status, status, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	}
+
+	apirouter.ReturnResponse(w, req, http.StatusCreated, subscription)
+}
+
+// deletePushSubscription removes a browser subscription from the person (endpoint)
+func deletePushSubscription(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+
+	// Get the person
+	person := preferencesPerson(w, req, ps)
+	if person == nil {
+		return
+	}
+
+	// Remove the subscription
+	endpoint := apirouter.GetParams(req).GetString("endpoint")
+	if err := notifications.DeletePushSubscription(req.Context(), person.ID, endpoint); err != nil {
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error removing push subscription: %s", err.Error()), "unable to remove push subscription", http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	}
+
+	apirouter.ReturnResponse(w, req, http.StatusOK, map[string]interface{}{"endpoint": endpoint, "subscribed": false})
+}
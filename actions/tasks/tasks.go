@@ -0,0 +1,65 @@
+// Package tasks are the admin actions for the task queue (dead-letter list and requeue)
+package tasks
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	apirouter "github.com/mrz1836/go-api-router"
+	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-api/tasks"
+	"github.com/mrz1836/go-api/tracing"
+)
+
+// Dead-letter list limits
+const (
+	defaultLimit = 50
+	maxLimit     = 500
+)
+
+// RegisterRoutes register all the package specific routes
+func RegisterRoutes(router *apirouter.Router) {
+	router.HTTPRouter.GET("/tasks/dead", router.BasicAuth(router.Request(listDeadTasks), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+	router.HTTPRouter.POST("/tasks/:id/requeue", router.BasicAuth(router.Request(requeueTask), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+}
+
+// listDeadTasks returns the dead tasks, newest first (?limit=50)
+func listDeadTasks(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+
+	// Get the limit
+	limit := apirouter.GetParams(req).GetInt("limit")
+	if limit <= 0 || limit > maxLimit {
+		limit = defaultLimit
+	}
+
+	// Get the tasks
+	list, err := tasks.DeadLetters(req.Context(), limit)
+	if err != nil {
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error getting dead tasks: %s", err.Error()), "unable to list dead tasks", http.StatusExpectationFailed, http.StatusExpectationFailed, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	}
+
+	apirouter.ReturnResponse(w, req, http.StatusOK, list)
+}
+
+// requeueTask moves a dead task back to the queue (attempts are reset)
+func requeueTask(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+
+	// Requeue the task
+	id, _ := strconv.ParseUint(ps.ByName("id"), 10, 64)
+	if err := tasks.Requeue(req.Context(), id); err != nil {
+		status := http.StatusExpectationFailed
+		if errors.Is(err, tasks.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("error requeueing task: %s", err.Error()), "unable to requeue task", status, status, tracing.ErrorData(req.Context()))
+		apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+		return
+	}
+
+	apirouter.ReturnResponse(w, req, http.StatusAccepted, map[string]interface{}{"id": id, "status": tasks.StatusPending})
+}
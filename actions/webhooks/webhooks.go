@@ -0,0 +1,237 @@
+// Package webhooks are the admin actions for the outbound webhooks (subscriptions, the delivery log and replays)
+package webhooks
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	apirouter "github.com/mrz1836/go-api-router"
+	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-api/tracing"
+	"github.com/mrz1836/go-api/webhooks"
+)
+
+// Delivery list limits
+const (
+	defaultLimit = 50
+	maxLimit     = 500
+)
+
+// RegisterRoutes register all the package specific routes
+func RegisterRoutes(router *apirouter.Router) {
+	router.HTTPRouter.GET("/webhooks", router.BasicAuth(router.Request(listSubscriptions), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+	router.HTTPRouter.POST("/webhooks", router.BasicAuth(router.Request(createSubscription), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+	router.HTTPRouter.OPTIONS("/webhooks", router.SetCrossOriginHeaders)
+	router.HTTPRouter.GET("/webhooks/:id", router.BasicAuth(router.Request(getSubscription), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+	router.HTTPRouter.PUT("/webhooks/:id", router.BasicAuth(router.Request(updateSubscription), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+	router.HTTPRouter.DELETE("/webhooks/:id", router.BasicAuth(router.Request(deleteSubscription), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+	router.HTTPRouter.POST("/webhooks/:id/secret", router.BasicAuth(router.Request(rotateSecret), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+	router.HTTPRouter.GET("/webhooks/:id/deliveries", router.BasicAuth(router.Request(listDeliveries), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+	router.HTTPRouter.GET("/webhooks/:id/deliveries/:delivery_id", router.BasicAuth(router.Request(getDelivery), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+	router.HTTPRouter.POST("/webhooks/:id/deliveries/:delivery_id/replay", router.BasicAuth(router.Request(replayDelivery), config.Values.BasicAuth.User, config.Values.BasicAuth.Password, config.Values.UnauthorizedError))
+}
+
+// listSubscriptions returns every subscription and the event types (secrets are not returned)
+func listSubscriptions(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	subscriptions, err := webhooks.GetSubscriptions(req.Context())
+	if err != nil {
+		returnWebhookError(w, req, err, "unable to list webhook subscriptions")
+		return
+	}
+	apirouter.ReturnResponse(w, req, http.StatusOK, map[string]interface{}{"event_types": webhooks.EventTypes(), "subscriptions": subscriptions})
+}
+
+// createSubscription saves a new subscription (url, event_types, secret, description, is_active), the secret is only returned here
+func createSubscription(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+
+	// Get the parameters (active by default)
+	params := apirouter.GetParams(req)
+	subscription := &webhooks.Subscription{
+		Description: params.GetString("description"),
+		Events:      params.GetStringSlice("event_types"),
+		IsActive:    true,
+		Secret:      params.GetString("secret"),
+		URL:         params.GetString("url"),
+	}
+	if active, ok := params.GetBoolOk("is_active"); ok {
+		subscription.IsActive = active
+	}
+
+	// Save the subscription
+	if err := webhooks.CreateSubscription(req.Context(), subscription); err != nil {
+		returnWebhookError(w, req, err, fmt.Sprintf("error creating webhook subscription: %s", err.Error()))
+		return
+	}
+
+	apirouter.ReturnResponse(w, req, http.StatusCreated, subscription)
+}
+
+// getSubscription returns a subscription and its latest deliveries
+func getSubscription(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+
+	// Get the subscription
+	id, _ := strconv.ParseUint(ps.ByName("id"), 10, 64)
+	subscription, err := webhooks.GetSubscription(req.Context(), id)
+	if err != nil {
+		returnWebhookError(w, req, err, "unable to get webhook subscription")
+		return
+	}
+
+	// Get the latest deliveries
+	var deliveries []*webhooks.Delivery
+	if deliveries, err = webhooks.GetDeliveries(req.Context(), id, "", defaultLimit); err != nil {
+		returnWebhookError(w, req, err, "unable to get webhook deliveries")
+		return
+	}
+
+	apirouter.ReturnResponse(w, req, http.StatusOK, map[string]interface{}{"deliveries": deliveries, "subscription": subscription})
+}
+
+// updateSubscription changes the url, event types, description or pauses the subscription (only the fields sent)
+func updateSubscription(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+
+	// Get the subscription
+	id, _ := strconv.ParseUint(ps.ByName("id"), 10, 64)
+	subscription, err := webhooks.GetSubscription(req.Context(), id)
+	if err != nil {
+		returnWebhookError(w, req, err, "unable to update webhook subscription")
+		return
+	}
+
+	// Set the values (if sent)
+	params := apirouter.GetParams(req)
+	if description, ok := params.GetStringOk("description"); ok {
+		subscription.Description = description
+	}
+	if events := params.GetStringSlice("event_types"); len(events) > 0 {
+		subscription.Events = events
+	}
+	if active, ok := params.GetBoolOk("is_active"); ok {
+		subscription.IsActive = active
+	}
+	if url := params.GetString("url"); len(url) > 0 {
+		subscription.URL = url
+	}
+
+	// Save the subscription
+	if err = webhooks.UpdateSubscription(req.Context(), subscription); err != nil {
+		returnWebhookError(w, req, err, fmt.Sprintf("error updating webhook subscription: %s", err.Error()))
+		return
+	}
+
+	apirouter.ReturnResponse(w, req, http.StatusOK, subscription)
+}
+
+// deleteSubscription removes a subscription (its pending deliveries are failed)
+func deleteSubscription(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id, _ := strconv.ParseUint(ps.ByName("id"), 10, 64)
+	if err := webhooks.DeleteSubscription(req.Context(), id); err != nil {
+		returnWebhookError(w, req, err, "unable to delete webhook subscription")
+		return
+	}
+	apirouter.ReturnResponse(w, req, http.StatusOK, map[string]interface{}{"deleted": true, "id": id})
+}
+
+// rotateSecret replaces the signing secret of a subscription (the new secret is only returned here)
+func rotateSecret(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id, _ := strconv.ParseUint(ps.ByName("id"), 10, 64)
+	secret, err := webhooks.RotateSecret(req.Context(), id)
+	if err != nil {
+		returnWebhookError(w, req, err, "unable to rotate webhook secret")
+		return
+	}
+	apirouter.ReturnResponse(w, req, http.StatusOK, map[string]interface{}{"id": id, "secret": secret})
+}
+
+// listDeliveries returns the latest deliveries of a subscription (?status=failed&limit=50)
+func listDeliveries(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+
+	// Get the filters
+	params := apirouter.GetParams(req)
+	limit := params.GetInt("limit")
+	if limit <= 0 || limit > maxLimit {
+		limit = defaultLimit
+	}
+
+	// Get the deliveries
+	id, _ := strconv.ParseUint(ps.ByName("id"), 10, 64)
+	if _, err := webhooks.GetSubscription(req.Context(), id); err != nil {
+		returnWebhookError(w, req, err, "unable to get webhook subscription")
+		return
+	}
+	deliveries, err := webhooks.GetDeliveries(req.Context(), id, params.GetString("status"), limit)
+	if err != nil {
+		returnWebhookError(w, req, err, "unable to list webhook deliveries")
+		return
+	}
+
+	apirouter.ReturnResponse(w, req, http.StatusOK, deliveries)
+}
+
+// getDelivery returns a delivery and its attempts (the delivery log)
+func getDelivery(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+
+	// Get the delivery
+	delivery := subscriptionDelivery(w, req, ps)
+	if delivery == nil {
+		return
+	}
+
+	// Get the attempts
+	attempts, err := webhooks.GetAttempts(req.Context(), delivery.ID)
+	if err != nil {
+		returnWebhookError(w, req, err, "unable to get webhook attempts")
+		return
+	}
+
+	apirouter.ReturnResponse(w, req, http.StatusOK, map[string]interface{}{"attempts": attempts, "delivery": delivery})
+}
+
+// replayDelivery queues the event of a delivery again (a new delivery with the same event ID)
+func replayDelivery(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+
+	// Get the delivery
+	delivery := subscriptionDelivery(w, req, ps)
+	if delivery == nil {
+		return
+	}
+
+	// Replay it
+	id, err := webhooks.Replay(req.Context(), delivery.ID)
+	if err != nil {
+		returnWebhookError(w, req, err, "unable to replay webhook delivery")
+		return
+	}
+
+	apirouter.ReturnResponse(w, req, http.StatusAccepted, map[string]interface{}{"event_id": delivery.EventID, "id": id, "status": webhooks.DeliveryStatusPending})
+}
+
+// subscriptionDelivery gets the delivery from the path (writes the error response and returns nil if not found or another subscription's)
+func subscriptionDelivery(w http.ResponseWriter, req *http.Request, ps httprouter.Params) *webhooks.Delivery {
+	subscriptionID, _ := strconv.ParseUint(ps.ByName("id"), 10, 64)
+	id, _ := strconv.ParseUint(ps.ByName("delivery_id"), 10, 64)
+	delivery, err := webhooks.GetDelivery(req.Context(), id)
+	if err == nil && delivery.SubscriptionID != subscriptionID {
+		err = fmt.Errorf("%w: %d", webhooks.ErrDeliveryNotFound, id)
+	}
+	if err != nil {
+		returnWebhookError(w, req, err, "unable to get webhook delivery")
+		return nil
+	}
+	return delivery
+}
+
+// returnWebhookError returns a 404 for unknown subscriptions and deliveries, a 400 for invalid subscriptions, otherwise a 417
+func returnWebhookError(w http.ResponseWriter, req *http.Request, err error, publicMessage string) {
+	status := http.StatusExpectationFailed
+	if errors.Is(err, webhooks.ErrSubscriptionNotFound) || errors.Is(err, webhooks.ErrDeliveryNotFound) {
+		status = http.StatusNotFound
+	} else if errors.Is(err, webhooks.ErrInvalidSubscription) {
+		status = http.StatusBadRequest
+	}
+	apiError := apirouter.ErrorFromRequest(req, err.Error(), publicMessage, status, status, tracing.ErrorData(req.Context()))
+	apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+}
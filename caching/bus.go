@@ -0,0 +1,239 @@
+package caching
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-api/request"
+	"github.com/mrz1836/go-api/tracing"
+	"github.com/mrz1836/go-logger"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Invalidation bus constants
+const (
+	busHealthCheck = time.Minute      // Ping the subscription (detects dead connections)
+	busMaxBackoff  = 30 * time.Second // Maximum wait between reconnect attempts
+	busMinBackoff  = time.Second      // First wait between reconnect attempts
+	busReadTimeout = 70 * time.Second // Longer than the health check (a ping reply is always expected)
+	flushBatchSize = 500              // Keys per SCAN when flushing redis
+)
+
+// instanceID identifies this instance (messages from ourselves are ignored)
+var instanceID = request.NewID()
+
+// message is published on the invalidation channel
+type message struct {
+	Dependencies []string `json:"dependencies,omitempty"`
+	Flush        bool     `json:"flush,omitempty"`
+	Keys         []string `json:"keys,omitempty"`
+	Origin       string   `json:"origin"`
+}
+
+// StartUp flushes the model cache (if configured) and subscribes to the invalidation bus
+func StartUp(ctx context.Context) (err error) {
+
+	// Model cache is disabled
+	if !config.Values.ModelCache.Enabled {
+		logger.Data(2, logger.INFO, "model cache: disabled")
+		return
+	}
+
+	// Flush everything (IE: after a deployment that changed the models)
+	if config.Values.ModelCache.FlushOnStartup {
+		if err = Flush(ctx); err != nil {
+			return fmt.Errorf("error flushing model cache: %w", err)
+		}
+		logger.Data(2, logger.INFO, "model cache: flushed on startup")
+	}
+
+	// Without redis there are no other instances to hear from
+	if !config.Values.CacheEnabled {
+		logger.Data(2, logger.INFO, "model cache: local only (invalidation bus disabled)")
+		return
+	}
+
+	go listen(ctx)
+
+	logger.Data(2, logger.INFO, "model cache: subscribed to invalidation bus",
+		logger.MakeParameter("channel", config.Values.ModelCache.Channel),
+	)
+
+	return
+}
+
+// publish sends the message to all other instances
+func publish(ctx context.Context, m *message) (err error) {
+
+	m.Origin = instanceID
+	var payload []byte
+	if payload, err = json.Marshal(m); err != nil {
+		return
+	}
+
+	ctx, span := tracing.StartSpan(ctx, "redis publish", attribute.String("cache.channel", config.Values.ModelCache.Channel))
+	defer func() {
+		tracing.EndSpan(span, err)
+	}()
+
+	var conn redis.Conn
+	if conn, err = config.Values.Cache.Client.GetConnectionWithContext(ctx); err != nil {
+		return
+	}
+	defer config.Values.Cache.Client.CloseConnection(conn)
+
+	_, err = conn.Do("PUBLISH", config.Values.ModelCache.Channel, payload)
+	return
+}
+
+// listen keeps a subscription open until the context is done (reconnects with backoff)
+func listen(ctx context.Context) {
+
+	backoff := busMinBackoff
+	for {
+
+		// Subscribe and receive until the connection fails
+		start := time.Now()
+		err := subscribe(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		// Messages may have been missed while disconnected
+		flushLocal()
+
+		// Reset the backoff if the subscription was healthy for a while
+		if time.Since(start) > busMaxBackoff {
+			backoff = busMinBackoff
+		}
+		logger.Data(2, logger.ERROR, "model cache: invalidation bus disconnected: "+err.Error(),
+			logger.MakeParameter("retry_in", backoff.String()),
+		)
+
+		// Wait before reconnecting
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > busMaxBackoff {
+			backoff = busMaxBackoff
+		}
+	}
+}
+
+// subscribe receives messages on the channel (always returns an error when the subscription ends)
+func subscribe(ctx context.Context) (err error) {
+
+	// Get a dedicated connection
+	var conn redis.Conn
+	if conn, err = config.Values.Cache.Client.GetConnectionWithContext(ctx); err != nil {
+		return
+	}
+	psc := redis.PubSubConn{Conn: conn}
+	defer func() {
+		_ = psc.Close()
+	}()
+
+	if err = psc.Subscribe(config.Values.ModelCache.Channel); err != nil {
+		return
+	}
+
+	// Ping the connection so a dead connection is noticed (and stop on shutdown)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(busHealthCheck)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if pingErr := psc.Ping(""); pingErr != nil {
+					return
+				}
+			case <-ctx.Done():
+				_ = psc.Unsubscribe()
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// Receive until an error (or unsubscribed)
+	for {
+		switch v := psc.ReceiveWithTimeout(busReadTimeout).(type) {
+		case error:
+			return v
+		case redis.Message:
+			handleMessage(v.Data)
+		case redis.Subscription:
+			if v.Count == 0 {
+				return errors.New("unsubscribed")
+			}
+		}
+	}
+}
+
+// handleMessage evicts the local entries from a message published by another instance
+func handleMessage(data []byte) {
+
+	m := new(message)
+	if err := json.Unmarshal(data, m); err != nil {
+		logger.Data(2, logger.ERROR, "model cache: invalid invalidation message: "+err.Error())
+		return
+	}
+
+	// Ignore our own messages (already removed locally)
+	if m.Origin == instanceID {
+		return
+	}
+
+	if m.Flush {
+		flushLocal()
+		return
+	}
+	removeLocal(m.Keys)
+	invalidateLocal(m.Dependencies)
+}
+
+// flushRedis removes all model values from redis (SCAN, never KEYS)
+func flushRedis(ctx context.Context) (err error) {
+
+	ctx, span := tracing.StartSpan(ctx, "redis flush", attribute.String("cache.key", keyPrefix+"*"))
+	defer func() {
+		tracing.EndSpan(span, err)
+	}()
+
+	var conn redis.Conn
+	if conn, err = config.Values.Cache.Client.GetConnectionWithContext(ctx); err != nil {
+		return
+	}
+	defer config.Values.Cache.Client.CloseConnection(conn)
+
+	// Walk the keyspace and delete each batch
+	cursor := 0
+	for {
+		var values []interface{}
+		if values, err = redis.Values(conn.Do("SCAN", cursor, "MATCH", keyPrefix+"*", "COUNT", flushBatchSize)); err != nil {
+			return
+		}
+		var keys []string
+		if _, err = redis.Scan(values, &cursor, &keys); err != nil {
+			return
+		}
+		if len(keys) > 0 {
+			if _, err = conn.Do("DEL", redis.Args{}.AddFlat(keys)...); err != nil {
+				return
+			}
+		}
+		if cursor == 0 {
+			return
+		}
+	}
+}
@@ -0,0 +1,343 @@
+/*
+Package caching is a two-tier read-through cache for models and responses (local memory store in front of redis)
+
+Values are stored as JSON so every caller gets its own copy, misses are cached (negative caching) and
+concurrent loads of the same key are collapsed into a single load (stampede protection)
+*/
+package caching
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-api/metrics"
+	"github.com/mrz1836/go-api/request"
+	"github.com/mrz1836/go-api/tracing"
+	"github.com/mrz1836/go-cache"
+	"github.com/mrz1836/go-logger"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/singleflight"
+)
+
+// Cache names (used for metrics)
+const (
+	cacheLocal = "local"
+	cacheRedis = "redis"
+	keyPrefix  = "model:"
+)
+
+// loadTimeout is the longest a shared load can run (it is not canceled by the callers)
+const loadTimeout = 30 * time.Second
+
+// notFound is stored for negative caching (JSON null)
+var notFound = []byte("null")
+
+// entry is stored in all tiers (dependencies are kept so the local memory store can be invalidated)
+type entry struct {
+	Dependencies []string        `json:"d,omitempty"`
+	Value        json.RawMessage `json:"v"`
+}
+
+// Options are the TTLs for a cached value
+type Options struct {
+	LocalTTL    time.Duration // TTL in the local memory store
+	NegativeTTL time.Duration // TTL for a miss (not found)
+	TTL         time.Duration // TTL in redis
+}
+
+// DefaultOptions returns the options from the configuration
+func DefaultOptions() Options {
+	return Options{
+		LocalTTL:    config.Values.ModelCache.LocalTTL,
+		NegativeTTL: config.Values.ModelCache.NegativeTTL,
+		TTL:         config.Values.ModelCache.TTL,
+	}
+}
+
+// Loader loads the value from the source (return nil if not found) and the dependencies
+// of the value (invalidating any of the dependencies will remove the value)
+type Loader[T any] func(ctx context.Context) (value *T, dependencies []string, err error)
+
+// group collapses concurrent loads of the same key
+var group singleflight.Group
+
+// local dependency index (dependency -> keys) and generation (flushing bumps the generation) for the local memory store
+var (
+	localDependencies = make(map[string]map[string]struct{})
+	localGeneration   uint64
+	localMutex        sync.Mutex
+)
+
+// Get reads through the local memory store, then redis, then the loader
+func Get[T any](ctx context.Context, key string, options Options, load Loader[T]) (value *T, err error) {
+
+	// Cache is disabled
+	if !config.Values.ModelCache.Enabled {
+		value, _, err = load(ctx)
+		return
+	}
+
+	// Try the caches
+	var data []byte
+	if data, err = getCached(ctx, key); err == nil && data != nil {
+		return decode[T](data)
+	}
+
+	// Load (only once for all concurrent callers of this key)
+	//
+	// The load is shared, so it runs on a detached context (one caller canceling does not fail the others)
+	results := group.DoChan(key, func() (interface{}, error) {
+		loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), loadTimeout)
+		defer cancel()
+		loaded, dependencies, loadErr := load(loadCtx)
+		if loadErr != nil {
+			return nil, loadErr
+		}
+
+		// Encode and store the result (nil is stored as a miss)
+		ttl := options.TTL
+		e := &entry{Dependencies: dependencies, Value: notFound}
+		if loaded != nil {
+			if e.Value, loadErr = json.Marshal(loaded); loadErr != nil {
+				return nil, loadErr
+			}
+		} else {
+			ttl = options.NegativeTTL
+		}
+		encoded, loadErr := json.Marshal(e)
+		if loadErr != nil {
+			return nil, loadErr
+		}
+		setCached(loadCtx, key, encoded, ttl, options.LocalTTL, dependencies)
+		return encoded, nil
+	})
+
+	// Wait for the load (or this caller giving up)
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case result := <-results:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return decode[T](result.Val.([]byte))
+	}
+}
+
+// Lookup returns a cached value without loading it (found is false if not cached)
+func Lookup[T any](ctx context.Context, key string) (value *T, found bool, err error) {
+	var data []byte
+	if data, err = getCached(ctx, key); err != nil || data == nil {
+		return
+	}
+	value, err = decode[T](data)
+	found = err == nil && value != nil
+	return
+}
+
+// Store caches the value in all tiers (invalidating any of the dependencies will remove the value)
+func Store[T any](ctx context.Context, key string, value *T, options Options, dependencies ...string) (err error) {
+	e := &entry{Dependencies: dependencies}
+	if e.Value, err = json.Marshal(value); err != nil {
+		return
+	}
+	var encoded []byte
+	if encoded, err = json.Marshal(e); err != nil {
+		return
+	}
+	setCached(ctx, key, encoded, options.TTL, options.LocalTTL, dependencies)
+	return
+}
+
+// Delete removes the keys from all tiers (and from the local memory store of all instances)
+func Delete(ctx context.Context, keys ...string) (err error) {
+
+	// Local memory store
+	removeLocal(keys)
+
+	// Redis
+	if !config.Values.CacheEnabled {
+		return
+	}
+	ctx, span := tracing.StartSpan(ctx, "redis del")
+	_, err = cache.Delete(ctx, config.Values.Cache.Client, prefixed(keys)...)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		return
+	}
+
+	// Other instances
+	return publish(ctx, &message{Keys: keys})
+}
+
+// Invalidate removes any keys that depend on the dependencies from all tiers (and all instances)
+func Invalidate(ctx context.Context, dependencies ...string) (err error) {
+
+	// Local memory store
+	invalidateLocal(dependencies)
+
+	// Redis
+	if !config.Values.CacheEnabled {
+		return
+	}
+	ctx, span := tracing.StartSpan(ctx, "redis kill dependencies")
+	_, err = cache.KillByDependency(ctx, config.Values.Cache.Client, prefixed(dependencies)...)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		return
+	}
+
+	// Other instances
+	return publish(ctx, &message{Dependencies: dependencies})
+}
+
+// Flush removes all model values from all tiers (and from the local memory store of all instances)
+func Flush(ctx context.Context) (err error) {
+
+	// Local memory store
+	flushLocal()
+
+	// Redis
+	if !config.Values.CacheEnabled {
+		return
+	}
+	if err = flushRedis(ctx); err != nil {
+		return
+	}
+
+	// Other instances
+	return publish(ctx, &message{Flush: true})
+}
+
+// localKey is the key in the local memory store (includes the current generation)
+func localKey(key string) string {
+	return keyPrefix + strconv.FormatUint(atomic.LoadUint64(&localGeneration), 10) + ":" + key
+}
+
+// removeLocal removes the keys from the local memory store
+func removeLocal(keys []string) {
+	for _, key := range keys {
+		config.Values.Cache.MemStore.Remove(localKey(key))
+	}
+}
+
+// invalidateLocal removes any keys that depend on the dependencies from the local memory store
+func invalidateLocal(dependencies []string) {
+	localMutex.Lock()
+	for _, dependency := range dependencies {
+		for key := range localDependencies[dependency] {
+			config.Values.Cache.MemStore.Remove(localKey(key))
+		}
+		delete(localDependencies, dependency)
+	}
+	localMutex.Unlock()
+}
+
+// flushLocal drops all values in the local memory store (old generation entries will expire)
+func flushLocal() {
+	localMutex.Lock()
+	atomic.AddUint64(&localGeneration, 1)
+	localDependencies = make(map[string]map[string]struct{})
+	localMutex.Unlock()
+}
+
+// getCached returns the value from the local memory store or redis (nil if not found)
+func getCached(ctx context.Context, key string) (data []byte, err error) {
+
+	// Local memory store
+	if value, ok := config.Values.Cache.MemStore.Get(localKey(key)); ok {
+		if data, ok = value.([]byte); ok {
+			metrics.CacheHit(cacheLocal)
+			return
+		}
+	}
+	metrics.CacheMiss(cacheLocal)
+
+	// Redis
+	if !config.Values.CacheEnabled {
+		return
+	}
+	ctx, span := tracing.StartSpan(ctx, "redis get", attribute.String("cache.key", keyPrefix+key))
+	var value string
+	value, err = cache.Get(ctx, config.Values.Cache.Client, keyPrefix+key)
+	if errors.Is(err, redis.ErrNil) {
+		err = nil
+	}
+	tracing.EndSpan(span, err)
+	if err != nil || len(value) == 0 {
+		metrics.CacheMiss(cacheRedis)
+		return
+	}
+	metrics.CacheHit(cacheRedis)
+
+	// Keep a local copy
+	data = []byte(value)
+	e := new(entry)
+	if err = json.Unmarshal(data, e); err != nil {
+		return nil, err
+	}
+	_ = config.Values.Cache.MemStore.Set(localKey(key), data, config.Values.ModelCache.LocalTTL)
+	trackLocal(key, e.Dependencies)
+	return
+}
+
+// setCached stores the value in the local memory store and redis
+func setCached(ctx context.Context, key string, data []byte, ttl, localTTL time.Duration, dependencies []string) {
+
+	// Local memory store
+	if ttl < localTTL {
+		localTTL = ttl
+	}
+	_ = config.Values.Cache.MemStore.Set(localKey(key), data, localTTL)
+	trackLocal(key, dependencies)
+
+	// Redis
+	if !config.Values.CacheEnabled {
+		return
+	}
+	ctx, span := tracing.StartSpan(ctx, "redis set", attribute.String("cache.key", keyPrefix+key))
+	err := cache.SetExp(ctx, config.Values.Cache.Client, keyPrefix+key, string(data), ttl, prefixed(dependencies)...)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		logger.Data(2, logger.ERROR, "error setting cache: "+err.Error(), request.LogParameters(ctx)...)
+	}
+}
+
+// trackLocal records the key under each dependency (for local invalidation)
+func trackLocal(key string, dependencies []string) {
+	localMutex.Lock()
+	for _, dependency := range dependencies {
+		if localDependencies[dependency] == nil {
+			localDependencies[dependency] = make(map[string]struct{})
+		}
+		localDependencies[dependency][key] = struct{}{}
+	}
+	localMutex.Unlock()
+}
+
+// prefixed adds the key prefix to all keys (or dependencies)
+func prefixed(keys []string) []string {
+	redisKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		redisKeys = append(redisKeys, keyPrefix+key)
+	}
+	return redisKeys
+}
+
+// decode returns a new value from the entry (nil if a cached miss)
+func decode[T any](data []byte) (value *T, err error) {
+	e := new(entry)
+	if err = json.Unmarshal(data, e); err != nil || string(e.Value) == string(notFound) {
+		return
+	}
+	value = new(T)
+	err = json.Unmarshal(e.Value, value)
+	return
+}
@@ -6,13 +6,21 @@ package main
 import (
 	"context"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/gomodule/redigo/redis"
+	"github.com/mrz1836/go-api/caching"
 	"github.com/mrz1836/go-api/config"
 	"github.com/mrz1836/go-api/database"
+	"github.com/mrz1836/go-api/metrics"
 	"github.com/mrz1836/go-api/models"
 	"github.com/mrz1836/go-api/notifications"
 	"github.com/mrz1836/go-api/router"
+	"github.com/mrz1836/go-api/tasks"
+	"github.com/mrz1836/go-api/tracing"
+	"github.com/mrz1836/go-api/webhooks"
 	"github.com/mrz1836/go-cache"
 	"github.com/mrz1836/go-logger"
 	"github.com/volatiletech/sqlboiler/v4/boil"
@@ -43,8 +51,29 @@ func main() {
 
 		// Close the database on exit
 		database.CloseAllConnections()
+
+		// Flush any remaining spans
+		if err = tracing.Shutdown(context.Background()); err != nil {
+			logger.Data(2, logger.ERROR, "error shutting down tracing: "+err.Error())
+		}
 	}()
 
+	// Serve the metrics on a separate admin port
+	if config.Values.Metrics.Enabled && len(config.Values.Metrics.AdminPort) > 0 {
+		go serveMetrics()
+	}
+
+	// Worker service mode (process tasks, no http server)
+	if config.Values.ServiceMode == config.ServiceModeWorker {
+		runWorkers()
+		return
+	}
+
+	// Process tasks inside the api as well (IE: development)
+	if config.Values.Tasks.Enabled && config.Values.Tasks.RunInAPI {
+		go tasks.Run(context.Background(), tasks.Configuration(config.Values.Tasks))
+	}
+
 	// Load the server
 	logger.Data(2, logger.DEBUG, "starting Go "+config.Values.ServiceMode+" server...", logger.MakeParameter("port", config.Values.ServerPort))
 	srv := &http.Server{
@@ -59,6 +88,15 @@ func main() {
 // loadService loads all the required services and connections
 func loadService() (err error) {
 
+	// Start tracing (exporter, sampler and propagation)
+	if err = tracing.StartUp(
+		context.Background(),
+		tracing.Configuration(config.Values.Tracing),
+		config.Values.Environment,
+	); err != nil {
+		return
+	}
+
 	// Check the environment and use caching if set
 	if len(config.Values.Cache.URL) > 0 {
 
@@ -84,6 +122,16 @@ func loadService() (err error) {
 		logger.Data(2, logger.INFO, "caching: disabled")
 	}
 
+	// Job locks need redis, without it every instance runs every job
+	if config.Values.Jobs.DistributedLocks && !config.Values.CacheEnabled {
+		logger.Data(2, logger.ERROR, "distributed job locks are enabled but the cache is not connected - jobs will run on every instance")
+	}
+
+	// Start the model cache (flush and subscribe to invalidations from other instances)
+	if err = caching.StartUp(context.Background()); err != nil {
+		return
+	}
+
 	// Turn on database debugging
 	if config.Values.DatabaseDebug {
 		boil.DebugMode = config.Values.DatabaseDebug
@@ -101,13 +149,54 @@ func loadService() (err error) {
 		return
 	}
 
+	// Register the connection pool metrics
+	if config.Values.Metrics.Enabled {
+		if err = metrics.RegisterDatabase("read", database.ReadDatabase.GetReadDatabase()); err != nil {
+			return
+		}
+		if err = metrics.RegisterDatabase("write", database.WriteDatabase.GetWriteDatabase()); err != nil {
+			return
+		}
+	}
+
 	// Load notifications
 	if err = notifications.StartUp(); err != nil {
 		return
 	}
 
 	// Load models
-	err = models.StartUp()
+	if err = models.StartUp(); err != nil {
+		return
+	}
+
+	// Subscribe the event consumers (published by the event-relay job)
+	err = webhooks.StartUp()
 
 	return
 }
+
+// serveMetrics starts the admin server for the metrics (not exposed with the api routes)
+func serveMetrics() {
+	mux := http.NewServeMux()
+	mux.Handle("/"+config.MetricsRequestPath, metrics.Handler())
+	logger.Data(2, logger.DEBUG, "starting metrics server...", logger.MakeParameter("port", config.Values.Metrics.AdminPort))
+	srv := &http.Server{
+		Addr:         ":" + config.Values.Metrics.AdminPort,
+		Handler:      mux,
+		ReadTimeout:  config.HTTPRequestReadTimeout,
+		WriteTimeout: config.HTTPRequestWriteTimeout,
+	}
+	if err := srv.ListenAndServe(); err != nil {
+		logger.Data(2, logger.ERROR, "metrics server stopped: "+err.Error())
+	}
+}
+
+// runWorkers processes tasks until the process is stopped (in-flight tasks are finished first)
+func runWorkers() {
+	if !config.Values.Tasks.Enabled {
+		logger.Fatalln("tasks are not enabled for the " + config.ServiceModeWorker + " service mode")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	tasks.Run(ctx, tasks.Configuration(config.Values.Tasks))
+}
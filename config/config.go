@@ -2,16 +2,21 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/OrlovEvgeny/go-mcache"
 	validation "github.com/go-ozzo/ozzo-validation"
 	"github.com/go-ozzo/ozzo-validation/is"
+	"github.com/mrz1836/go-api/tracing"
 	"github.com/mrz1836/go-cache"
 	"github.com/mrz1836/go-logger"
 	"github.com/robfig/cron/v3"
@@ -21,6 +26,17 @@ import (
 // Values global configuration (config.Values)
 var Values appConfig
 
+// CronParser parses all job specs (optional seconds field, descriptors and CRON_TZ)
+var CronParser = cron.NewParser(
+	cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor,
+)
+
+// LocalePattern is a valid (normalized) locale (IE: en, es or es-mx)
+var LocalePattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})?$`)
+
+// PhonePattern is a valid E.164 phone number (IE: +15555550100)
+var PhonePattern = regexp.MustCompile(`^\+[1-9]\d{6,14}$`)
+
 // SchedulerConfig is our cron task wrapper
 type SchedulerConfig struct {
 	CronApp *cron.Cron
@@ -48,6 +64,11 @@ func (s SchedulerConfig) AddJob(name, spec string, cmd func()) (entryID cron.Ent
 	return
 }
 
+// Entry returns the cron entry (schedule, next and previous run) by entryID (zero entry if not found)
+func (s SchedulerConfig) Entry(entryID cron.EntryID) cron.Entry {
+	return s.CronApp.Entry(entryID)
+}
+
 // RemoveJob will remove a cron job by entryID (int)
 func (s SchedulerConfig) RemoveJob(entryID cron.EntryID) (err error) {
 	s.CronApp.Remove(entryID)
@@ -61,42 +82,127 @@ const (
 	EnvironmentKey           = "API_ENVIRONMENT"
 	EnvironmentProduction    = "production"
 	EnvironmentStaging       = "staging"
+	HealthCheckTimeout       = 3 * time.Second
+	HealthLivePath           = "health/live"
+	HealthReadyPath          = "health/ready"
 	HealthRequestPath        = "health"
 	HTTPRequestReadTimeout   = 15 * time.Second
 	HTTPRequestWriteTimeout  = 15 * time.Second
+	MetricsRequestPath       = "metrics"
+	ResponseCachePath        = "cache/responses"
 	ServiceModeAPI           = "api"
+	ServiceModeWorker        = "worker"
+	TaskVisibilityMargin     = 30 * time.Second
 )
 
+// Job names (the keys of the config jobs schedules, the jobs package has the job for each name)
+const (
+	JobEmailOutbox       = "email-outbox"
+	JobEventRelay        = "event-relay"
+	JobExample           = "example-job"
+	JobWebhookDeliveries = "webhook-deliveries"
+)
+
+// exampleUnsubscribeSecrets are the unsubscribe secrets that have been committed (only allowed in development)
+var exampleUnsubscribeSecrets = []string{
+	"DevelopmentOnlySecretForSigningUnsubscribeTokens",
+	"ThisIsALongSecretForSigningUnsubscribeTokens",
+}
+
+// JobNames are all the jobs that can be scheduled
+var JobNames = []string{JobEmailOutbox, JobEventRelay, JobExample, JobWebhookDeliveries}
+
 // appConfig is the configuration values and associated env vars
 type appConfig struct {
-	BasicAuth         basicAuthConfig `json:"basic_auth" mapstructure:"basic_auth"`
-	Cache             cacheConfig     `json:"cache" mapstructure:"cache"`
-	CacheEnabled      bool            `json:"-" mapstructure:"-"`
-	DatabaseDebug     bool            `json:"database_debug" mapstructure:"database_debug"`
-	DatabaseRead      databaseConfig  `json:"database_read" mapstructure:"database_read"`
-	DatabaseWrite     databaseConfig  `json:"database_write" mapstructure:"database_write"`
-	Email             emailConfig     `json:"email" mapstructure:"email"`
-	Environment       string          `json:"environment" mapstructure:"environment"`
-	Scheduler         SchedulerConfig `json:"-" mapstructure:"-"`
-	ServerPort        string          `json:"server_port" mapstructure:"server_port"`
-	ServiceMode       string          `json:"service_mode" mapstructure:"service_mode"`
-	UnauthorizedError string          `json:"unauthorized_error" mapstructure:"unauthorized_error"`
+	AccessLog         accessLogConfig     `json:"access_log" mapstructure:"access_log"`
+	BasicAuth         basicAuthConfig     `json:"basic_auth" mapstructure:"basic_auth"`
+	Cache             cacheConfig         `json:"cache" mapstructure:"cache"`
+	CacheEnabled      bool                `json:"-" mapstructure:"-"`
+	DatabaseDebug     bool                `json:"database_debug" mapstructure:"database_debug"`
+	DatabaseRead      databaseConfig      `json:"database_read" mapstructure:"database_read"`
+	DatabaseWrite     databaseConfig      `json:"database_write" mapstructure:"database_write"`
+	Email             emailConfig         `json:"email" mapstructure:"email"`
+	Environment       string              `json:"environment" mapstructure:"environment"`
+	Events            eventsConfig        `json:"events" mapstructure:"events"`
+	Jobs              jobsConfig          `json:"jobs" mapstructure:"jobs"`
+	Metrics           metricsConfig       `json:"metrics" mapstructure:"metrics"`
+	ModelCache        modelCacheConfig    `json:"model_cache" mapstructure:"model_cache"`
+	Push              pushConfig          `json:"push" mapstructure:"push"`
+	RateLimit         rateLimitConfig     `json:"rate_limit" mapstructure:"rate_limit"`
+	ResponseCache     responseCacheConfig `json:"response_cache" mapstructure:"response_cache"`
+	Scheduler         SchedulerConfig     `json:"-" mapstructure:"-"`
+	ServerPort        string              `json:"server_port" mapstructure:"server_port"`
+	ServiceMode       string              `json:"service_mode" mapstructure:"service_mode"`
+	SMS               smsConfig           `json:"sms" mapstructure:"sms"`
+	Tasks             tasksConfig         `json:"tasks" mapstructure:"tasks"`
+	Tracing           tracingConfig       `json:"tracing" mapstructure:"tracing"`
+	TrustedProxies    []string            `json:"trusted_proxies" mapstructure:"trusted_proxies"` // 10.0.0.0/8 (forwarded ip headers are only used from these ranges, IE: load balancers)
+	UnauthorizedError string              `json:"unauthorized_error" mapstructure:"unauthorized_error"`
+	Webhooks          webhooksConfig      `json:"webhooks" mapstructure:"webhooks"`
 }
 
 // Validate checks the configuration for specific rules
 func (a appConfig) Validate() error {
 	return validation.ValidateStruct(&a,
+		validation.Field(&a.AccessLog),     // Runs validations on the child struct level
 		validation.Field(&a.BasicAuth),     // Runs validations on the child struct level
 		validation.Field(&a.Cache),         // Runs validations on the child struct level
 		validation.Field(&a.DatabaseRead),  // Runs validations on the child struct level
 		validation.Field(&a.DatabaseWrite), // Runs validations on the child struct level
+		validation.Field(&a.Email, validation.By(a.validateEmailProviders), validation.By(a.validateUnsubscribeSecret)),
 		validation.Field(&a.Environment, validation.Required, validation.In(EnvironmentDevelopment, EnvironmentStaging, EnvironmentProduction)),
+		validation.Field(&a.Events), // Runs validations on the child struct level
+		validation.Field(&a.Jobs, validation.By(a.validateJobLocks)),
+		validation.Field(&a.Metrics),       // Runs validations on the child struct level
+		validation.Field(&a.ModelCache),    // Runs validations on the child struct level
+		validation.Field(&a.Push),          // Runs validations on the child struct level
+		validation.Field(&a.RateLimit),     // Runs validations on the child struct level
+		validation.Field(&a.ResponseCache), // Runs validations on the child struct level
 		validation.Field(&a.ServerPort, validation.Required, is.Digit, validation.Length(2, 6)),
-		validation.Field(&a.ServiceMode, validation.Required, validation.In(ServiceModeAPI)),
+		validation.Field(&a.ServiceMode, validation.Required, validation.In(ServiceModeAPI, ServiceModeWorker)),
+		validation.Field(&a.SMS),     // Runs validations on the child struct level
+		validation.Field(&a.Tasks),   // Runs validations on the child struct level
+		validation.Field(&a.Tracing), // Runs validations on the child struct level
+		validation.Field(&a.TrustedProxies, validation.Each(validation.By(validCIDR))),
 		validation.Field(&a.UnauthorizedError, validation.Required, validation.Length(2, 0)),
+		validation.Field(&a.Webhooks), // Runs validations on the child struct level
 	)
 }
 
+// validateEmailProviders checks the local sinks (memory and file) are only used in development
+func (a appConfig) validateEmailProviders(interface{}) error {
+	if a.Environment == EnvironmentDevelopment {
+		return nil
+	}
+	for _, provider := range a.Email.Providers {
+		if provider == EmailProviderFile || provider == EmailProviderMemory {
+			return fmt.Errorf("email provider %s is only allowed in %s", provider, EnvironmentDevelopment)
+		}
+	}
+	return nil
+}
+
+// validateUnsubscribeSecret checks the development secret (or the old example secret) is not used outside development
+func (a appConfig) validateUnsubscribeSecret(interface{}) error {
+	if a.Environment == EnvironmentDevelopment {
+		return nil
+	}
+	for _, example := range exampleUnsubscribeSecrets {
+		if a.Email.Unsubscribe.Secret == example {
+			return fmt.Errorf("unsubscribe secret is an example value, set API_EMAIL__UNSUBSCRIBE__SECRET")
+		}
+	}
+	return nil
+}
+
+// validateJobLocks checks the job locks can be taken (redis is required)
+func (a appConfig) validateJobLocks(interface{}) error {
+	if a.Jobs.DistributedLocks && len(a.Cache.URL) == 0 {
+		return fmt.Errorf("distributed_locks requires the cache url (redis)")
+	}
+	return nil
+}
+
 // databaseConfig is a configuration for a SQL connection
 type databaseConfig struct {
 	Driver             string `json:"driver" mapstructure:"driver"`                             // mysql or postgresql
@@ -148,32 +254,490 @@ func (c cacheConfig) Validate() error {
 
 // emailConfig is a configuration for a email services
 type emailConfig struct {
-	AwsSesAccessID      string `json:"aws_ses_access_id" mapstructure:"aws_ses_access_id"`         // 12345
-	AwsSesSecretKey     string `json:"aws_ses_secret_key" mapstructure:"aws_ses_secret_key"`       // 12345
-	FromDomain          string `json:"from_domain" mapstructure:"from_domain"`                     // example.com
-	FromName            string `json:"from_name" mapstructure:"from_name"`                         // Test User
-	FromUsername        string `json:"from_username" mapstructure:"from_username"`                 // testuser
-	MandrillAPIKey      string `json:"mandrill_api_key" mapstructure:"mandrill_api_key"`           // 12345
-	PostmarkServerToken string `json:"postmark_server_token" mapstructure:"postmark_server_token"` // 12345
-	SMTPHost            string `json:"smtp_host" mapstructure:"smtp_host"`                         // example.com
-	SMTPPassword        string `json:"smtp_password" mapstructure:"smtp_password"`                 // secret123
-	SMTPPort            int    `json:"smtp_port" mapstructure:"smtp_port"`                         // 25
-	SMTPUsername        string `json:"smtp_username" mapstructure:"smtp_username"`                 // testuser
+	AwsSesAccessID      string           `json:"aws_ses_access_id" mapstructure:"aws_ses_access_id"`         // 12345
+	AwsSesSecretKey     string           `json:"aws_ses_secret_key" mapstructure:"aws_ses_secret_key"`       // 12345
+	BreakerCooldown     time.Duration    `json:"breaker_cooldown" mapstructure:"breaker_cooldown"`           // 1m (how long a failing provider is skipped)
+	BreakerThreshold    int              `json:"breaker_threshold" mapstructure:"breaker_threshold"`         // 3 (consecutive transient failures before the provider is skipped)
+	DefaultLocale       string           `json:"default_locale" mapstructure:"default_locale"`               // en (language of the templates without a locale suffix)
+	ExampleEmail        bool             `json:"example_email" mapstructure:"example_email"`                 // false (queue the example email when a person is created)
+	FromDomain          string           `json:"from_domain" mapstructure:"from_domain"`                     // example.com
+	FromName            string           `json:"from_name" mapstructure:"from_name"`                         // Test User
+	FromUsername        string           `json:"from_username" mapstructure:"from_username"`                 // testuser
+	MailboxDir          string           `json:"mailbox_dir" mapstructure:"mailbox_dir"`                     // tmp/mailbox (.eml files for the file provider, relative to the project)
+	MandrillAPIKey      string           `json:"mandrill_api_key" mapstructure:"mandrill_api_key"`           // 12345
+	Outbox              emailOutbox      `json:"outbox" mapstructure:"outbox"`                               // Dispatching queued emails (email_outbox table)
+	PostmarkServerToken string           `json:"postmark_server_token" mapstructure:"postmark_server_token"` // 12345
+	Providers           []string         `json:"providers" mapstructure:"providers"`                         // postmark, smtp (tried in order, fails over on transient errors)
+	SMTPHost            string           `json:"smtp_host" mapstructure:"smtp_host"`                         // example.com
+	SMTPPassword        string           `json:"smtp_password" mapstructure:"smtp_password"`                 // secret123
+	SMTPPort            int              `json:"smtp_port" mapstructure:"smtp_port"`                         // 25
+	SMTPUsername        string           `json:"smtp_username" mapstructure:"smtp_username"`                 // testuser
+	TestRecipients      []string         `json:"test_recipients" mapstructure:"test_recipients"`             // qa@example.com, @example.com (allowlist for test sends)
+	Unsubscribe         emailUnsubscribe `json:"unsubscribe" mapstructure:"unsubscribe"`                     // One-click unsubscribe links (List-Unsubscribe)
+	Webhooks            emailWebhooks    `json:"webhooks" mapstructure:"webhooks"`                           // Delivery events from the providers (bounces, complaints and opens)
 }
 
+// Email providers (used in the email providers config)
+const (
+	EmailProviderAwsSes   = "aws_ses"
+	EmailProviderFile     = "file" // Local sink: writes .eml files (development only)
+	EmailProviderMandrill = "mandrill"
+	EmailProviderMemory   = "memory" // Local sink: in-process mailbox (development only, tests use UseMailbox)
+	EmailProviderPostmark = "postmark"
+	EmailProviderSMTP     = "smtp"
+)
+
 // Validate checks the configuration for specific rules
 func (e emailConfig) Validate() error {
 	return validation.ValidateStruct(&e,
 		validation.Field(&e.AwsSesAccessID, validation.Length(0, 100)),
 		validation.Field(&e.AwsSesSecretKey, validation.Length(0, 100)),
+		validation.Field(&e.BreakerCooldown, validation.Required, validation.Min(time.Second)),
+		validation.Field(&e.BreakerThreshold, validation.Required, validation.Min(1)),
+		validation.Field(&e.DefaultLocale, validation.Required, validation.Match(LocalePattern)),
 		validation.Field(&e.FromDomain, validation.Required, validation.Length(1, 100)),
 		validation.Field(&e.FromName, validation.Required, validation.Length(1, 100)),
 		validation.Field(&e.FromUsername, validation.Required, validation.Length(1, 100)),
+		validation.Field(&e.MailboxDir, validation.Length(0, 255)),
 		validation.Field(&e.MandrillAPIKey, validation.Length(0, 100)),
+		validation.Field(&e.Outbox), // Runs validations on the child struct level
 		validation.Field(&e.PostmarkServerToken, validation.Length(0, 100)),
+		validation.Field(&e.Providers, validation.Required, validation.Each(
+			validation.In(EmailProviderAwsSes, EmailProviderFile, EmailProviderMandrill, EmailProviderMemory, EmailProviderPostmark, EmailProviderSMTP),
+		)),
 		validation.Field(&e.SMTPHost, validation.Length(0, 255)),
 		validation.Field(&e.SMTPPassword, validation.Length(0, 255)),
 		validation.Field(&e.SMTPUsername, validation.Length(0, 255)),
+		validation.Field(&e.TestRecipients, validation.Each(validation.Length(3, 255))),
+		validation.Field(&e.Unsubscribe), // Runs validations on the child struct level
+		validation.Field(&e.Webhooks),    // Runs validations on the child struct level
+	)
+}
+
+// emailOutbox is a configuration for dispatching queued emails (email-outbox job)
+type emailOutbox struct {
+	MaxAttempts       int           `json:"max_attempts" mapstructure:"max_attempts"`             // 8 (attempts before the email is failed)
+	MaxBackoff        time.Duration `json:"max_backoff" mapstructure:"max_backoff"`               // 1h (longest wait between attempts)
+	RetryBackoff      time.Duration `json:"retry_backoff" mapstructure:"retry_backoff"`           // 30s (doubles after each attempt)
+	VisibilityTimeout time.Duration `json:"visibility_timeout" mapstructure:"visibility_timeout"` // 5m (claim expires, then the email is retried - longer than one email on every provider)
+}
+
+// Validate checks the configuration for specific rules
+func (e emailOutbox) Validate() error {
+	return validation.ValidateStruct(&e,
+		validation.Field(&e.MaxAttempts, validation.Required, validation.Min(1)),
+		validation.Field(&e.MaxBackoff, validation.Required, validation.Min(e.RetryBackoff)),
+		validation.Field(&e.RetryBackoff, validation.Required, validation.Min(time.Second)),
+		validation.Field(&e.VisibilityTimeout, validation.Required, validation.Min(time.Second)),
+	)
+}
+
+// emailUnsubscribe is a configuration for the signed unsubscribe links (no login needed)
+type emailUnsubscribe struct {
+	Secret   string        `json:"secret" mapstructure:"secret"`       // Set with API_EMAIL__UNSUBSCRIBE__SECRET (signs the unsubscribe tokens)
+	TokenTTL time.Duration `json:"token_ttl" mapstructure:"token_ttl"` // 2160h (links in older emails stop working)
+	URL      string        `json:"url" mapstructure:"url"`             // https://api.example.com/unsubscribe (public url of the unsubscribe endpoint)
+}
+
+// Validate checks the configuration for specific rules
+func (e emailUnsubscribe) Validate() error {
+	return validation.ValidateStruct(&e,
+		validation.Field(&e.Secret, validation.Required, validation.Length(32, 255)),
+		validation.Field(&e.TokenTTL, validation.Required, validation.Min(24*time.Hour)),
+		validation.Field(&e.URL, validation.Required, is.URL, validation.Length(1, 255)),
+	)
+}
+
+// emailWebhooks is a configuration for verifying the provider webhooks (unset providers are rejected)
+type emailWebhooks struct {
+	MandrillKey      string   `json:"mandrill_key" mapstructure:"mandrill_key"`           // 12345 (webhook key that signs X-Mandrill-Signature)
+	MandrillURL      string   `json:"mandrill_url" mapstructure:"mandrill_url"`           // https://api.example.com/emails/webhooks/mandrill (exact url registered in Mandrill)
+	PostmarkPassword string   `json:"postmark_password" mapstructure:"postmark_password"` // secret123 (basic auth set in the Postmark webhook url)
+	PostmarkUser     string   `json:"postmark_user" mapstructure:"postmark_user"`         // postmark
+	SesTopicArns     []string `json:"ses_topic_arns" mapstructure:"ses_topic_arns"`       // arn:aws:sns:us-east-1:123456789012:ses-events (SNS topics that are accepted)
+}
+
+// Validate checks the configuration for specific rules
+func (e emailWebhooks) Validate() error {
+	return validation.ValidateStruct(&e,
+		validation.Field(&e.MandrillKey, validation.Length(0, 100)),
+		validation.Field(&e.MandrillURL, requiredWhen(len(e.MandrillKey) > 0), is.URL, validation.Length(0, 255)),
+		validation.Field(&e.PostmarkPassword, requiredWhen(len(e.PostmarkUser) > 0), validation.Length(0, 255)),
+		validation.Field(&e.PostmarkUser, validation.Length(0, 100)),
+		validation.Field(&e.SesTopicArns, validation.Each(validation.Match(regexp.MustCompile(`^arn:aws[a-z-]*:sns:[a-z0-9-]+:\d{12}:[\w-]+$`)))),
+	)
+}
+
+// ProviderConfigured returns true if the provider has its credentials set
+func (e emailConfig) ProviderConfigured(name string) bool {
+	switch name {
+	case EmailProviderAwsSes:
+		return len(e.AwsSesAccessID) > 0 && len(e.AwsSesSecretKey) > 0
+	case EmailProviderFile:
+		return len(e.MailboxDir) > 0
+	case EmailProviderMandrill:
+		return len(e.MandrillAPIKey) > 0
+	case EmailProviderMemory:
+		return true
+	case EmailProviderPostmark:
+		return len(e.PostmarkServerToken) > 0
+	case EmailProviderSMTP:
+		return len(e.SMTPHost) > 0
+	}
+	return false
+}
+
+// accessLogConfig is a configuration for the structured (json) access logs
+type accessLogConfig struct {
+	Enabled      bool     `json:"enabled" mapstructure:"enabled"`             // true
+	RedactParams []string `json:"redact_params" mapstructure:"redact_params"` // password, token (query params to redact)
+	SampleRate   float64  `json:"sample_rate" mapstructure:"sample_rate"`     // 1 (all), 0.1 (10%) - server errors are always logged
+}
+
+// Validate checks the configuration for specific rules
+func (l accessLogConfig) Validate() error {
+	return validation.ValidateStruct(&l,
+		validation.Field(&l.SampleRate, validation.Min(0.0), validation.Max(1.0)),
+	)
+}
+
+// eventsConfig is a configuration for relaying the event outbox (event-relay job)
+type eventsConfig struct {
+	BatchSize       int           `json:"batch_size" mapstructure:"batch_size"`               // 100 (events read per batch, per consumer)
+	RedisStream     string        `json:"redis_stream" mapstructure:"redis_stream"`           // events (also publish to this redis stream, empty is off)
+	RedisStreamSize int64         `json:"redis_stream_size" mapstructure:"redis_stream_size"` // 100000 (approximate max length of the stream)
+	Retention       time.Duration `json:"retention" mapstructure:"retention"`                 // 168h (relayed events are removed after, 0 keeps them)
+	SettleDelay     time.Duration `json:"settle_delay" mapstructure:"settle_delay"`           // 30s (how long an ID gap is waited on, longer than the database transaction timeout)
+}
+
+// Validate checks the configuration for specific rules
+func (e eventsConfig) Validate() error {
+	return validation.ValidateStruct(&e,
+		validation.Field(&e.BatchSize, validation.Required, validation.Min(1), validation.Max(1000)),
+		validation.Field(&e.RedisStream, validation.Length(0, 100)),
+		validation.Field(&e.RedisStreamSize, requiredWhen(len(e.RedisStream) > 0), validation.Min(int64(0))),
+		validation.Field(&e.Retention, validation.Min(time.Duration(0))),
+		validation.Field(&e.SettleDelay, validation.Required, validation.By(func(interface{}) error {
+			if e.SettleDelay <= DatabaseDefaultTxTimeout {
+				return fmt.Errorf("must be longer than the database transaction timeout (%s)", DatabaseDefaultTxTimeout)
+			}
+			return nil
+		})),
+	)
+}
+
+// jobsConfig is a configuration for the scheduled jobs
+type jobsConfig struct {
+	DistributedLocks bool                   `json:"distributed_locks" mapstructure:"distributed_locks"` // true (each job runs on one instance per schedule tick, requires the cache url)
+	Schedules        map[string]jobSchedule `json:"schedules" mapstructure:"schedules"`                 // "example-job" (job name, lowercase)
+}
+
+// Validate checks the configuration for specific rules
+func (j jobsConfig) Validate() error {
+	return validation.ValidateStruct(&j,
+		validation.Field(&j.Schedules, validation.By(func(interface{}) error {
+			var unknown []string
+			for name := range j.Schedules {
+				if !knownJob(name) {
+					unknown = append(unknown, name)
+				}
+			}
+			if len(unknown) > 0 {
+				sort.Strings(unknown)
+				return fmt.Errorf("unknown jobs: %s (known jobs: %s)", strings.Join(unknown, ", "), strings.Join(JobNames, ", "))
+			}
+			return nil
+		})),
+	)
+}
+
+// knownJob returns true if the name is in the job names
+func knownJob(name string) bool {
+	for _, known := range JobNames {
+		if known == name {
+			return true
+		}
+	}
+	return false
+}
+
+// jobSchedule is the schedule for a job (wired up by name at boot)
+type jobSchedule struct {
+	Enabled    bool   `json:"enabled" mapstructure:"enabled"`           // true
+	RunOnStart bool   `json:"run_on_start" mapstructure:"run_on_start"` // false (also run once when the service starts)
+	Seconds    bool   `json:"seconds" mapstructure:"seconds"`           // false (spec has a leading seconds field)
+	Spec       string `json:"spec" mapstructure:"spec"`                 // */5 * * * * or @every 5m
+	Timezone   string `json:"timezone" mapstructure:"timezone"`         // America/New_York (default is UTC)
+}
+
+// Validate checks the configuration for specific rules
+func (j jobSchedule) Validate() error {
+	return validation.ValidateStruct(&j,
+		validation.Field(&j.Spec, validation.Required, validation.By(func(interface{}) error {
+			expected := 5
+			if j.Seconds {
+				expected = 6
+			}
+			if fields := len(strings.Fields(j.Spec)); !strings.HasPrefix(j.Spec, "@") && fields != expected {
+				return fmt.Errorf("expected %d fields but found %d (seconds: %t)", expected, fields, j.Seconds)
+			}
+			_, err := CronParser.Parse(j.CronSpec())
+			return err
+		})),
+		validation.Field(&j.Timezone, validation.By(func(interface{}) error {
+			_, err := time.LoadLocation(j.Timezone)
+			return err
+		})),
+	)
+}
+
+// CronSpec returns the spec with the timezone (IE: CRON_TZ=America/New_York */5 * * * *)
+func (j jobSchedule) CronSpec() string {
+	timezone := j.Timezone
+	if len(timezone) == 0 {
+		timezone = "UTC"
+	}
+	return "CRON_TZ=" + timezone + " " + j.Spec
+}
+
+// metricsConfig is a configuration for the Prometheus metrics endpoint
+type metricsConfig struct {
+	AdminPort   string `json:"admin_port" mapstructure:"admin_port"`     // 9090 (serve /metrics on a separate port, empty uses the server port)
+	Enabled     bool   `json:"enabled" mapstructure:"enabled"`           // true
+	RequireAuth bool   `json:"require_auth" mapstructure:"require_auth"` // true (basic auth, only on the server port)
+}
+
+// Validate checks the configuration for specific rules
+func (m metricsConfig) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.AdminPort, is.Digit, validation.Length(2, 6)),
+	)
+}
+
+// modelCacheConfig is a configuration for the read-through model cache (local memory store in front of redis)
+type modelCacheConfig struct {
+	Channel        string        `json:"channel" mapstructure:"channel"`                   // go-api:model-cache (redis pub/sub invalidation channel)
+	Enabled        bool          `json:"enabled" mapstructure:"enabled"`                   // true
+	FlushOnStartup bool          `json:"flush_on_startup" mapstructure:"flush_on_startup"` // false (flush all tiers and instances on boot)
+	LocalTTL       time.Duration `json:"local_ttl" mapstructure:"local_ttl"`               // 30s (local memory store)
+	NegativeTTL    time.Duration `json:"negative_ttl" mapstructure:"negative_ttl"`         // 30s (cached misses)
+	TTL            time.Duration `json:"ttl" mapstructure:"ttl"`                           // 10m (redis)
+}
+
+// Validate checks the configuration for specific rules
+func (m modelCacheConfig) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Channel, requiredWhen(m.Enabled)),
+		validation.Field(&m.LocalTTL, requiredWhen(m.Enabled)),
+		validation.Field(&m.NegativeTTL, requiredWhen(m.Enabled)),
+		validation.Field(&m.TTL, requiredWhen(m.Enabled)),
+	)
+}
+
+// Notification channel providers (empty disables the channel)
+const (
+	ChannelProviderFake = "fake" // Records the messages in-process (development and tests)
+	PushProviderVapid   = "vapid"
+	SMSProviderTwilio   = "twilio"
+)
+
+// pushConfig is a configuration for web push notifications (VAPID)
+type pushConfig struct {
+	Provider        string        `json:"provider" mapstructure:"provider"`                   // vapid, fake (empty disables push)
+	Subject         string        `json:"subject" mapstructure:"subject"`                     // mailto:support@example.com (contact for the push services)
+	TTL             time.Duration `json:"ttl" mapstructure:"ttl"`                             // 24h (how long the push service keeps an undelivered message)
+	VapidPrivateKey string        `json:"vapid_private_key" mapstructure:"vapid_private_key"` // base64url P-256 private key (signs the VAPID tokens)
+	VapidPublicKey  string        `json:"vapid_public_key" mapstructure:"vapid_public_key"`   // base64url P-256 public key (uncompressed, used by the browsers to subscribe)
+}
+
+// Validate checks the configuration for specific rules
+func (p pushConfig) Validate() error {
+	vapid := p.Provider == PushProviderVapid
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.Provider, validation.In(ChannelProviderFake, PushProviderVapid)),
+		validation.Field(&p.Subject, requiredWhen(vapid), validation.Match(regexp.MustCompile(`^(mailto:|https://)`)), validation.Length(0, 255)),
+		validation.Field(&p.TTL, requiredWhen(vapid), validation.Min(time.Duration(0))),
+		validation.Field(&p.VapidPrivateKey, requiredWhen(vapid), validation.Length(0, 100)),
+		validation.Field(&p.VapidPublicKey, requiredWhen(vapid), validation.Length(0, 100)),
+	)
+}
+
+// rateLimitConfig is a configuration for rate limiting (per ip, per api key and per route)
+type rateLimitConfig struct {
+	APIKey       rateLimitRule            `json:"api_key" mapstructure:"api_key"`               // Per api key (all routes)
+	APIKeyHeader string                   `json:"api_key_header" mapstructure:"api_key_header"` // X-API-Key (falls back to the basic auth user)
+	APIKeys      []string                 `json:"api_keys" mapstructure:"api_keys"`             // Known api keys, the header is ignored for any other value (env: API_RATE_LIMIT__API_KEYS, comma separated)
+	Enabled      bool                     `json:"enabled" mapstructure:"enabled"`               // true
+	IP           rateLimitRule            `json:"ip" mapstructure:"ip"`                         // Per ip address (all routes)
+	Routes       map[string]rateLimitRule `json:"routes" mapstructure:"routes"`                 // "post /persons" (method and route template, lowercase)
+}
+
+// Validate checks the configuration for specific rules
+func (r rateLimitConfig) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.APIKey),
+		validation.Field(&r.APIKeyHeader, validation.Length(0, 100)),
+		validation.Field(&r.APIKeys, validation.Each(validation.Length(16, 255))),
+		validation.Field(&r.IP),
+		validation.Field(&r.Routes),
+	)
+}
+
+// rateLimitRule is the limit of requests per period (converted into ratelimit.Rule)
+type rateLimitRule struct {
+	Limit  int `json:"limit" mapstructure:"limit"`   // 10
+	Period int `json:"period" mapstructure:"period"` // 60 (seconds)
+}
+
+// Validate checks the configuration for specific rules
+func (r rateLimitRule) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.Limit, validation.Min(0)),
+		validation.Field(&r.Period, validation.Min(0)),
+	)
+}
+
+// responseCacheConfig is a configuration for caching GET responses (opt-in per route)
+type responseCacheConfig struct {
+	Enabled  bool                         `json:"enabled" mapstructure:"enabled"`     // true
+	LocalTTL time.Duration                `json:"local_ttl" mapstructure:"local_ttl"` // 10s (local memory store)
+	Routes   map[string]responseCacheRule `json:"routes" mapstructure:"routes"`       // "get /persons" (method and route template, lowercase)
+}
+
+// Validate checks the configuration for specific rules
+func (r responseCacheConfig) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.LocalTTL, requiredWhen(r.Enabled)),
+		validation.Field(&r.Routes, validation.By(func(value interface{}) error {
+			for route := range r.Routes {
+				if !strings.HasPrefix(route, "get ") {
+					return fmt.Errorf("only get routes can be cached: %s", route)
+				}
+			}
+			return nil
+		})),
+	)
+}
+
+// responseCacheRule is how a route is cached
+//
+// DO NOT CHANGE ORDER - Converted into responsecache.Rule
+type responseCacheRule struct {
+	Tags            []string      `json:"tags" mapstructure:"tags"`                           // persons (purged when any person changes)
+	TTL             time.Duration `json:"ttl" mapstructure:"ttl"`                             // 1m
+	VaryByPrincipal bool          `json:"vary_by_principal" mapstructure:"vary_by_principal"` // true (separate entries per authenticated user, otherwise only anonymous requests are cached)
+}
+
+// Validate checks the configuration for specific rules
+func (r responseCacheRule) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.TTL, validation.Required),
+	)
+}
+
+// smsConfig is a configuration for text messages (Twilio-style HTTP API)
+type smsConfig struct {
+	Provider         string `json:"provider" mapstructure:"provider"`                     // twilio, fake (empty disables sms)
+	TwilioAccountSID string `json:"twilio_account_sid" mapstructure:"twilio_account_sid"` // AC12345
+	TwilioAuthToken  string `json:"twilio_auth_token" mapstructure:"twilio_auth_token"`   // 12345
+	TwilioFrom       string `json:"twilio_from" mapstructure:"twilio_from"`               // +15555550100 (sending number)
+	TwilioURL        string `json:"twilio_url" mapstructure:"twilio_url"`                 // https://api.twilio.com (or a compatible API)
+}
+
+// Validate checks the configuration for specific rules
+func (s smsConfig) Validate() error {
+	twilio := s.Provider == SMSProviderTwilio
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Provider, validation.In(ChannelProviderFake, SMSProviderTwilio)),
+		validation.Field(&s.TwilioAccountSID, requiredWhen(twilio), validation.Length(0, 100)),
+		validation.Field(&s.TwilioAuthToken, requiredWhen(twilio), validation.Length(0, 100)),
+		validation.Field(&s.TwilioFrom, requiredWhen(twilio), validation.Match(PhonePattern)),
+		validation.Field(&s.TwilioURL, requiredWhen(twilio), is.URL, validation.Length(0, 255)),
+	)
+}
+
+// tasksConfig is a configuration for the durable task queue and the workers
+//
+// DO NOT CHANGE ORDER - Converted into tasks.Configuration
+type tasksConfig struct {
+	Enabled           bool          `json:"enabled" mapstructure:"enabled"`                       // true
+	MaxBackoff        time.Duration `json:"max_backoff" mapstructure:"max_backoff"`               // 1h (longest wait between attempts)
+	PollInterval      time.Duration `json:"poll_interval" mapstructure:"poll_interval"`           // 1s (wait when the queue is empty)
+	RetryBackoff      time.Duration `json:"retry_backoff" mapstructure:"retry_backoff"`           // 10s (doubles after each attempt)
+	RunInAPI          bool          `json:"run_in_api" mapstructure:"run_in_api"`                 // false (also run workers in the api service mode)
+	Timeout           time.Duration `json:"timeout" mapstructure:"timeout"`                       // 4m (handler timeout)
+	VisibilityTimeout time.Duration `json:"visibility_timeout" mapstructure:"visibility_timeout"` // 5m (claim length, then retried by another worker - longer than the timeout plus a margin)
+	Workers           int           `json:"workers" mapstructure:"workers"`                       // 5 (worker pool size)
+}
+
+// Validate checks the configuration for specific rules
+func (t tasksConfig) Validate() error {
+	return validation.ValidateStruct(&t,
+		validation.Field(&t.MaxBackoff, requiredWhen(t.Enabled), validation.Min(t.RetryBackoff)),
+		validation.Field(&t.PollInterval, requiredWhen(t.Enabled)),
+		validation.Field(&t.RetryBackoff, requiredWhen(t.Enabled)),
+		validation.Field(&t.Timeout, requiredWhen(t.Enabled), validation.Min(time.Duration(0))),
+		validation.Field(&t.VisibilityTimeout, requiredWhen(t.Enabled), validation.By(func(interface{}) error {
+			if t.Enabled && t.VisibilityTimeout <= t.Timeout+TaskVisibilityMargin {
+				return fmt.Errorf("must be longer than the timeout plus %s", TaskVisibilityMargin)
+			}
+			return nil
+		})),
+		validation.Field(&t.Workers, requiredWhen(t.Enabled), validation.Min(0), validation.Max(100)),
+	)
+}
+
+// tracingConfig is a configuration for OpenTelemetry tracing
+//
+// DO NOT CHANGE ORDER - Converted into tracing.Configuration
+type tracingConfig struct {
+	Enabled     bool    `json:"enabled" mapstructure:"enabled"`           // true
+	Endpoint    string  `json:"endpoint" mapstructure:"endpoint"`         // localhost:4318 (otlp http)
+	Exporter    string  `json:"exporter" mapstructure:"exporter"`         // otlp, stdout, file or none
+	FilePath    string  `json:"file_path" mapstructure:"file_path"`       // traces.json (file exporter)
+	Insecure    bool    `json:"insecure" mapstructure:"insecure"`         // true (no TLS for the otlp endpoint)
+	Sampler     string  `json:"sampler" mapstructure:"sampler"`           // always, never or ratio
+	SampleRatio float64 `json:"sample_ratio" mapstructure:"sample_ratio"` // 0.1 (ratio sampler)
+	ServiceName string  `json:"service_name" mapstructure:"service_name"` // go-api
+}
+
+// Validate checks the configuration for specific rules
+func (t tracingConfig) Validate() error {
+	return validation.ValidateStruct(&t,
+		validation.Field(&t.Endpoint, requiredWhen(t.Enabled && t.Exporter == tracing.ExporterOTLP), validation.Length(0, 250)),
+		validation.Field(&t.Exporter, validation.In(tracing.ExporterOTLP, tracing.ExporterStdout, tracing.ExporterFile, tracing.ExporterNone)),
+		validation.Field(&t.FilePath, requiredWhen(t.Enabled && t.Exporter == tracing.ExporterFile), validation.Length(0, 250)),
+		validation.Field(&t.Sampler, validation.In(tracing.SamplerAlways, tracing.SamplerNever, tracing.SamplerRatio)),
+		validation.Field(&t.SampleRatio, validation.Min(0.0), validation.Max(1.0)),
+		validation.Field(&t.ServiceName, requiredWhen(t.Enabled), validation.Length(0, 100)),
+	)
+}
+
+// webhooksConfig is a configuration for delivering the outbound webhooks (webhook-deliveries job)
+type webhooksConfig struct {
+	AllowInsecure     bool          `json:"allow_insecure" mapstructure:"allow_insecure"`         // false (allow http and private addresses, development only)
+	BatchSize         int           `json:"batch_size" mapstructure:"batch_size"`                 // 25 (deliveries claimed per batch)
+	MaxAttempts       int           `json:"max_attempts" mapstructure:"max_attempts"`             // 10 (then the delivery is failed)
+	MaxBackoff        time.Duration `json:"max_backoff" mapstructure:"max_backoff"`               // 6h (longest wait between attempts)
+	RetryBackoff      time.Duration `json:"retry_backoff" mapstructure:"retry_backoff"`           // 30s (doubles after each attempt)
+	Timeout           time.Duration `json:"timeout" mapstructure:"timeout"`                       // 10s (how long the endpoint has to respond)
+	VisibilityTimeout time.Duration `json:"visibility_timeout" mapstructure:"visibility_timeout"` // 5m (claim expires, then the delivery is retried, at least batch_size x timeout)
+}
+
+// Validate checks the configuration for specific rules
+func (w webhooksConfig) Validate() error {
+	return validation.ValidateStruct(&w,
+		validation.Field(&w.BatchSize, validation.Required, validation.Min(1)),
+		validation.Field(&w.MaxAttempts, validation.Required, validation.Min(1)),
+		validation.Field(&w.MaxBackoff, validation.Required, validation.Min(w.RetryBackoff)),
+		validation.Field(&w.RetryBackoff, validation.Required, validation.Min(time.Second)),
+		validation.Field(&w.Timeout, validation.Required, validation.Min(time.Second)),
+		validation.Field(&w.VisibilityTimeout, validation.Required, validation.By(func(interface{}) error {
+			if batch := time.Duration(w.BatchSize) * w.Timeout; w.VisibilityTimeout < batch {
+				return fmt.Errorf("must be at least batch_size x timeout (%s), the deliveries in a batch are sent one at a time", batch)
+			}
+			return nil
+		})),
 	)
 }
 
@@ -191,6 +755,22 @@ func (b basicAuthConfig) Validate() error {
 	)
 }
 
+// validCIDR checks that the value is an ip range (IE: 10.0.0.0/8)
+func validCIDR(value interface{}) error {
+	if _, _, err := net.ParseCIDR(value.(string)); err != nil {
+		return errors.New("must be a valid CIDR range")
+	}
+	return nil
+}
+
+// requiredWhen returns the required rule only if the condition is met
+func requiredWhen(condition bool) validation.Rule {
+	if condition {
+		return validation.Required
+	}
+	return validation.By(func(interface{}) error { return nil })
+}
+
 // Load all environment variables
 func Load() (err error) {
 
@@ -237,13 +817,13 @@ func Load() (err error) {
 	}
 
 	// Check service mode
-	if Values.ServiceMode != ServiceModeAPI {
+	if Values.ServiceMode != ServiceModeAPI && Values.ServiceMode != ServiceModeWorker {
 		logger.Data(2, logger.ERROR, "invalid value for service mode")
 		logger.Fatalln("exiting...")
 	}
 
-	// Load the scheduler and start
-	Values.Scheduler.CronApp = cron.New()
+	// Load the scheduler and start (a job that is still running skips the next tick)
+	Values.Scheduler.CronApp = cron.New(cron.WithParser(CronParser), cron.WithChain(cron.SkipIfStillRunning(cron.DefaultLogger)))
 	Values.Scheduler.CronApp.Start()
 
 	// Load the in-memory cache store
@@ -33,8 +33,6 @@ type APIDatabase struct {
 	throttleQueue  chan struct{}
 	statements     map[uint32]*sql.Stmt
 	statementMutex *sync.RWMutex
-	worker         chan func()
-	waitGroup      *sync.WaitGroup
 	//	dB      *sql.DB // same as super struct; I am read only but cheap.  Please use me when possible.
 	// throttleCount int32  // uncomment when adding query weight
 }
@@ -65,13 +63,10 @@ func SetConfiguration(conf Configuration) {
 
 // NewAPIDatabase creates a new database connection
 func NewAPIDatabase(read, write *sql.DB) *APIDatabase {
-	databaseQueue := &APIDatabase{read, write, nil, nil, nil, nil, nil}
+	databaseQueue := &APIDatabase{read, write, nil, nil, nil}
 	databaseQueue.throttleQueue = make(chan struct{}, 10)
 	databaseQueue.statements = make(map[uint32]*sql.Stmt, 30)
-	databaseQueue.worker = make(chan func(), 10000)
 	databaseQueue.statementMutex = new(sync.RWMutex)
-	databaseQueue.waitGroup = new(sync.WaitGroup)
-	go databaseQueue.startWorker()
 	return databaseQueue
 }
 
@@ -125,40 +120,51 @@ func OpenConnection() (err error) {
 
 // CloseAllConnections closes the current database connections
 func CloseAllConnections() {
-	WriteDatabase.waitGroup.Wait()
 	WriteDatabase.Close()
 	WriteDatabase = nil
-	ReadDatabase.waitGroup.Wait()
 	ReadDatabase.Close()
 	ReadDatabase = nil
 }
 
 // NewTx creates a new TX
-func NewTx(timeout time.Duration) (tx *sql.Tx, cancelMethod context.CancelFunc, err error) {
+func NewTx(timeout time.Duration) (tx *Tx, cancelMethod context.CancelFunc, err error) {
 	var ctx context.Context
 	ctx, cancelMethod = context.WithTimeout(context.Background(), timeout)
-	tx, err = WriteDatabase.BeginTx(ctx, nil)
+	var sqlTx *sql.Tx
+	if sqlTx, err = WriteDatabase.BeginTx(ctx, nil); err != nil {
+		return
+	}
+	tx = &Tx{Tx: sqlTx}
 	return
 }
 
-// startWorker starts a worker for the Throttled Query
-func (d *APIDatabase) startWorker() {
-	for handle := range d.worker {
-		handle()
-		d.waitGroup.Done()
-	}
+// Tx is a transaction that runs the after commit functions once it is committed (IE: cache invalidation)
+type Tx struct {
+	*sql.Tx
+	afterCommit []func()
+	mutex       sync.Mutex
 }
 
-// StopWorker will wait for the queue to empty and then shutdown the worker
-func (d *APIDatabase) StopWorker() {
-	d.waitGroup.Wait()
-	close(d.worker)
+// AfterCommit adds a function to run after the commit (dropped on a rollback)
+func (t *Tx) AfterCommit(fn func()) {
+	t.mutex.Lock()
+	t.afterCommit = append(t.afterCommit, fn)
+	t.mutex.Unlock()
 }
 
-// Enque adds a worker
-func (d *APIDatabase) Enque(handle func()) {
-	d.waitGroup.Add(1)
-	d.worker <- handle
+// Commit commits the transaction and then runs the after commit functions (in order)
+func (t *Tx) Commit() error {
+	if err := t.Tx.Commit(); err != nil {
+		return err
+	}
+	t.mutex.Lock()
+	afterCommit := t.afterCommit
+	t.afterCommit = nil
+	t.mutex.Unlock()
+	for _, fn := range afterCommit {
+		fn()
+	}
+	return nil
 }
 
 // Close both or any connections
@@ -190,9 +196,9 @@ func openDatabaseConnection(databaseAddress, databaseName, databaseUser, databas
 	// Switch on the drivers supported
 	switch driver {
 	case MySQLDriver:
-		db, err = sql.Open(driver, databaseUser+":"+databasePassword+"@tcp("+databaseAddress+")/"+databaseName+"?parseTime=true")
+		db, err = openTraced(driver, driver, databaseUser+":"+databasePassword+"@tcp("+databaseAddress+")/"+databaseName+"?parseTime=true")
 	case PostgreSQLDriver:
-		db, err = sql.Open("pgx", fmt.Sprintf("postgres://%s:%s@%s/%s", databaseUser, databasePassword, databaseAddress, databaseName))
+		db, err = openTraced(driver, "pgx", fmt.Sprintf("postgres://%s:%s@%s/%s", databaseUser, databasePassword, databaseAddress, databaseName))
 	default:
 		logger.Data(2, logger.ERROR, fmt.Sprintf("unknown driver specified: %s", driver))
 		return
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE `job_runs` (
+   `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT 'ID of the record',
+   `job_name` varchar(100) NOT NULL COMMENT 'Name of the scheduled job',
+   `status` varchar(20) NOT NULL DEFAULT 'running' COMMENT 'running, success, error, panic or timeout',
+   `attempts` int(5) unsigned NOT NULL DEFAULT 0 COMMENT 'Number of attempts (including retries)',
+   `error` text NOT NULL COMMENT 'Last error of the run (if any)',
+   `owner` varchar(255) NOT NULL DEFAULT '' COMMENT 'Instance that ran the job (lock owner)',
+   `started_at` timestamp(3) NOT NULL DEFAULT current_timestamp(3) COMMENT 'Time the run started',
+   `ended_at` timestamp(3) NULL DEFAULT NULL COMMENT 'Time the run ended',
+   PRIMARY KEY `job_runs_pkey` (`id`),
+   KEY `job_name_started_at` (`job_name`, `started_at`),
+   KEY `status` (`status`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='History of scheduled job runs';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE `job_runs`;
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE `tasks` (
+   `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT 'ID of the record',
+   `type` varchar(100) NOT NULL COMMENT 'Type of task (registered handler)',
+   `payload` json NOT NULL COMMENT 'Typed payload for the handler',
+   `status` varchar(20) NOT NULL DEFAULT 'pending' COMMENT 'pending, running or dead',
+   `attempts` int(5) unsigned NOT NULL DEFAULT 0 COMMENT 'Number of attempts so far',
+   `max_attempts` int(5) unsigned NOT NULL DEFAULT 1 COMMENT 'Attempts before the task is dead (dead-letter)',
+   `run_at` timestamp(3) NOT NULL DEFAULT current_timestamp(3) COMMENT 'Time the task is due (delayed, scheduled or retry)',
+   `locked_by` varchar(255) NOT NULL DEFAULT '' COMMENT 'Worker that claimed the task',
+   `locked_until` timestamp(3) NULL DEFAULT NULL COMMENT 'Claim expires (task is retried if the worker died)',
+   `last_error` text NOT NULL COMMENT 'Last error from the handler',
+   `created_at` timestamp NOT NULL DEFAULT current_timestamp() COMMENT 'Time the record was created',
+   `modified_at` timestamp NOT NULL DEFAULT current_timestamp() ON UPDATE current_timestamp() COMMENT 'Time the record was last modified',
+   PRIMARY KEY `tasks_pkey` (`id`),
+   KEY `status_run_at` (`status`, `run_at`),
+   KEY `status_locked_until` (`status`, `locked_until`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='Durable background tasks (claimed with FOR UPDATE SKIP LOCKED)';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE `tasks`;
+-- +goose StatementEnd
@@ -0,0 +1,48 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE `email_outbox` (
+   `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT 'ID of the record',
+   `person_id` bigint(20) unsigned NULL DEFAULT NULL COMMENT 'Person the email is for (if any)',
+   `kind` varchar(100) NOT NULL COMMENT 'Kind of email (IE: person_example)',
+   `message` json NOT NULL COMMENT 'Rendered email (recipients, subject, html and text content)',
+   `status` varchar(20) NOT NULL DEFAULT 'pending' COMMENT 'pending, sending, sent or failed',
+   `attempts` int(5) unsigned NOT NULL DEFAULT 0 COMMENT 'Number of attempts so far',
+   `max_attempts` int(5) unsigned NOT NULL DEFAULT 1 COMMENT 'Attempts before the email is failed',
+   `next_attempt_at` timestamp(3) NOT NULL DEFAULT current_timestamp(3) COMMENT 'Time the email is due (retries back off)',
+   `locked_until` timestamp(3) NULL DEFAULT NULL COMMENT 'Claim expires (email is retried if the dispatcher died)',
+   `provider` varchar(20) NOT NULL DEFAULT '' COMMENT 'Provider that sent the email',
+   `provider_message_id` varchar(255) NOT NULL DEFAULT '' COMMENT 'Message ID from the provider (if reported)',
+   `last_error` text NOT NULL COMMENT 'Last error from sending',
+   `sent_at` timestamp(3) NULL DEFAULT NULL COMMENT 'Time the email was sent',
+   `created_at` timestamp NOT NULL DEFAULT current_timestamp() COMMENT 'Time the record was created',
+   `modified_at` timestamp NOT NULL DEFAULT current_timestamp() ON UPDATE current_timestamp() COMMENT 'Time the record was last modified',
+   PRIMARY KEY `email_outbox_pkey` (`id`),
+   KEY `status_next_attempt_at` (`status`, `next_attempt_at`),
+   KEY `status_locked_until` (`status`, `locked_until`),
+   KEY `person_id` (`person_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='Emails queued in the same transaction as the change that caused them';
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE `email_deliveries` (
+   `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT 'ID of the record',
+   `outbox_id` bigint(20) unsigned NOT NULL COMMENT 'Email in the outbox',
+   `attempt` int(5) unsigned NOT NULL COMMENT 'Attempt number',
+   `status` varchar(20) NOT NULL COMMENT 'sent or failed',
+   `provider` varchar(20) NOT NULL DEFAULT '' COMMENT 'Provider used for the attempt',
+   `provider_message_id` varchar(255) NOT NULL DEFAULT '' COMMENT 'Message ID from the provider (if reported)',
+   `error` text NOT NULL COMMENT 'Error from the attempt (if any)',
+   `created_at` timestamp(3) NOT NULL DEFAULT current_timestamp(3) COMMENT 'Time of the attempt',
+   PRIMARY KEY `email_deliveries_pkey` (`id`),
+   KEY `outbox_id` (`outbox_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='Delivery log of every attempt to send an outbox email';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE `email_deliveries`;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE `email_outbox`;
+-- +goose StatementEnd
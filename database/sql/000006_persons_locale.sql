@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE `persons`
+   ADD COLUMN `locale` varchar(10) NOT NULL DEFAULT 'en' COMMENT 'Preferred language for emails (IE: en, es)' AFTER `email`;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE `persons` DROP COLUMN `locale`;
+-- +goose StatementEnd
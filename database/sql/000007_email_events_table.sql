@@ -0,0 +1,44 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE `email_events` (
+   `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT 'ID of the record',
+   `outbox_id` bigint(20) unsigned NULL DEFAULT NULL COMMENT 'Email in the outbox (matched by the outbox tag or provider message ID)',
+   `person_id` bigint(20) unsigned NULL DEFAULT NULL COMMENT 'Person the email was for (from the outbox, or matched by email)',
+   `provider` varchar(20) NOT NULL COMMENT 'Provider that sent the event (postmark, mandrill or aws_ses)',
+   `provider_event_id` varchar(255) NOT NULL COMMENT 'Unique ID of the event from the provider (webhooks are retried)',
+   `provider_message_id` varchar(255) NOT NULL DEFAULT '' COMMENT 'Message ID from the provider',
+   `event_type` varchar(20) NOT NULL COMMENT 'delivered, opened, clicked, soft_bounce, hard_bounce or complaint',
+   `email` varchar(255) NOT NULL COMMENT 'Recipient of the email',
+   `description` text NOT NULL COMMENT 'Bounce or complaint details (if any)',
+   `payload` json NOT NULL COMMENT 'Event as sent by the provider',
+   `occurred_at` timestamp(3) NOT NULL DEFAULT current_timestamp(3) COMMENT 'Time of the event (from the provider)',
+   `created_at` timestamp(3) NOT NULL DEFAULT current_timestamp(3) COMMENT 'Time the event was received',
+   PRIMARY KEY `email_events_pkey` (`id`),
+   UNIQUE KEY `provider_event_id` (`provider`, `provider_event_id`),
+   KEY `outbox_id` (`outbox_id`),
+   KEY `person_id` (`person_id`),
+   KEY `email` (`email`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='Delivery events from the provider webhooks (bounces, complaints and opens)';
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE `email_suppressions` (
+   `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT 'ID of the record',
+   `email` varchar(255) NOT NULL COMMENT 'Suppressed address (lowercase)',
+   `reason` varchar(20) NOT NULL COMMENT 'hard_bounce or complaint',
+   `provider` varchar(20) NOT NULL DEFAULT '' COMMENT 'Provider that reported the address',
+   `event_id` bigint(20) unsigned NULL DEFAULT NULL COMMENT 'Email event that caused the suppression',
+   `created_at` timestamp NOT NULL DEFAULT current_timestamp() COMMENT 'Time the record was created',
+   PRIMARY KEY `email_suppressions_pkey` (`id`),
+   UNIQUE KEY `email` (`email`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='Addresses that are never sent to (hard bounces and complaints)';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE `email_suppressions`;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE `email_events`;
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE `notification_preferences` (
+   `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT 'ID of the record',
+   `person_id` bigint(20) unsigned NOT NULL COMMENT 'Person the preference is for',
+   `category` varchar(20) NOT NULL COMMENT 'marketing, product or security',
+   `subscribed` tinyint(1) NOT NULL DEFAULT 1 COMMENT 'Flag for if the person receives this category',
+   `source` varchar(20) NOT NULL DEFAULT 'api' COMMENT 'What changed it last (api or unsubscribe)',
+   `created_at` timestamp NOT NULL DEFAULT current_timestamp() COMMENT 'Time the record was created',
+   `modified_at` timestamp NOT NULL DEFAULT current_timestamp() ON UPDATE current_timestamp() COMMENT 'Time the record was last modified',
+   PRIMARY KEY `notification_preferences_pkey` (`id`),
+   UNIQUE KEY `person_id_category` (`person_id`, `category`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='Notification preferences per person and category (no record is the category default)';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE `notification_preferences`;
+-- +goose StatementEnd
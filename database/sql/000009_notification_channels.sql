@@ -0,0 +1,38 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE `persons`
+   ADD COLUMN `phone` varchar(20) NOT NULL DEFAULT '' COMMENT 'Mobile number for sms (E.164, IE: +15555550100)' AFTER `locale`;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE `notification_preferences`
+   ADD COLUMN `channels` varchar(50) NOT NULL DEFAULT '' COMMENT 'Channels for the category (IE: email,push), empty is the category default' AFTER `subscribed`;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE `push_subscriptions` (
+   `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT 'ID of the record',
+   `person_id` bigint(20) unsigned NOT NULL COMMENT 'Person the browser belongs to',
+   `endpoint` varchar(500) NOT NULL COMMENT 'Push service url from the browser subscription',
+   `p256dh` varchar(100) NOT NULL COMMENT 'Browser public key (base64url)',
+   `auth` varchar(50) NOT NULL COMMENT 'Browser auth secret (base64url)',
+   `created_at` timestamp NOT NULL DEFAULT current_timestamp() COMMENT 'Time the record was created',
+   `modified_at` timestamp NOT NULL DEFAULT current_timestamp() ON UPDATE current_timestamp() COMMENT 'Time the record was last modified',
+   PRIMARY KEY `push_subscriptions_pkey` (`id`),
+   UNIQUE KEY `endpoint` (`endpoint`),
+   KEY `person_id` (`person_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='Web push subscriptions (removed when the push service reports them gone)';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE `push_subscriptions`;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE `notification_preferences` DROP COLUMN `channels`;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE `persons` DROP COLUMN `phone`;
+-- +goose StatementEnd
@@ -0,0 +1,68 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE `webhook_subscriptions` (
+   `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT 'ID of the record',
+   `url` varchar(500) NOT NULL COMMENT 'Endpoint that receives the events (https)',
+   `secret` varchar(255) NOT NULL COMMENT 'Signs each delivery (HMAC-SHA256)',
+   `event_types` varchar(255) NOT NULL COMMENT 'Comma separated event types (IE: person.created,person.updated)',
+   `description` varchar(255) NOT NULL DEFAULT '' COMMENT 'Who or what the subscription is for',
+   `is_active` tinyint(1) NOT NULL DEFAULT 1 COMMENT 'Flag for if events are delivered',
+   `created_at` timestamp NOT NULL DEFAULT current_timestamp() COMMENT 'Time the record was created',
+   `modified_at` timestamp NOT NULL DEFAULT current_timestamp() ON UPDATE current_timestamp() COMMENT 'Time the record was last modified',
+   PRIMARY KEY `webhook_subscriptions_pkey` (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='Outbound webhook subscriptions for integrations';
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE `webhook_deliveries` (
+   `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT 'ID of the record',
+   `subscription_id` bigint(20) unsigned NOT NULL COMMENT 'Subscription the event is delivered to',
+   `event_id` varchar(50) NOT NULL COMMENT 'ID of the event (the same for every subscription and replay)',
+   `event_type` varchar(50) NOT NULL COMMENT 'Type of event (IE: person.created)',
+   `payload` json NOT NULL COMMENT 'Body that is signed and sent',
+   `status` varchar(20) NOT NULL DEFAULT 'pending' COMMENT 'pending, sending, delivered or failed',
+   `attempts` int(5) unsigned NOT NULL DEFAULT 0 COMMENT 'Number of attempts so far',
+   `max_attempts` int(5) unsigned NOT NULL DEFAULT 1 COMMENT 'Attempts before the delivery is failed',
+   `next_attempt_at` timestamp(3) NOT NULL DEFAULT current_timestamp(3) COMMENT 'Time the delivery is due (retries back off)',
+   `locked_until` timestamp(3) NULL DEFAULT NULL COMMENT 'Claim expires (delivery is retried if the dispatcher died)',
+   `response_status` int(5) unsigned NOT NULL DEFAULT 0 COMMENT 'Last http status from the endpoint',
+   `last_error` text NOT NULL COMMENT 'Last error from delivering',
+   `delivered_at` timestamp(3) NULL DEFAULT NULL COMMENT 'Time the endpoint accepted the event',
+   `created_at` timestamp NOT NULL DEFAULT current_timestamp() COMMENT 'Time the record was created',
+   `modified_at` timestamp NOT NULL DEFAULT current_timestamp() ON UPDATE current_timestamp() COMMENT 'Time the record was last modified',
+   PRIMARY KEY `webhook_deliveries_pkey` (`id`),
+   KEY `status_next_attempt_at` (`status`, `next_attempt_at`),
+   KEY `status_locked_until` (`status`, `locked_until`),
+   KEY `subscription_id` (`subscription_id`),
+   KEY `event_id` (`event_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='Events queued for each webhook subscription';
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE `webhook_attempts` (
+   `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT 'ID of the record',
+   `delivery_id` bigint(20) unsigned NOT NULL COMMENT 'Delivery that was attempted',
+   `attempt` int(5) unsigned NOT NULL COMMENT 'Attempt number',
+   `status` varchar(20) NOT NULL COMMENT 'delivered or failed',
+   `response_status` int(5) unsigned NOT NULL DEFAULT 0 COMMENT 'Http status from the endpoint (0 if no response)',
+   `response_body` varchar(1000) NOT NULL DEFAULT '' COMMENT 'Start of the response body',
+   `error` text NOT NULL COMMENT 'Error from the attempt (if any)',
+   `duration_ms` int(10) unsigned NOT NULL DEFAULT 0 COMMENT 'Time the endpoint took',
+   `created_at` timestamp(3) NOT NULL DEFAULT current_timestamp(3) COMMENT 'Time of the attempt',
+   PRIMARY KEY `webhook_attempts_pkey` (`id`),
+   KEY `delivery_id` (`delivery_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='Delivery log of every attempt to deliver a webhook';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE `webhook_attempts`;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE `webhook_deliveries`;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE `webhook_subscriptions`;
+-- +goose StatementEnd
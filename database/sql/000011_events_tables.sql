@@ -0,0 +1,38 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE `event_outbox` (
+   `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT 'ID of the record (position for the consumer offsets)',
+   `event_id` varchar(50) NOT NULL COMMENT 'Unique ID of the event (consumers can dedupe)',
+   `event_type` varchar(50) NOT NULL COMMENT 'Type of event (IE: person.created)',
+   `aggregate_type` varchar(50) NOT NULL DEFAULT '' COMMENT 'Type of record that changed (IE: person)',
+   `aggregate_id` bigint(20) unsigned NOT NULL DEFAULT 0 COMMENT 'ID of the record that changed',
+   `payload` json NOT NULL COMMENT 'Typed event (IE: events.PersonCreated)',
+   `request_id` varchar(64) NOT NULL DEFAULT '' COMMENT 'Request that caused the event',
+   `principal` varchar(255) NOT NULL DEFAULT '' COMMENT 'Who caused the event (auth principal or job)',
+   `created_at` timestamp(3) NOT NULL DEFAULT current_timestamp(3) COMMENT 'Time the event was recorded',
+   PRIMARY KEY `event_outbox_pkey` (`id`),
+   UNIQUE KEY `event_id` (`event_id`),
+   KEY `aggregate` (`aggregate_type`, `aggregate_id`),
+   KEY `created_at` (`created_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='Events written in the same transaction as the change (published by the event-relay job)';
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE `event_offsets` (
+   `consumer` varchar(100) NOT NULL COMMENT 'Name of the subscriber (IE: webhooks or redis:events)',
+   `position` bigint(20) unsigned NOT NULL DEFAULT 0 COMMENT 'Last event_outbox.id the consumer handled',
+   `last_error` text NOT NULL COMMENT 'Last error from the consumer (empty once it succeeds)',
+   `created_at` timestamp NOT NULL DEFAULT current_timestamp() COMMENT 'Time the record was created',
+   `modified_at` timestamp NOT NULL DEFAULT current_timestamp() ON UPDATE current_timestamp() COMMENT 'Time the record was last modified',
+   PRIMARY KEY `event_offsets_pkey` (`consumer`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='Position of each event consumer in the outbox (at-least-once delivery)';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE `event_offsets`;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE `event_outbox`;
+-- +goose StatementEnd
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE `person_audit` (
+   `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT 'ID of the record',
+   `person_id` bigint(20) unsigned NOT NULL COMMENT 'Person that changed (kept after a purge)',
+   `action` varchar(20) NOT NULL COMMENT 'insert, update, delete, restore or purge',
+   `actor` varchar(255) NOT NULL DEFAULT '' COMMENT 'Who made the change (auth principal, job or task)',
+   `request_id` varchar(64) NOT NULL DEFAULT '' COMMENT 'Request that made the change',
+   `ip_address` varchar(64) NOT NULL DEFAULT '' COMMENT 'Client IP address of the request',
+   `changes` json NOT NULL COMMENT 'Changed columns (IE: {"email": {"from": "a@example.com", "to": "b@example.com"}})',
+   `created_at` timestamp(3) NOT NULL DEFAULT current_timestamp(3) COMMENT 'Time of the change',
+   PRIMARY KEY `person_audit_pkey` (`id`),
+   KEY `person_id_id` (`person_id`, `id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='Every change to a person (written in the same transaction as the change)';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE `person_audit`;
+-- +goose StatementEnd
@@ -0,0 +1,325 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/mrz1836/go-api/tracing"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// openTraced opens the database with every statement in a child span (db, tx and prepared statements)
+//
+// Query spans end when the rows are closed (so they include reading the rows)
+func openTraced(system, driverName, dataSourceName string) (*sql.DB, error) {
+
+	// Find the registered driver (sql.Open does not connect)
+	db, err := sql.Open(driverName, dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+	base := db.Driver()
+	_ = db.Close()
+
+	// Use the driver's connector (if it has one)
+	var connector driver.Connector
+	if driverContext, ok := base.(driver.DriverContext); ok {
+		if connector, err = driverContext.OpenConnector(dataSourceName); err != nil {
+			return nil, err
+		}
+	} else {
+		connector = &dsnConnector{dataSourceName: dataSourceName, driver: base}
+	}
+	return sql.OpenDB(&tracedConnector{Connector: connector, system: system}), nil
+}
+
+// dsnConnector is the connector for drivers without one
+type dsnConnector struct {
+	dataSourceName string
+	driver         driver.Driver
+}
+
+// Connect opens a connection
+func (c *dsnConnector) Connect(_ context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dataSourceName)
+}
+
+// Driver returns the driver
+func (c *dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// tracedConnector returns traced connections
+type tracedConnector struct {
+	driver.Connector
+	system string // IE: mysql
+}
+
+// Connect opens a traced connection
+func (c *tracedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedConn{Conn: conn, system: c.system}, nil
+}
+
+// tracedConn starts a span for each statement (the optional driver interfaces are passed through)
+type tracedConn struct {
+	driver.Conn
+	system string
+}
+
+// ExecContext executes a statement without preparing it (the driver skips when it needs a prepared statement)
+func (c *tracedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	result, err := execer.ExecContext(ctx, query, args)
+	if errors.Is(err, driver.ErrSkip) {
+		return nil, err
+	}
+	_, span := startQuerySpan(ctx, c.system, "sql exec", query, start)
+	tracing.EndSpan(span, err)
+	return result, err
+}
+
+// QueryContext executes a query without preparing it (the span ends when the rows are closed)
+func (c *tracedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	rows, err := queryer.QueryContext(ctx, query, args)
+	if errors.Is(err, driver.ErrSkip) {
+		return nil, err
+	}
+	_, span := startQuerySpan(ctx, c.system, "sql query", query, start)
+	if err != nil {
+		tracing.EndSpan(span, err)
+		return nil, err
+	}
+	return &tracedRows{Rows: rows, span: span}, nil
+}
+
+// PrepareContext prepares a traced statement
+func (c *tracedConn) PrepareContext(ctx context.Context, query string) (stmt driver.Stmt, err error) {
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tracedStmt{Stmt: stmt, conn: c, query: query}, nil
+}
+
+// Prepare prepares a traced statement
+func (c *tracedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// BeginTx starts a transaction (statements in the transaction use this connection, so they are traced)
+func (c *tracedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin() //nolint:staticcheck // the driver does not support contexts
+}
+
+// Ping checks the connection (if the driver supports it)
+func (c *tracedConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// ResetSession resets the connection before it is reused (if the driver supports it)
+func (c *tracedConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+// IsValid returns false if the connection should be discarded (if the driver supports it)
+func (c *tracedConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+// CheckNamedValue uses the driver's argument conversion (IE: mysql uint64)
+func (c *tracedConn) CheckNamedValue(value *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(value)
+	}
+	return driver.ErrSkip
+}
+
+// tracedStmt starts a span each time the prepared statement is executed
+type tracedStmt struct {
+	driver.Stmt
+	conn  *tracedConn
+	query string
+}
+
+// ExecContext executes the statement
+func (s *tracedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (result driver.Result, err error) {
+	var span trace.Span
+	ctx, span = startQuerySpan(ctx, s.conn.system, "sql exec", s.query, time.Now())
+	if execer, ok := s.Stmt.(driver.StmtExecContext); ok {
+		result, err = execer.ExecContext(ctx, args)
+	} else {
+		result, err = s.Stmt.Exec(namedValues(args)) //nolint:staticcheck // the driver does not support contexts
+	}
+	tracing.EndSpan(span, err)
+	return
+}
+
+// QueryContext executes the query (the span ends when the rows are closed)
+func (s *tracedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (rows driver.Rows, err error) {
+	var span trace.Span
+	ctx, span = startQuerySpan(ctx, s.conn.system, "sql query", s.query, time.Now())
+	if queryer, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		rows, err = queryer.QueryContext(ctx, args)
+	} else {
+		rows, err = s.Stmt.Query(namedValues(args)) //nolint:staticcheck // the driver does not support contexts
+	}
+	if err != nil {
+		tracing.EndSpan(span, err)
+		return nil, err
+	}
+	return &tracedRows{Rows: rows, span: span}, nil
+}
+
+// CheckNamedValue uses the statement's (or the connection's) argument conversion
+func (s *tracedStmt) CheckNamedValue(value *driver.NamedValue) error {
+	if checker, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(value)
+	}
+	return s.conn.CheckNamedValue(value)
+}
+
+// ColumnConverter uses the statement's converter (if any)
+func (s *tracedStmt) ColumnConverter(idx int) driver.ValueConverter {
+	if converter, ok := s.Stmt.(driver.ColumnConverter); ok { //nolint:staticcheck // passed through for older drivers
+		return converter.ColumnConverter(idx)
+	}
+	return driver.DefaultParameterConverter
+}
+
+// tracedRows ends the query span when the rows are closed (errors reading the rows are recorded)
+type tracedRows struct {
+	driver.Rows
+	err  error
+	span trace.Span
+}
+
+// Next reads the next row
+func (r *tracedRows) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+	if err != nil && !errors.Is(err, io.EOF) {
+		r.err = err
+	}
+	return err
+}
+
+// Close closes the rows and ends the span
+func (r *tracedRows) Close() error {
+	err := r.Rows.Close()
+	if r.err == nil {
+		r.err = err
+	}
+	tracing.EndSpan(r.span, r.err)
+	return err
+}
+
+// HasNextResultSet returns true if there is another result set
+func (r *tracedRows) HasNextResultSet() bool {
+	if resultSets, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return resultSets.HasNextResultSet()
+	}
+	return false
+}
+
+// NextResultSet moves to the next result set
+func (r *tracedRows) NextResultSet() error {
+	if resultSets, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return resultSets.NextResultSet()
+	}
+	return io.EOF
+}
+
+// ColumnTypeScanType returns the go type of the column
+func (r *tracedRows) ColumnTypeScanType(index int) reflect.Type {
+	if columns, ok := r.Rows.(driver.RowsColumnTypeScanType); ok {
+		return columns.ColumnTypeScanType(index)
+	}
+	return reflect.TypeOf(new(interface{})).Elem()
+}
+
+// ColumnTypeDatabaseTypeName returns the database type of the column (IE: VARCHAR)
+func (r *tracedRows) ColumnTypeDatabaseTypeName(index int) string {
+	if columns, ok := r.Rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return columns.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+// ColumnTypeLength returns the length of the column (variable length types)
+func (r *tracedRows) ColumnTypeLength(index int) (int64, bool) {
+	if columns, ok := r.Rows.(driver.RowsColumnTypeLength); ok {
+		return columns.ColumnTypeLength(index)
+	}
+	return 0, false
+}
+
+// ColumnTypeNullable returns true if the column can be null
+func (r *tracedRows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	if columns, found := r.Rows.(driver.RowsColumnTypeNullable); found {
+		return columns.ColumnTypeNullable(index)
+	}
+	return false, false
+}
+
+// ColumnTypePrecisionScale returns the precision and scale of the column (decimal types)
+func (r *tracedRows) ColumnTypePrecisionScale(index int) (precision, scale int64, ok bool) {
+	if columns, found := r.Rows.(driver.RowsColumnTypePrecisionScale); found {
+		return columns.ColumnTypePrecisionScale(index)
+	}
+	return 0, 0, false
+}
+
+// namedValues converts the arguments for drivers without context support
+func namedValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	return values
+}
+
+// startQuerySpan starts a client span for a sql statement
+func startQuerySpan(ctx context.Context, system, name, query string, start time.Time) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(start),
+		trace.WithAttributes(
+			semconv.DBSystemKey.String(system),
+			semconv.DBQueryText(query),
+		),
+	)
+}
@@ -0,0 +1,174 @@
+/*
+Package events records typed events in an outbox (in the same transaction as the change) and relays them to the subscribers
+*/
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/mrz1836/go-api/request"
+)
+
+// Event types
+const (
+	TypePersonCreated  = "person.created"
+	TypePersonDeleted  = "person.deleted" // Soft delete (is_deleted)
+	TypePersonPurged   = "person.purged"  // Removed for good
+	TypePersonRestored = "person.restored"
+	TypePersonUpdated  = "person.updated"
+)
+
+// AggregatePerson is the aggregate type of the person events
+const AggregatePerson = "person"
+
+// Event is a typed event (stored as json in the outbox)
+type Event interface {
+	AggregateID() uint64   // ID of the record that changed
+	AggregateType() string // IE: person
+	EventType() string     // IE: person.created
+}
+
+// PersonEvent is the person in every person event
+type PersonEvent struct {
+	Person   json.RawMessage `json:"person,omitempty"` // The person after the change (not set when purged)
+	PersonID uint64          `json:"person_id"`
+}
+
+// AggregateID returns the person ID
+func (e PersonEvent) AggregateID() uint64 {
+	return e.PersonID
+}
+
+// AggregateType returns the person aggregate
+func (e PersonEvent) AggregateType() string {
+	return AggregatePerson
+}
+
+// PersonCreated is when a person is inserted
+type PersonCreated struct {
+	PersonEvent
+}
+
+// EventType returns the event type
+func (e PersonCreated) EventType() string {
+	return TypePersonCreated
+}
+
+// PersonUpdated is when a person's fields are updated
+type PersonUpdated struct {
+	PersonEvent
+	Columns []string `json:"columns,omitempty"` // Columns that were saved
+}
+
+// EventType returns the event type
+func (e PersonUpdated) EventType() string {
+	return TypePersonUpdated
+}
+
+// PersonDeleted is when a person is marked as deleted
+type PersonDeleted struct {
+	PersonEvent
+}
+
+// EventType returns the event type
+func (e PersonDeleted) EventType() string {
+	return TypePersonDeleted
+}
+
+// PersonRestored is when a deleted person is marked as not deleted
+type PersonRestored struct {
+	PersonEvent
+}
+
+// EventType returns the event type
+func (e PersonRestored) EventType() string {
+	return TypePersonRestored
+}
+
+// PersonPurged is when a deleted person is removed for good
+type PersonPurged struct {
+	PersonEvent
+}
+
+// EventType returns the event type
+func (e PersonPurged) EventType() string {
+	return TypePersonPurged
+}
+
+// Envelope is a recorded event (event_outbox table)
+type Envelope struct {
+	AggregateID   uint64          `boil:"aggregate_id" json:"aggregate_id"`
+	AggregateType string          `boil:"aggregate_type" json:"aggregate_type"`
+	CreatedAt     time.Time       `boil:"created_at" json:"created_at"`
+	EventID       string          `boil:"event_id" json:"event_id"` // Unique (consumers can dedupe)
+	ID            uint64          `boil:"id" json:"id"`             // Position in the outbox
+	Payload       json.RawMessage `boil:"payload" json:"payload"`
+	Principal     string          `boil:"principal" json:"principal,omitempty"`
+	RequestID     string          `boil:"request_id" json:"request_id,omitempty"`
+	Type          string          `boil:"event_type" json:"event_type"`
+}
+
+// Decode unmarshals the payload into the typed event (IE: events.PersonCreated)
+func (e *Envelope) Decode(event interface{}) error {
+	return json.Unmarshal(e.Payload, event)
+}
+
+// Context returns the context with the request ID and principal that caused the event (for log correlation)
+func (e *Envelope) Context(ctx context.Context) context.Context {
+	if len(e.RequestID) > 0 {
+		ctx = request.WithID(ctx, e.RequestID)
+	}
+	if len(e.Principal) > 0 {
+		ctx = request.WithPrincipal(ctx, e.Principal)
+	}
+	return ctx
+}
+
+// Record writes the event to the outbox in the transaction (relayed by the event-relay job after the commit)
+func Record(ctx context.Context, tx *sql.Tx, event Event) (envelope *Envelope, err error) {
+
+	// Build the envelope (the request values are kept for the consumers)
+	envelope = &Envelope{
+		AggregateID:   event.AggregateID(),
+		AggregateType: event.AggregateType(),
+		CreatedAt:     time.Now().UTC(),
+		Principal:     request.Principal(ctx),
+		RequestID:     request.ID(ctx),
+		Type:          event.EventType(),
+	}
+	if envelope.EventID, err = newEventID(); err != nil {
+		return nil, err
+	}
+	if envelope.Payload, err = json.Marshal(event); err != nil {
+		return nil, err
+	}
+
+	// Write to the outbox
+	var result sql.Result
+	if result, err = tx.ExecContext(ctx,
+		"INSERT INTO `event_outbox` (`event_id`, `event_type`, `aggregate_type`, `aggregate_id`, `payload`, `request_id`, `principal`, `created_at`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		envelope.EventID, envelope.Type, envelope.AggregateType, envelope.AggregateID, []byte(envelope.Payload), envelope.RequestID, envelope.Principal, envelope.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	var id int64
+	if id, err = result.LastInsertId(); err != nil {
+		return nil, err
+	}
+	envelope.ID = uint64(id)
+	return
+}
+
+// newEventID returns a random event ID (IE: evt_1a2b3c...)
+func newEventID() (string, error) {
+	random := make([]byte, 12)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return "evt_" + hex.EncodeToString(random), nil
+}
@@ -0,0 +1,287 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-api/database"
+	"github.com/mrz1836/go-api/request"
+	"github.com/mrz1836/go-api/tracing"
+	"github.com/mrz1836/go-logger"
+	"github.com/volatiletech/sqlboiler/v4/queries"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// redisConsumerPrefix starts the name of the redis stream consumer (IE: redis:events)
+const redisConsumerPrefix = "redis:"
+
+var (
+	// ErrInvalidConsumer is when the consumer name is missing or taken, or the handler is missing
+	ErrInvalidConsumer = errors.New("event consumer is not valid")
+
+	// ErrOffsetMoved is when another relay moved the consumer's offset (the events are handled there)
+	ErrOffsetMoved = errors.New("event consumer offset was moved by another relay")
+)
+
+// Handler handles an event for a consumer (an error stops the consumer, the event is handled again on the next run)
+//
+// Delivery is at-least-once, handlers should be idempotent (IE: dedupe on the envelope EventID)
+type Handler func(ctx context.Context, envelope *Envelope) error
+
+// consumer is a subscriber with its own offset in the outbox
+type consumer struct {
+	eventTypes []string // Empty is every type
+	handler    Handler
+	name       string
+}
+
+// consumers are the in-process subscribers (in the order they subscribed)
+var consumers struct {
+	sync.RWMutex
+	list []*consumer
+}
+
+// Subscribe registers a consumer for the event types (every type if none are set)
+//
+// The consumer starts at the beginning of the outbox, its offset is kept in the event_offsets table by name
+func Subscribe(name string, handler Handler, eventTypes ...string) error {
+	if len(name) == 0 || len(name) > 100 || handler == nil {
+		return fmt.Errorf("%w: %s", ErrInvalidConsumer, name)
+	}
+	consumers.Lock()
+	defer consumers.Unlock()
+	for _, c := range consumers.list {
+		if c.name == name {
+			return fmt.Errorf("%w: %s is already subscribed", ErrInvalidConsumer, name)
+		}
+	}
+	consumers.list = append(consumers.list, &consumer{eventTypes: eventTypes, handler: handler, name: name})
+	return nil
+}
+
+// Relay publishes the outbox to every consumer from its offset (the event-relay job)
+func Relay(ctx context.Context) error {
+
+	// Every consumer is relayed (one failing consumer does not hold back the others)
+	var errs []error
+	lowest := uint64(math.MaxInt64) // No consumers, only the retention applies
+	for _, c := range relayConsumers() {
+		position, err := c.relay(ctx)
+		if err != nil {
+			logger.Data(2, logger.ERROR, "event consumer "+c.name+" stopped: "+err.Error(), request.LogParameters(ctx)...)
+			errs = append(errs, fmt.Errorf("%s: %w", c.name, err))
+		}
+		if position < lowest {
+			lowest = position
+		}
+	}
+
+	// Remove the old events every consumer has handled
+	if err := prune(ctx, lowest); err != nil {
+		errs = append(errs, fmt.Errorf("error pruning events: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// relayConsumers returns the subscribers and the redis stream (if configured)
+func relayConsumers() []*consumer {
+	consumers.RLock()
+	list := append([]*consumer{}, consumers.list...)
+	consumers.RUnlock()
+
+	if stream := config.Values.Events.RedisStream; len(stream) > 0 && config.Values.CacheEnabled {
+		list = append(list, &consumer{handler: publishToStream(stream, config.Values.Events.RedisStreamSize), name: redisConsumerPrefix + stream})
+	}
+	return list
+}
+
+// relay hands the consumer every event after its offset, in order (returns the offset it reached)
+func (c *consumer) relay(ctx context.Context) (position uint64, err error) {
+
+	ctx, span := tracing.StartSpan(ctx, "events relay", attribute.String("events.consumer", c.name))
+	defer func() {
+		tracing.EndSpan(span, err)
+	}()
+
+	if position, err = getOffset(ctx, c.name); err != nil {
+		return
+	}
+
+	conf := config.Values.Events
+	for {
+
+		var batch []*Envelope
+		if batch, err = getEvents(ctx, position, conf.BatchSize); err != nil || len(batch) == 0 {
+			return
+		}
+
+		// Stop at an ID gap until it has settled (a slower transaction that took the ID may still commit)
+		ready := settled(batch, position, time.Now().UTC().Add(-conf.SettleDelay))
+		if len(ready) == 0 {
+			return
+		}
+
+		// Handle in order, stopping at the first error
+		handled := position
+		var handlerErr error
+		for _, envelope := range ready {
+			if c.wants(envelope.Type) {
+				if handlerErr = c.handler(envelope.Context(ctx), envelope); handlerErr != nil {
+					handlerErr = fmt.Errorf("event %d (%s): %w", envelope.ID, envelope.EventID, handlerErr)
+					break
+				}
+			}
+			handled = envelope.ID
+		}
+
+		// Move the offset past the handled events
+		if err = saveOffset(ctx, c.name, position, handled, handlerErr); err != nil {
+			return
+		}
+		position = handled
+		if handlerErr != nil || len(ready) < conf.BatchSize {
+			return position, handlerErr
+		}
+	}
+}
+
+// settled returns the events up to the first ID gap that is newer than the settle time
+//
+// IDs are taken at insert and become visible at commit, so a missing ID is either a transaction that is still
+// open or one that rolled back. Once the event after the gap is older than any transaction can run
+// (config events.settle_delay), the missing ID is never coming and the gap is skipped.
+func settled(batch []*Envelope, position uint64, settleTime time.Time) []*Envelope {
+	next := position + 1
+	for i, envelope := range batch {
+		if envelope.ID != next && envelope.CreatedAt.After(settleTime) {
+			return batch[:i]
+		}
+		next = envelope.ID + 1
+	}
+	return batch
+}
+
+// wants returns true if the consumer subscribed to the event type
+func (c *consumer) wants(eventType string) bool {
+	if len(c.eventTypes) == 0 {
+		return true
+	}
+	for _, t := range c.eventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// getOffset returns the consumer's position in the outbox (new consumers start at the beginning)
+func getOffset(ctx context.Context, name string) (position uint64, err error) {
+	if _, err = database.WriteDatabase.ExecContext(ctx,
+		"INSERT IGNORE INTO `event_offsets` (`consumer`, `position`, `last_error`) VALUES (?, 0, '')", name,
+	); err != nil {
+		return
+	}
+	var offset struct {
+		Position uint64 `boil:"position"`
+	}
+	err = queries.Raw(
+		"SELECT `position` FROM `event_offsets` WHERE `consumer` = ?", name,
+	).Bind(ctx, database.WriteDatabase, &offset)
+	return offset.Position, err
+}
+
+// saveOffset moves the consumer from one position to the next and keeps the last error (fails if another relay moved it)
+func saveOffset(ctx context.Context, name string, from, to uint64, handlerErr error) error {
+	lastError := ""
+	if handlerErr != nil {
+		lastError = handlerErr.Error()
+	}
+
+	// Nothing was handled (only the error changed)
+	if to == from {
+		_, err := database.WriteDatabase.ExecContext(ctx,
+			"UPDATE `event_offsets` SET `last_error` = ? WHERE `consumer` = ?", lastError, name,
+		)
+		return err
+	}
+
+	result, err := database.WriteDatabase.ExecContext(ctx,
+		"UPDATE `event_offsets` SET `position` = ?, `last_error` = ? WHERE `consumer` = ? AND `position` = ?",
+		to, lastError, name, from,
+	)
+	if err != nil {
+		return err
+	}
+	var rows int64
+	if rows, err = result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return fmt.Errorf("%w: %s", ErrOffsetMoved, name)
+	}
+	return nil
+}
+
+// getEvents gets the next events after the position (recorded before the time, from the primary so none are missed)
+func getEvents(ctx context.Context, position uint64, limit int) (envelopes []*Envelope, err error) {
+	if err = queries.Raw(
+		"SELECT * FROM `event_outbox` WHERE `id` > ? ORDER BY `id` LIMIT ?",
+		position, limit,
+	).Bind(ctx, database.WriteDatabase, &envelopes); errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return
+}
+
+// prune removes the events older than the retention that every consumer has handled (config events.retention)
+func prune(ctx context.Context, position uint64) error {
+	retention := config.Values.Events.Retention
+	if retention == 0 {
+		return nil
+	}
+	_, err := database.WriteDatabase.ExecContext(ctx,
+		"DELETE FROM `event_outbox` WHERE `id` <= ? AND `created_at` < ?",
+		position, time.Now().UTC().Add(-retention),
+	)
+	return err
+}
+
+// publishToStream returns the handler that adds each event to the redis stream (XADD, trimmed to about the size)
+func publishToStream(stream string, size int64) Handler {
+	return func(ctx context.Context, envelope *Envelope) (err error) {
+
+		ctx, span := tracing.StartSpan(ctx, "redis xadd", attribute.String("events.stream", stream))
+		defer func() {
+			tracing.EndSpan(span, err)
+		}()
+
+		var conn redis.Conn
+		if conn, err = config.Values.Cache.Client.GetConnectionWithContext(ctx); err != nil {
+			return
+		}
+		defer config.Values.Cache.Client.CloseConnection(conn)
+
+		args := redis.Args{stream}
+		if size > 0 {
+			args = args.Add("MAXLEN", "~", size)
+		}
+		args = args.Add("*",
+			"event_id", envelope.EventID,
+			"event_type", envelope.Type,
+			"position", envelope.ID,
+			"aggregate_type", envelope.AggregateType,
+			"aggregate_id", envelope.AggregateID,
+			"payload", []byte(envelope.Payload),
+			"request_id", envelope.RequestID,
+			"created_at", envelope.CreatedAt.Format(time.RFC3339Nano),
+		)
+		_, err = conn.Do("XADD", args...)
+		return
+	}
+}
@@ -0,0 +1,152 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mrz1836/go-api/database"
+)
+
+// TestSettled tests holding the relay at an ID gap until the gap outlasts the settle delay
+func TestSettled(t *testing.T) {
+	now := time.Now().UTC()
+	settleTime := now.Add(-30 * time.Second)
+	fresh, old := now, now.Add(-time.Minute)
+
+	tests := []struct {
+		name     string
+		batch    []*Envelope
+		position uint64
+		expected int
+	}{
+		{"no gaps", []*Envelope{{ID: 6, CreatedAt: fresh}, {ID: 7, CreatedAt: fresh}, {ID: 8, CreatedAt: fresh}}, 5, 3},
+		{"gap before a recent event", []*Envelope{{ID: 6, CreatedAt: fresh}, {ID: 7, CreatedAt: fresh}, {ID: 9, CreatedAt: fresh}}, 5, 2},
+		{"gap after the position", []*Envelope{{ID: 6, CreatedAt: fresh}, {ID: 7, CreatedAt: fresh}}, 4, 0},
+		{"gap before a settled event", []*Envelope{{ID: 6, CreatedAt: fresh}, {ID: 7, CreatedAt: fresh}, {ID: 9, CreatedAt: old}}, 5, 3},
+		{"settled gap then a recent gap", []*Envelope{{ID: 8, CreatedAt: old}, {ID: 9, CreatedAt: fresh}, {ID: 11, CreatedAt: fresh}}, 5, 2},
+		{"empty", nil, 5, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if ready := settled(test.batch, test.position, settleTime); len(ready) != test.expected {
+				t.Fatalf("expected %d events, got %d", test.expected, len(ready))
+			}
+		})
+	}
+}
+
+// TestSaveOffset tests that the offset only moves from the position the relay read (compare and swap)
+func TestSaveOffset(t *testing.T) {
+	offsets := &testOffsets{lastErrors: map[string]string{}, positions: map[string]int64{"webhooks": 5}}
+	sql.Register("events-test", offsets)
+	db, err := sql.Open("events-test", "")
+	if err != nil {
+		t.Fatalf("error opening database: %s", err.Error())
+	}
+	previous := database.WriteDatabase
+	database.WriteDatabase = database.NewAPIDatabase(db, db)
+	defer func() {
+		database.WriteDatabase = previous
+		_ = db.Close()
+	}()
+	ctx := context.Background()
+
+	t.Run("moves from the position", func(t *testing.T) {
+		if err = saveOffset(ctx, "webhooks", 5, 8, nil); err != nil {
+			t.Fatalf("error saving offset: %s", err.Error())
+		}
+		if position := offsets.position("webhooks"); position != 8 {
+			t.Fatalf("expected 8, got %d", position)
+		}
+	})
+
+	t.Run("moved by another relay", func(t *testing.T) {
+		if err = saveOffset(ctx, "webhooks", 5, 9, nil); !errors.Is(err, ErrOffsetMoved) {
+			t.Fatalf("expected %s, got %v", ErrOffsetMoved, err)
+		}
+		if position := offsets.position("webhooks"); position != 8 {
+			t.Fatalf("expected 8, got %d", position)
+		}
+	})
+
+	t.Run("only the error changed", func(t *testing.T) {
+		if err = saveOffset(ctx, "webhooks", 8, 8, errors.New("handler error")); err != nil {
+			t.Fatalf("error saving offset: %s", err.Error())
+		}
+		if position, lastError := offsets.position("webhooks"), offsets.lastError("webhooks"); position != 8 || lastError != "handler error" {
+			t.Fatalf("expected 8 handler error, got %d %s", position, lastError)
+		}
+	})
+}
+
+// testOffsets is a database/sql driver with the event_offsets updates (only ExecContext is supported)
+type testOffsets struct {
+	sync.Mutex
+	lastErrors map[string]string
+	positions  map[string]int64
+}
+
+// Open returns a connection to the offsets
+func (o *testOffsets) Open(string) (driver.Conn, error) {
+	return &testOffsetsConn{offsets: o}, nil
+}
+
+// position returns the consumer's position
+func (o *testOffsets) position(name string) int64 {
+	o.Lock()
+	defer o.Unlock()
+	return o.positions[name]
+}
+
+// lastError returns the consumer's last error
+func (o *testOffsets) lastError(name string) string {
+	o.Lock()
+	defer o.Unlock()
+	return o.lastErrors[name]
+}
+
+// testOffsetsConn runs the offset updates
+type testOffsetsConn struct {
+	offsets *testOffsets
+}
+
+// Prepare is not supported
+func (c *testOffsetsConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepare is not supported")
+}
+
+// Close does nothing
+func (c *testOffsetsConn) Close() error {
+	return nil
+}
+
+// Begin is not supported
+func (c *testOffsetsConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+// ExecContext runs the position (compare and swap) or last error update
+func (c *testOffsetsConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	o := c.offsets
+	o.Lock()
+	defer o.Unlock()
+	switch {
+	case strings.Contains(query, "SET `position` = ?"):
+		name := args[2].Value.(string)
+		if o.positions[name] != args[3].Value.(int64) {
+			return driver.RowsAffected(0), nil
+		}
+		o.positions[name], o.lastErrors[name] = args[0].Value.(int64), args[1].Value.(string)
+	case strings.Contains(query, "SET `last_error` = ?"):
+		o.lastErrors[args[1].Value.(string)] = args[0].Value.(string)
+	default:
+		return nil, errors.New("unexpected query: " + query)
+	}
+	return driver.RowsAffected(1), nil
+}
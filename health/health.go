@@ -0,0 +1,179 @@
+/*
+Package health is a registry of readiness checks for all subsystems (database, cache, email, etc)
+*/
+package health
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Status constants used in reports
+const (
+	StatusDown = "down"
+	StatusUp   = "up"
+)
+
+// DefaultTimeout is used when a checker is registered without a timeout
+const DefaultTimeout = 3 * time.Second
+
+// CheckFunc is the signature for a component check (return an error if not healthy)
+type CheckFunc func(ctx context.Context) error
+
+// checker is a registered check with its own timeout
+type checker struct {
+	check   CheckFunc
+	timeout time.Duration
+}
+
+// ComponentStatus is the result of a single component check
+type ComponentStatus struct {
+	Error     string  `json:"error,omitempty"`
+	LatencyMs float64 `json:"latency_ms"`
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+}
+
+// Report is the result of running all registered checks
+type Report struct {
+	CheckedAt  time.Time         `json:"checked_at"`
+	Components []ComponentStatus `json:"components"`
+	Status     string            `json:"status"`
+}
+
+// Healthy returns true if all components are up
+func (r *Report) Healthy() bool {
+	return r.Status == StatusUp
+}
+
+// registry of all checkers
+var (
+	checkers     = make(map[string]checker)
+	checkerMutex sync.RWMutex
+)
+
+// Register adds (or replaces) a named check, a timeout of 0 will use the DefaultTimeout
+func Register(name string, timeout time.Duration, check CheckFunc) {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	checkerMutex.Lock()
+	checkers[name] = checker{check: check, timeout: timeout}
+	checkerMutex.Unlock()
+}
+
+// Unregister removes a named check
+func Unregister(name string) {
+	checkerMutex.Lock()
+	delete(checkers, name)
+	checkerMutex.Unlock()
+}
+
+// Names returns the names of all registered checks (sorted)
+func Names() (names []string) {
+	checkerMutex.RLock()
+	for name := range checkers {
+		names = append(names, name)
+	}
+	checkerMutex.RUnlock()
+	sort.Strings(names)
+	return
+}
+
+// Check runs all registered checks concurrently and returns the report
+func Check(ctx context.Context) (report *Report) {
+
+	// Copy the checkers (don't hold the lock while checking)
+	checkerMutex.RLock()
+	list := make(map[string]checker, len(checkers))
+	for name, c := range checkers {
+		list[name] = c
+	}
+	checkerMutex.RUnlock()
+
+	// Start the report
+	report = &Report{
+		CheckedAt:  time.Now().UTC(),
+		Components: make([]ComponentStatus, 0, len(list)),
+		Status:     StatusUp,
+	}
+
+	// Run each check in its own routine
+	var wg sync.WaitGroup
+	results := make(chan ComponentStatus, len(list))
+	for name, c := range list {
+		wg.Add(1)
+		go func(name string, c checker) {
+			defer wg.Done()
+			results <- runCheck(ctx, name, c)
+		}(name, c)
+	}
+	wg.Wait()
+	close(results)
+
+	// Collect the results
+	for result := range results {
+		if result.Status != StatusUp {
+			report.Status = StatusDown
+		}
+		report.Components = append(report.Components, result)
+	}
+
+	// Always return in the same order
+	sort.Slice(report.Components, func(i, j int) bool {
+		return report.Components[i].Name < report.Components[j].Name
+	})
+
+	return
+}
+
+// runCheck runs a single check with a timeout (recovers from panics)
+func runCheck(ctx context.Context, name string, c checker) (status ComponentStatus) {
+
+	status.Name = name
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	// Run the check in a routine so a blocking check can't hold the request
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- &panicError{value: r}
+			}
+		}()
+		done <- c.check(ctx)
+	}()
+
+	// Wait for the check or the timeout
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	status.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		status.Error = err.Error()
+		status.Status = StatusDown
+	} else {
+		status.Status = StatusUp
+	}
+
+	return
+}
+
+// panicError is returned when a check panics
+type panicError struct {
+	value interface{}
+}
+
+// Error returns the panic value as an error message
+func (p *panicError) Error() string {
+	return fmt.Sprintf("check panicked: %v", p.value)
+}
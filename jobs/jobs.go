@@ -4,31 +4,75 @@ Package jobs is for all scheduled jobs (tasks) to run
 package jobs
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-api/events"
+	"github.com/mrz1836/go-api/notifications"
+	"github.com/mrz1836/go-api/request"
+	"github.com/mrz1836/go-api/webhooks"
 	"github.com/mrz1836/go-logger"
 )
 
+// newJobContext creates a context for a job run with its own request ID (used for log correlation)
+func newJobContext(name string) context.Context {
+	return request.WithPrincipal(request.WithID(context.Background(), request.NewID()), "job:"+name)
+}
+
 // exampleJob is an example job
-func exampleJob() {
+func exampleJob(ctx context.Context) error {
 
-	logger.Data(2, logger.DEBUG, "starting job...")
+	logger.Data(2, logger.DEBUG, "starting job...", request.LogParameters(ctx)...)
 
-	// Do something
+	// Do something (pass the ctx to any models)
 
 	// Do something else
 
-	logger.Data(2, logger.DEBUG, "job complete!")
+	logger.Data(2, logger.DEBUG, "job complete!", request.LogParameters(ctx)...)
+
+	return nil
 }
 
-// RunExampleJob will run the job every X minutes
-func RunExampleJob(runNow bool, andEveryXMinutes int) {
-	if runNow {
-		exampleJob()
-	}
-	_, err := config.Values.Scheduler.AddJob("example-job", fmt.Sprintf("@every %dm", andEveryXMinutes), exampleJob)
-	if err != nil {
-		logger.Data(2, logger.ERROR, "error adding job: RunExampleJob: "+err.Error())
+// catalog is every job that can be scheduled (by config.JobNames, schedules are set in the config jobs section)
+var catalog = map[string]struct {
+	job    Job
+	policy Policy
+}{
+	config.JobEmailOutbox:       {job: notifications.DispatchOutbox, policy: Policy{Backoff: 5 * time.Second, MaxRetries: 1, Timeout: 5 * time.Minute}},
+	config.JobEventRelay:        {job: events.Relay, policy: Policy{Backoff: 5 * time.Second, MaxRetries: 1, Timeout: 5 * time.Minute}},
+	config.JobExample:           {job: exampleJob, policy: DefaultPolicy},
+	config.JobWebhookDeliveries: {job: webhooks.Dispatch, policy: Policy{Backoff: 5 * time.Second, MaxRetries: 1, Timeout: 5 * time.Minute}},
+}
+
+// StartUp registers every enabled job from the config schedules (and runs any set to run on start)
+func StartUp() (err error) {
+	for name, schedule := range config.Values.Jobs.Schedules {
+
+		// Disabled in this environment
+		if !schedule.Enabled {
+			logger.Data(2, logger.DEBUG, "job disabled: "+name)
+			continue
+		}
+
+		// Find the job by name (unknown names fail the config validation)
+		definition, ok := catalog[name]
+		if !ok {
+			return fmt.Errorf("%w: %s (check the config jobs section)", ErrNotFound, name)
+		}
+
+		// Add to the registry and scheduler
+		if err = DefaultRegistry.Register(name, schedule.CronSpec(), definition.policy, definition.job); err != nil {
+			return
+		}
+
+		// Run now (in the background, don't hold the start up)
+		if schedule.RunOnStart {
+			go func(name string) {
+				_ = DefaultRegistry.RunNow(name)
+			}(name)
+		}
 	}
+	return
 }
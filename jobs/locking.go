@@ -0,0 +1,101 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-api/locks"
+	"github.com/mrz1836/go-api/request"
+	"github.com/mrz1836/go-logger"
+)
+
+// Lock name prefix and the suffix of the schedule tick claim
+const (
+	lockPrefix = "job:"
+	tickSuffix = ":tick"
+)
+
+// locksEnabled returns true if runs are locked across instances (redis not connected is logged as an error at startup)
+func locksEnabled() bool {
+	return config.Values.Jobs.DistributedLocks && config.Values.CacheEnabled
+}
+
+// claimTick takes the schedule tick so only one instance runs it (never released, expires just before the next tick)
+//
+// Instances that start at different times will skip any tick inside the window
+func claimTick(ctx context.Context, name string, nextTick time.Time) bool {
+	ttl := time.Until(nextTick) * 9 / 10
+	if !locksEnabled() || ttl <= 0 {
+		return true
+	}
+
+	_, heldBy, err := locks.Acquire(ctx, lockPrefix+name+tickSuffix, ttl)
+	if errors.Is(err, locks.ErrNotAcquired) {
+		logger.Data(2, logger.DEBUG, "job skipped: "+name+" tick already ran on another instance",
+			append(request.LogParameters(ctx), logger.MakeParameter("lock_owner", heldBy))...,
+		)
+		return false
+	} else if err != nil {
+		logger.Data(2, logger.ERROR, "job skipped: "+name+" error claiming tick: "+err.Error(), request.LogParameters(ctx)...)
+		return false
+	}
+	return true
+}
+
+// acquireLock takes the job lock for the whole run (ErrAlreadyRunning if another instance holds it)
+func acquireLock(ctx context.Context, name string, ttl time.Duration) (context.Context, error) {
+	if !locksEnabled() {
+		return ctx, nil
+	}
+
+	// Take the lock (skip if another instance has it, or if the lock can't be checked)
+	lock, heldBy, err := locks.Acquire(ctx, lockPrefix+name, ttl)
+	if errors.Is(err, locks.ErrNotAcquired) {
+		logger.Data(2, logger.DEBUG, "job skipped: "+name+" is running on another instance",
+			append(request.LogParameters(ctx), logger.MakeParameter("lock_owner", heldBy))...,
+		)
+		return ctx, fmt.Errorf("%w: %s on %s", ErrAlreadyRunning, name, heldBy)
+	} else if err != nil {
+		logger.Data(2, logger.ERROR, "job skipped: "+name+" error acquiring lock: "+err.Error(), request.LogParameters(ctx)...)
+		return ctx, fmt.Errorf("error acquiring job lock: %w", err)
+	}
+
+	logger.Data(2, logger.DEBUG, "job lock acquired: "+name,
+		append(request.LogParameters(ctx), logger.MakeParameter("lock_owner", lock.Owner))...,
+	)
+
+	return locks.WithLock(ctx, lock), nil
+}
+
+// releaseLock releases the job lock once the run is over (if locked)
+func releaseLock(ctx context.Context) {
+	if lock := locks.FromContext(ctx); lock != nil {
+		if err := locks.Release(ctx, lock); err != nil {
+			logger.Data(2, logger.ERROR, "error releasing job lock: "+err.Error(), request.LogParameters(ctx)...)
+		}
+	}
+}
+
+// lockOwner returns the owner of the run (lock owner, or this instance if not locked)
+func lockOwner(ctx context.Context) string {
+	if lock := locks.FromContext(ctx); lock != nil {
+		return lock.Owner
+	}
+	return locks.InstanceOwner()
+}
+
+// lockTTL returns how long a run can take (every attempt, the time to stop after each timeout and the backoffs)
+func (p Policy) lockTTL() (ttl time.Duration) {
+	backoff := p.Backoff
+	for attempt := 0; attempt <= p.MaxRetries; attempt++ {
+		ttl += p.Timeout + stopTimeout
+		if attempt < p.MaxRetries {
+			ttl += backoff
+			backoff *= 2
+		}
+	}
+	return
+}
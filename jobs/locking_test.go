@@ -0,0 +1,26 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+)
+
+// TestPolicyLockTTL tests the lock is held for the longest run of the policy
+func TestPolicyLockTTL(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   Policy
+		expected time.Duration
+	}{
+		{"no retries", Policy{Backoff: time.Second, Timeout: time.Minute}, time.Minute + stopTimeout},
+		{"one retry", Policy{Backoff: 5 * time.Second, MaxRetries: 1, Timeout: 5 * time.Minute}, 2*(5*time.Minute+stopTimeout) + 5*time.Second},
+		{"default policy", DefaultPolicy, 3*(time.Minute+stopTimeout) + 5*time.Second + 10*time.Second},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if ttl := test.policy.lockTTL(); ttl != test.expected {
+				t.Fatalf("expected %s, got %s", test.expected, ttl)
+			}
+		})
+	}
+}
@@ -0,0 +1,462 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-api/metrics"
+	"github.com/mrz1836/go-api/models"
+	"github.com/mrz1836/go-api/request"
+	"github.com/mrz1836/go-logger"
+	"github.com/robfig/cron/v3"
+)
+
+// Job is a scheduled job (return an error if the run failed, the ctx is cancelled on timeout)
+type Job func(ctx context.Context) error
+
+// Policy is how each run of a job is executed
+type Policy struct {
+	Backoff    time.Duration // Wait before the first retry (doubles after each retry)
+	MaxRetries int           // Retries after a failed attempt (panics are not retried)
+	Timeout    time.Duration // Timeout for each attempt (required, the lock is held for the longest run)
+}
+
+// DefaultPolicy is used by most jobs
+var DefaultPolicy = Policy{
+	Backoff:    5 * time.Second,
+	MaxRetries: 2,
+	Timeout:    time.Minute,
+}
+
+// ErrNotFound is returned when a job is not registered
+var ErrNotFound = errors.New("job not found")
+
+// ErrAlreadyRunning is returned when a run is started while the job is running (on this or another instance)
+var ErrAlreadyRunning = errors.New("job is already running")
+
+// errStillRunning is when a job ignores the timeout (the run stops, it is not retried)
+var errStillRunning = errors.New("job is still running after the timeout")
+
+// stopTimeout is how long a job has to return after the timeout cancels its ctx
+const stopTimeout = 5 * time.Second
+
+// entry is a registered job
+type entry struct {
+	entryID cron.EntryID
+	job     Job
+	name    string
+	paused  bool
+	policy  Policy
+	running atomic.Bool // Running on this instance (scheduled or manual)
+	spec    string
+}
+
+// Info is the current schedule of a job
+type Info struct {
+	MaxRetries int        `json:"max_retries"`
+	Name       string     `json:"name"`
+	Next       *time.Time `json:"next,omitempty"`
+	Paused     bool       `json:"paused"`
+	Prev       *time.Time `json:"prev,omitempty"`
+	Spec       string     `json:"spec"`
+	Timeout    string     `json:"timeout"`
+}
+
+// Registry holds all the scheduled jobs by name
+type Registry struct {
+	entries map[string]*entry
+	mutex   sync.RWMutex
+}
+
+// DefaultRegistry is the registry for all jobs in the service
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{entries: make(map[string]*entry)}
+}
+
+// Register adds the job to the registry and the scheduler
+func (r *Registry) Register(name, spec string, policy Policy, job Job) (err error) {
+
+	// Check the job
+	if len(name) == 0 || job == nil {
+		return errors.New("job name and func are required")
+	} else if policy.Timeout <= 0 {
+		return fmt.Errorf("job timeout is required: %s", name)
+	}
+
+	e := &entry{job: job, name: name, policy: policy, spec: spec}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// Names are unique
+	if _, ok := r.entries[name]; ok {
+		return fmt.Errorf("job already registered: %s", name)
+	}
+
+	// Add to the scheduler
+	if err = r.schedule(e); err != nil {
+		return
+	}
+
+	r.entries[name] = e
+	return
+}
+
+// schedule adds the entry to the scheduler (mutex must be held)
+func (r *Registry) schedule(e *entry) (err error) {
+	e.entryID, err = config.Values.Scheduler.AddJob(e.name, e.spec, func() {
+		_ = r.run(e)
+	})
+	return
+}
+
+// RunNow runs the job immediately (same locking, retries and history as a scheduled run)
+func (r *Registry) RunNow(name string) error {
+	e, err := r.entry(name)
+	if err != nil {
+		return err
+	}
+	return r.run(e)
+}
+
+// Trigger runs the job now in the background (even if paused, ErrAlreadyRunning if it is running on any instance)
+func (r *Registry) Trigger(name string) error {
+	e, err := r.entry(name)
+	if err != nil {
+		return err
+	}
+	ctx, err := e.start(newJobContext(e.name))
+	if err != nil {
+		return err
+	}
+	go func() {
+		_ = e.finish(ctx)
+	}()
+	return nil
+}
+
+// Pause stops scheduled runs of the job (on all instances)
+func (r *Registry) Pause(ctx context.Context, name string) error {
+	return r.setPaused(ctx, name, true)
+}
+
+// Resume starts scheduled runs of the job again (on all instances)
+func (r *Registry) Resume(ctx context.Context, name string) error {
+	return r.setPaused(ctx, name, false)
+}
+
+// setPaused sets the local and shared paused state
+func (r *Registry) setPaused(ctx context.Context, name string, paused bool) error {
+	r.mutex.Lock()
+	e, ok := r.entries[name]
+	if !ok {
+		r.mutex.Unlock()
+		return fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	e.paused = paused
+	s := &state{Paused: e.paused, Spec: e.spec}
+	r.mutex.Unlock()
+	return saveState(ctx, name, s)
+}
+
+// Reschedule changes the spec of the job (other instances apply it on their next tick)
+func (r *Registry) Reschedule(ctx context.Context, name, spec string) error {
+	r.mutex.Lock()
+	e, ok := r.entries[name]
+	if !ok {
+		r.mutex.Unlock()
+		return fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if err := r.reschedule(e, spec); err != nil {
+		r.mutex.Unlock()
+		return err
+	}
+	s := &state{Paused: e.paused, Spec: e.spec}
+	r.mutex.Unlock()
+	return saveState(ctx, name, s)
+}
+
+// reschedule replaces the cron entry with the new spec (mutex must be held)
+func (r *Registry) reschedule(e *entry, spec string) (err error) {
+
+	// Add the new entry before removing the current one (an invalid spec keeps the current entry)
+	oldEntryID, oldSpec := e.entryID, e.spec
+	e.spec = spec
+	if err = r.schedule(e); err != nil {
+		e.spec = oldSpec
+		return
+	}
+	return config.Values.Scheduler.RemoveJob(oldEntryID)
+}
+
+// Get returns the schedule of the job
+func (r *Registry) Get(name string) (info Info, err error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	e, ok := r.entries[name]
+	if !ok {
+		return info, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return e.info(), nil
+}
+
+// List returns the schedule of all jobs (sorted by name)
+func (r *Registry) List() (list []Info) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	list = make([]Info, 0, len(r.entries))
+	for _, e := range r.entries {
+		list = append(list, e.info())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return
+}
+
+// info returns the schedule from the cron entry (mutex must be held)
+func (e *entry) info() (info Info) {
+	info = Info{
+		MaxRetries: e.policy.MaxRetries,
+		Name:       e.name,
+		Paused:     e.paused,
+		Spec:       e.spec,
+		Timeout:    e.policy.Timeout.String(),
+	}
+	cronEntry := config.Values.Scheduler.Entry(e.entryID)
+	if !cronEntry.Next.IsZero() && !e.paused {
+		info.Next = &cronEntry.Next
+	}
+	if !cronEntry.Prev.IsZero() {
+		info.Prev = &cronEntry.Prev
+	}
+	return
+}
+
+// entry returns the registered entry by name
+func (r *Registry) entry(name string) (*entry, error) {
+	r.mutex.RLock()
+	e, ok := r.entries[name]
+	r.mutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return e, nil
+}
+
+// applyState applies changes made on other instances (returns false if the run should be skipped)
+func (r *Registry) applyState(ctx context.Context, e *entry) bool {
+
+	s, err := loadState(ctx, e.name)
+	if err != nil {
+		logger.Data(2, logger.ERROR, "error loading job state: "+err.Error(), request.LogParameters(ctx)...)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if s != nil {
+		e.paused = s.Paused
+
+		// New schedule (this tick was from the old one)
+		if len(s.Spec) > 0 && s.Spec != e.spec {
+			if err = r.reschedule(e, s.Spec); err != nil {
+				logger.Data(2, logger.ERROR, "error applying job schedule: "+err.Error(), request.LogParameters(ctx)...)
+			}
+			return false
+		}
+	}
+
+	if e.paused {
+		logger.Data(2, logger.DEBUG, "job skipped: "+e.name+" is paused", request.LogParameters(ctx)...)
+	}
+	return !e.paused
+}
+
+// Names returns the names of all registered jobs (sorted)
+func (r *Registry) Names() (names []string) {
+	r.mutex.RLock()
+	for name := range r.entries {
+		names = append(names, name)
+	}
+	r.mutex.RUnlock()
+	sort.Strings(names)
+	return
+}
+
+// run executes a scheduled run of the job (skipped if paused, the tick ran on another instance or it is still running)
+func (r *Registry) run(e *entry) (err error) {
+	ctx := newJobContext(e.name)
+
+	// Paused or rescheduled (on any instance)
+	if !r.applyState(ctx, e) {
+		return
+	}
+
+	// Only one instance runs each tick
+	r.mutex.RLock()
+	nextTick := config.Values.Scheduler.Entry(e.entryID).Next
+	r.mutex.RUnlock()
+	if !claimTick(ctx, e.name, nextTick) {
+		return
+	}
+
+	// Only one run at a time
+	if ctx, err = e.start(ctx); err != nil {
+		return
+	}
+	return e.finish(ctx)
+}
+
+// start marks the job as running and takes the lock for the whole run (ErrAlreadyRunning if either is held)
+func (e *entry) start(ctx context.Context) (context.Context, error) {
+	if !e.running.CompareAndSwap(false, true) {
+		return ctx, fmt.Errorf("%w: %s", ErrAlreadyRunning, e.name)
+	}
+	ctx, err := acquireLock(ctx, e.name, e.policy.lockTTL())
+	if err != nil {
+		e.running.Store(false)
+	}
+	return ctx, err
+}
+
+// finish runs the started job (attempts, metrics and history) and then releases the lock
+func (e *entry) finish(ctx context.Context) (err error) {
+	defer func() {
+		releaseLock(ctx)
+		e.running.Store(false)
+	}()
+
+	// Record the start (history is best effort, never block the job)
+	run, historyErr := models.StartJobRun(ctx, e.name, lockOwner(ctx))
+	if historyErr != nil {
+		logger.Data(2, logger.ERROR, "error recording job run: "+historyErr.Error(), request.LogParameters(ctx)...)
+	}
+
+	// Run with retries (instrumented)
+	var status string
+	var attempts int
+	instrumentJob(e.name, func() string {
+		status, attempts, err = execute(ctx, e)
+		return status
+	})
+	if err != nil {
+		logger.Data(2, logger.ERROR, fmt.Sprintf("job failed: %s after %d attempt(s): %s", e.name, attempts, err.Error()),
+			request.LogParameters(ctx)...,
+		)
+	}
+
+	// Record the end
+	if run != nil {
+		if historyErr = run.Finish(ctx, status, attempts, err); historyErr != nil {
+			logger.Data(2, logger.ERROR, "error recording job run: "+historyErr.Error(), request.LogParameters(ctx)...)
+		}
+	}
+
+	return
+}
+
+// instrumentJob records the run status and duration (a panic outside the attempts is recorded and raised again)
+func instrumentJob(name string, run func() (status string)) {
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			metrics.ObserveJob(name, metrics.StatusPanic, time.Since(start))
+			panic(r)
+		}
+	}()
+	status := run()
+	metrics.ObserveJob(name, status, time.Since(start))
+}
+
+// execute runs the attempts with backoff until success, a panic or no retries are left
+func execute(ctx context.Context, e *entry) (status string, attempts int, err error) {
+	backoff := e.policy.Backoff
+	for {
+		attempts++
+		if err = attempt(ctx, e); err == nil {
+			return models.JobRunStatusSuccess, attempts, nil
+		}
+
+		// Panics are not retried
+		var p *panicError
+		if errors.As(err, &p) {
+			return models.JobRunStatusPanic, attempts, err
+		}
+
+		status = models.JobRunStatusError
+		if errors.Is(err, context.DeadlineExceeded) {
+			status = models.JobRunStatusTimeout
+		}
+
+		// No retries left (or the attempt is still running, a retry would run a second copy)
+		if attempts > e.policy.MaxRetries || errors.Is(err, errStillRunning) {
+			return
+		}
+
+		// Wait before the next attempt
+		logger.Data(2, logger.WARN, fmt.Sprintf("job attempt %d failed: %s retrying in %s: %s", attempts, e.name, backoff, err.Error()),
+			request.LogParameters(ctx)...,
+		)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// attempt runs the job once with a timeout (recovers from panics)
+func attempt(ctx context.Context, e *entry) (err error) {
+
+	if e.policy.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.policy.Timeout)
+		defer cancel()
+	}
+
+	// Run the job in a routine so a job that ignores the ctx can't hold the scheduler
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- &panicError{stack: debug.Stack(), value: r}
+			}
+		}()
+		done <- e.job(ctx)
+	}()
+
+	// Wait for the job or the timeout
+	select {
+	case err = <-done:
+		return
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	// Give the canceled job time to stop (the attempt is only over once the job returns)
+	select {
+	case <-done:
+	case <-time.After(stopTimeout):
+		err = fmt.Errorf("%w: %w", errStillRunning, err)
+	}
+	return
+}
+
+// panicError is returned when a job panics
+type panicError struct {
+	stack []byte
+	value interface{}
+}
+
+// Error returns the panic value and stack as an error message
+func (p *panicError) Error() string {
+	return fmt.Sprintf("job panicked: %v\n%s", p.value, p.stack)
+}
@@ -0,0 +1,74 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-api/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// statePrefix is used for the shared job state (pause and schedule changes for all instances)
+const statePrefix = "job-state:"
+
+// state is the runtime state of a job shared by all instances (stored in redis)
+type state struct {
+	Paused bool   `redis:"paused"`
+	Spec   string `redis:"spec"`
+}
+
+// loadState gets the shared state (nil if not set or redis is disabled)
+func loadState(ctx context.Context, name string) (s *state, err error) {
+
+	if !config.Values.CacheEnabled {
+		return
+	}
+
+	ctx, span := tracing.StartSpan(ctx, "redis job state", attribute.String("job.name", name))
+	defer func() {
+		tracing.EndSpan(span, err)
+	}()
+
+	var conn redis.Conn
+	if conn, err = config.Values.Cache.Client.GetConnectionWithContext(ctx); err != nil {
+		return
+	}
+	defer config.Values.Cache.Client.CloseConnection(conn)
+
+	var values []interface{}
+	if values, err = redis.Values(conn.Do("HGETALL", statePrefix+name)); err != nil {
+		if errors.Is(err, redis.ErrNil) {
+			err = nil
+		}
+		return
+	} else if len(values) == 0 {
+		return
+	}
+	s = new(state)
+	err = redis.ScanStruct(values, s)
+	return
+}
+
+// saveState stores the shared state (other instances apply it on their next tick)
+func saveState(ctx context.Context, name string, s *state) (err error) {
+
+	if !config.Values.CacheEnabled {
+		return
+	}
+
+	ctx, span := tracing.StartSpan(ctx, "redis job state", attribute.String("job.name", name))
+	defer func() {
+		tracing.EndSpan(span, err)
+	}()
+
+	var conn redis.Conn
+	if conn, err = config.Values.Cache.Client.GetConnectionWithContext(ctx); err != nil {
+		return
+	}
+	defer config.Values.Cache.Client.CloseConnection(conn)
+
+	_, err = conn.Do("HSET", redis.Args{}.Add(statePrefix+name).AddFlat(s)...)
+	return
+}
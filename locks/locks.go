@@ -0,0 +1,156 @@
+/*
+Package locks is distributed locking across all instances (redis SET NX PX)
+
+These are plain TTL locks without fencing: size the ttl to cover the whole run, work that outlives
+its lock can overlap the next owner.
+*/
+package locks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-api/request"
+	"github.com/mrz1836/go-api/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// keyPrefix is used for all lock keys
+const keyPrefix = "lock:"
+
+// ErrNotAcquired is returned when the lock is held by another owner
+var ErrNotAcquired = errors.New("lock is held by another owner")
+
+// owner identifies this instance (hostname, pid and a random suffix)
+var owner = newOwner()
+
+// Lock is a distributed lock held by this instance
+type Lock struct {
+	ExpiresAt time.Time // Lock expires if not released (IE: the instance stopped)
+	Name      string    // Name of the lock (IE: job name)
+	Owner     string    // Owner that holds the lock
+}
+
+// acquireScript sets the lock if not held (returns 1, or the current owner)
+var acquireScript = redis.NewScript(1, `
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return 1
+end
+return redis.call('GET', KEYS[1])
+`)
+
+// releaseScript removes the lock only if still held by the owner
+var releaseScript = redis.NewScript(1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// newOwner creates the owner name for this instance
+func newOwner() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d:%s", hostname, os.Getpid(), request.NewID()[:8])
+}
+
+// InstanceOwner returns the owner name of this instance (recorded on each lock for diagnostics)
+func InstanceOwner() string {
+	return owner
+}
+
+// Acquire attempts to take the lock for the ttl (heldBy is the current owner if ErrNotAcquired)
+func Acquire(ctx context.Context, name string, ttl time.Duration) (lock *Lock, heldBy string, err error) {
+
+	// Start the span
+	ctx, span := tracing.StartSpan(ctx, "redis lock", attribute.String("lock.name", name))
+	defer func() {
+		tracing.EndSpan(span, err)
+	}()
+
+	// Get a connection
+	var conn redis.Conn
+	if conn, err = config.Values.Cache.Client.GetConnectionWithContext(ctx); err != nil {
+		return
+	}
+	defer config.Values.Cache.Client.CloseConnection(conn)
+
+	// Run the script
+	var reply interface{}
+	if reply, err = acquireScript.DoContext(
+		ctx, conn, keyPrefix+name, owner, ttl.Milliseconds(),
+	); err != nil {
+		return
+	}
+
+	// Acquired or held by another owner
+	switch value := reply.(type) {
+	case int64:
+		lock = &Lock{
+			ExpiresAt: time.Now().Add(ttl),
+			Name:      name,
+			Owner:     owner,
+		}
+	case []byte:
+		heldBy, err = string(value), ErrNotAcquired
+	default: // Expired between the SET and GET
+		err = ErrNotAcquired
+	}
+	return
+}
+
+// Release removes the lock (only if still held by this instance)
+func Release(ctx context.Context, lock *Lock) (err error) {
+
+	// Start the span
+	ctx, span := tracing.StartSpan(ctx, "redis unlock", attribute.String("lock.name", lock.Name))
+	defer func() {
+		tracing.EndSpan(span, err)
+	}()
+
+	// Get a connection
+	var conn redis.Conn
+	if conn, err = config.Values.Cache.Client.GetConnectionWithContext(ctx); err != nil {
+		return
+	}
+	defer config.Values.Cache.Client.CloseConnection(conn)
+
+	_, err = releaseScript.DoContext(ctx, conn, keyPrefix+lock.Name, lock.Owner)
+	return
+}
+
+// Owner returns the current owner of the lock (empty if not held)
+func Owner(ctx context.Context, name string) (current string, err error) {
+
+	var conn redis.Conn
+	if conn, err = config.Values.Cache.Client.GetConnectionWithContext(ctx); err != nil {
+		return
+	}
+	defer config.Values.Cache.Client.CloseConnection(conn)
+
+	if current, err = redis.String(conn.Do("GET", keyPrefix+name)); errors.Is(err, redis.ErrNil) {
+		err = nil
+	}
+	return
+}
+
+// contextKey is used for storing the lock on the context
+type contextKey string
+
+// lockContextKey is the context key for the lock
+const lockContextKey contextKey = "lock"
+
+// WithLock returns a new context with the lock (jobs can read the owner)
+func WithLock(ctx context.Context, lock *Lock) context.Context {
+	return context.WithValue(ctx, lockContextKey, lock)
+}
+
+// FromContext returns the lock from the context (nil if not found)
+func FromContext(ctx context.Context) *Lock {
+	lock, _ := ctx.Value(lockContextKey).(*Lock)
+	return lock
+}
@@ -0,0 +1,146 @@
+/*
+Package metrics is all the Prometheus collectors and instrumentation for the API (http, database, jobs, tasks, cache, email)
+*/
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metric constants
+const (
+	namespace     = "api"
+	ResultHit     = "hit"
+	ResultMiss    = "miss"
+	StatusError   = "error"
+	StatusPanic   = "panic"
+	StatusSuccess = "success"
+)
+
+// All collectors
+var (
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of HTTP requests by method, route and status",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	jobRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "jobs",
+		Name:      "runs_total",
+		Help:      "Total cron job runs by job and status",
+	}, []string{"job", "status"})
+
+	jobDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "jobs",
+		Name:      "run_duration_seconds",
+		Help:      "Duration of cron job runs by job",
+		Buckets:   []float64{.01, .05, .1, .5, 1, 5, 15, 30, 60, 300},
+	}, []string{"job"})
+
+	cacheResults = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "requests_total",
+		Help:      "Total cache lookups by cache and result (hit or miss)",
+	}, []string{"cache", "result"})
+
+	taskRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "tasks",
+		Name:      "processed_total",
+		Help:      "Total tasks processed by type and result (success, retry, dead or abandoned)",
+	}, []string{"type", "result"})
+
+	taskDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "tasks",
+		Name:      "duration_seconds",
+		Help:      "Duration of task attempts by type",
+		Buckets:   []float64{.01, .05, .1, .5, 1, 5, 15, 30, 60, 300},
+	}, []string{"type"})
+
+	emailSends = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "email",
+		Name:      "sends_total",
+		Help:      "Total emails sent by provider and status",
+	}, []string{"provider", "status"})
+)
+
+// registry is the isolated registry for all API metrics
+var registry = newRegistry()
+
+// newRegistry creates the registry with all collectors registered
+func newRegistry() *prometheus.Registry {
+	r := prometheus.NewRegistry()
+	r.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequestDuration,
+		jobRuns,
+		jobDuration,
+		taskRuns,
+		taskDuration,
+		cacheResults,
+		emailSends,
+	)
+	return r
+}
+
+// Handler returns the handler for exposing metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// RegisterDatabase registers the sql.DBStats gauges for a connection pool (IE: read, write)
+func RegisterDatabase(name string, db *sql.DB) error {
+	return registry.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// ObserveHTTPRequest records the duration of an HTTP request
+func ObserveHTTPRequest(method, route string, status int, duration time.Duration) {
+	httpRequestDuration.WithLabelValues(method, route, strconv.Itoa(status)).Observe(duration.Seconds())
+}
+
+// ObserveJob records a cron job run and the duration
+func ObserveJob(name, status string, duration time.Duration) {
+	jobRuns.WithLabelValues(name, status).Inc()
+	jobDuration.WithLabelValues(name).Observe(duration.Seconds())
+}
+
+// ObserveTask records a task attempt and the duration
+func ObserveTask(taskType, result string, duration time.Duration) {
+	taskRuns.WithLabelValues(taskType, result).Inc()
+	taskDuration.WithLabelValues(taskType).Observe(duration.Seconds())
+}
+
+// CacheHit records a cache hit for the given cache
+func CacheHit(cacheName string) {
+	cacheResults.WithLabelValues(cacheName, ResultHit).Inc()
+}
+
+// CacheMiss records a cache miss for the given cache
+func CacheMiss(cacheName string) {
+	cacheResults.WithLabelValues(cacheName, ResultMiss).Inc()
+}
+
+// ObserveEmail records the outcome of sending an email
+func ObserveEmail(provider string, err error) {
+	status := StatusSuccess
+	if err != nil {
+		status = StatusError
+	}
+	emailSends.WithLabelValues(provider, status).Inc()
+}
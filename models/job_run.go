@@ -0,0 +1,84 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/mrz1836/go-api/database"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/queries"
+)
+
+// Job run statuses
+const (
+	JobRunStatusError   = "error"
+	JobRunStatusPanic   = "panic"
+	JobRunStatusRunning = "running"
+	JobRunStatusSuccess = "success"
+	JobRunStatusTimeout = "timeout"
+)
+
+// JobRun is a single run of a scheduled job (job_runs table)
+type JobRun struct {
+	Attempts  int       `boil:"attempts" json:"attempts"`
+	EndedAt   null.Time `boil:"ended_at" json:"ended_at,omitempty"`
+	Error     string    `boil:"error" json:"error,omitempty"`
+	ID        uint64    `boil:"id" json:"id"`
+	JobName   string    `boil:"job_name" json:"job_name"`
+	Owner     string    `boil:"owner" json:"owner"`
+	StartedAt time.Time `boil:"started_at" json:"started_at"`
+	Status    string    `boil:"status" json:"status"`
+}
+
+// StartJobRun records the start of a job run
+func StartJobRun(ctx context.Context, jobName, owner string) (run *JobRun, err error) {
+
+	run = &JobRun{
+		JobName:   jobName,
+		Owner:     owner,
+		StartedAt: time.Now().UTC(),
+		Status:    JobRunStatusRunning,
+	}
+
+	// Insert the record
+	result, err := database.WriteDatabase.ExecContext(ctx,
+		"INSERT INTO `job_runs` (`job_name`, `status`, `error`, `owner`, `started_at`) VALUES (?, ?, '', ?, ?)",
+		run.JobName, run.Status, run.Owner, run.StartedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var id int64
+	if id, err = result.LastInsertId(); err != nil {
+		return nil, err
+	}
+	run.ID = uint64(id)
+
+	return
+}
+
+// Finish records the end of a job run (status, attempts and the last error)
+func (j *JobRun) Finish(ctx context.Context, status string, attempts int, runErr error) (err error) {
+
+	j.Attempts = attempts
+	j.EndedAt = null.TimeFrom(time.Now().UTC())
+	j.Status = status
+	if runErr != nil {
+		j.Error = runErr.Error()
+	}
+
+	_, err = database.WriteDatabase.ExecContext(ctx,
+		"UPDATE `job_runs` SET `status` = ?, `attempts` = ?, `error` = ?, `ended_at` = ? WHERE `id` = ?",
+		j.Status, j.Attempts, j.Error, j.EndedAt, j.ID,
+	)
+	return
+}
+
+// GetJobRuns gets the latest runs of a job (newest first)
+func GetJobRuns(ctx context.Context, jobName string, limit int) (runs []*JobRun, err error) {
+	err = queries.Raw(
+		"SELECT * FROM `job_runs` WHERE `job_name` = ? ORDER BY `id` DESC LIMIT ?", jobName, limit,
+	).Bind(ctx, database.ReadDatabase, &runs)
+	return
+}
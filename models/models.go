@@ -4,8 +4,7 @@ package models
 // StartUp loads all model dependencies
 func StartUp() (err error) {
 
-	// Load person templates into memory
-	err = loadPersonEmails()
+	// Nothing to load (email templates are loaded by the notifications package)
 
 	return
 }
@@ -3,13 +3,17 @@ package models
 import (
 	"context"
 	"database/sql"
+	"strings"
 
 	"github.com/friendsofgo/errors"
 	validation "github.com/go-ozzo/ozzo-validation"
 	"github.com/go-ozzo/ozzo-validation/is"
+	"github.com/mrz1836/go-api/caching"
+	"github.com/mrz1836/go-api/config"
 	"github.com/mrz1836/go-api/database"
 	"github.com/mrz1836/go-api/models/schema"
 	"github.com/mrz1836/go-sanitize"
+	"github.com/volatiletech/null/v8"
 	"github.com/volatiletech/sqlboiler/v4/boil"
 	"github.com/volatiletech/sqlboiler/v4/queries/qm"
 )
@@ -20,7 +24,9 @@ var (
 		schema.PersonColumns.Email,
 		schema.PersonColumns.FirstName,
 		schema.PersonColumns.LastName,
+		schema.PersonColumns.Locale,
 		schema.PersonColumns.MiddleName,
+		schema.PersonColumns.Phone,
 	)
 
 	// PersonUpdateColumns columns only allowed in update
@@ -28,7 +34,9 @@ var (
 		schema.PersonColumns.Email,
 		schema.PersonColumns.FirstName,
 		schema.PersonColumns.LastName,
+		schema.PersonColumns.Locale,
 		schema.PersonColumns.MiddleName,
+		schema.PersonColumns.Phone,
 	)
 
 	// PersonDeleteColumns columns only allowed in delete
@@ -43,11 +51,19 @@ var (
 		schema.PersonColumns.FirstName,
 		schema.PersonColumns.ID,
 		schema.PersonColumns.LastName,
+		schema.PersonColumns.Locale,
 		schema.PersonColumns.MiddleName,
 		schema.PersonColumns.ModifiedAt,
+		schema.PersonColumns.Phone,
 	}
 )
 
+// ErrPersonNotDeleted is when purging a person that is not deleted
+var ErrPersonNotDeleted = errors.New("person must be deleted before it is purged")
+
+// phoneFormatting removes the formatting from phone numbers
+var phoneFormatting = strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "")
+
 // Person extends the schema model
 type Person struct {
 	schema.Person
@@ -72,15 +88,35 @@ func NewPersonsUsingSchema(personSlice schema.PersonSlice) []Person {
 	return persons
 }
 
-// GetPersonByID gets a person by ID
-func GetPersonByID(id uint64) (person *Person, err error) {
+// GetPersonByID gets a person by ID (read-through cache)
+func GetPersonByID(ctx context.Context, id uint64) (person *Person, err error) {
 
 	// Start with a schema
 	var p *schema.Person
 
-	// Find the associated record
-	p, err = schema.FindPerson(context.Background(), database.ReadDatabase, id) // todo: turn slice of strings into variadic
-	if err != nil {
+	// Find the associated record (cache, then database)
+	p, err = caching.Get(ctx, personCacheKeyID(id), caching.DefaultOptions(), func(ctx context.Context) (*schema.Person, []string, error) {
+		found, findErr := schema.FindPerson(ctx, database.ReadDatabase, id) // todo: turn slice of strings into variadic
+		return personFromQuery(found, findErr)
+	})
+	if err != nil || p == nil {
+		return
+	}
+
+	// Create a new model with existing schema
+	person = NewPersonUsingSchema(*p)
+
+	return
+}
+
+// GetPersonForUpdate gets a person by ID and locks the row in the transaction (never cached, use before saving)
+func GetPersonForUpdate(ctx context.Context, tx *database.Tx, id uint64) (person *Person, err error) {
+
+	// Find and lock the record
+	var p *schema.Person
+	if p, err = schema.Persons(
+		qm.Where(schema.PersonColumns.ID+" = ?", id), qm.For("UPDATE"),
+	).One(ctx, tx); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			err = nil
 		}
@@ -93,18 +129,18 @@ func GetPersonByID(id uint64) (person *Person, err error) {
 	return
 }
 
-// GetPersonByEmail gets a person by email address
-func GetPersonByEmail(email string) (person *Person, err error) {
+// GetPersonByEmail gets a person by email address (read-through cache)
+func GetPersonByEmail(ctx context.Context, email string) (person *Person, err error) {
 
 	// Start with a schema
 	var p *schema.Person
 
-	// Find the associated record
-	p, err = schema.Persons(qm.Where(schema.PersonColumns.Email+" = ?", email)).One(context.Background(), database.ReadDatabase)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			err = nil
-		}
+	// Find the associated record (cache, then database)
+	p, err = caching.Get(ctx, personCacheKeyEmail(email), caching.DefaultOptions(), func(ctx context.Context) (*schema.Person, []string, error) {
+		found, findErr := schema.Persons(qm.Where(schema.PersonColumns.Email+" = ?", email)).One(ctx, database.ReadDatabase)
+		return personFromQuery(found, findErr)
+	})
+	if err != nil || p == nil {
 		return
 	}
 
@@ -115,14 +151,14 @@ func GetPersonByEmail(email string) (person *Person, err error) {
 }
 
 // GetPersons gets an array of person  //todo: temporary for now
-func GetPersons() (persons []Person, err error) {
+func GetPersons(ctx context.Context) (persons []Person, err error) {
 
 	// Start with a schema
 	var p schema.PersonSlice
 
 	// Find the associated record
 	p, err = schema.Persons(
-		qm.Where(schema.PersonColumns.IsDeleted+" = ?", 0)).All(context.Background(), database.ReadDatabase)
+		qm.Where(schema.PersonColumns.IsDeleted+" = ?", 0)).All(ctx, database.ReadDatabase)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			err = nil
@@ -146,6 +182,16 @@ func (p *Person) BeforeValidate() {
 	p.FirstName = sanitize.FormalName(p.FirstName)
 	p.MiddleName = sanitize.FormalName(p.MiddleName)
 	p.LastName = sanitize.FormalName(p.LastName)
+
+	// Default locale, otherwise lowercase with dashes (IE: es_MX is es-mx)
+	if len(p.Locale) == 0 {
+		p.Locale = config.Values.Email.DefaultLocale
+	} else {
+		p.Locale = strings.ReplaceAll(strings.ToLower(p.Locale), "_", "-")
+	}
+
+	// Phone without formatting (IE: +1 (555) 555-0100 is +15555550100)
+	p.Phone = phoneFormatting.Replace(strings.TrimSpace(p.Phone))
 }
 
 // Validate checks the model, struct and any custom validations
@@ -161,12 +207,16 @@ func (p *Person) Validate() error {
 		validation.Field(&p.Email, validation.Required, is.Email),
 		validation.Field(&p.FirstName, validation.Length(0, 50)),
 		validation.Field(&p.LastName, validation.Length(0, 50)),
+		validation.Field(&p.Locale, validation.Required, validation.Length(2, 10), validation.Match(config.LocalePattern)),
 		validation.Field(&p.MiddleName, validation.Length(0, 50)),
+		validation.Field(&p.Phone, validation.Match(config.PhonePattern)),
 	)
 }
 
-// Save either inserts or updates a model
-func (p *Person) Save(columns boil.Columns, tx *sql.Tx) (rowsAffected int64, err error) {
+// Save either inserts or updates a model and records the event and audit in the transaction (the context carries the request values, IE: request ID)
+//
+// The cached person is invalidated after the commit (a read before the commit would cache the old version again)
+func (p *Person) Save(ctx context.Context, columns boil.Columns, tx *database.Tx) (rowsAffected int64, err error) {
 
 	// Validate the model
 	err = p.Validate()
@@ -174,13 +224,87 @@ func (p *Person) Save(columns boil.Columns, tx *sql.Tx) (rowsAffected int64, err
 		return
 	}
 
+	// Lock the current version (for the audit changes)
+	inserted := p.ID == 0
+	var before *schema.Person
+	if !inserted {
+		if before, err = schema.Persons(
+			qm.Where(schema.PersonColumns.ID+" = ?", p.ID), qm.For("UPDATE"),
+		).One(ctx, tx); err != nil {
+			return
+		}
+	}
+
 	// Try to insert the model
-	if p.ID == 0 {
+	if inserted {
 		rowsAffected = 1
-		err = p.Insert(context.Background(), tx, columns)
+		err = p.Insert(ctx, tx, columns)
 	} else {
-		rowsAffected, err = p.Update(context.Background(), tx, columns)
+		rowsAffected, err = p.Update(ctx, tx, columns)
+	}
+	if err != nil || rowsAffected == 0 {
+		return
 	}
 
+	// The stored version (a whitelist update leaves the other columns as they were, not as they are in memory)
+	after := &p.Person
+	if !inserted {
+		if after, err = schema.Persons(
+			qm.Where(schema.PersonColumns.ID+" = ?", p.ID),
+		).One(ctx, tx); err != nil {
+			return
+		}
+	}
+
+	// Record the event (published after the commit) and the audit
+	action := p.personAction(inserted, columns)
+	if err = recordSaveEvent(ctx, tx.Tx, after, action, columns); err != nil {
+		return
+	}
+	if err = recordAudit(ctx, tx.Tx, p.ID, action, before, after); err != nil {
+		return
+	}
+
+	// Invalidate the cache once committed
+	invalidateAfterCommit(ctx, tx, p.ID, p.Email)
+
+	return
+}
+
+// Restore marks a deleted person as not deleted
+func (p *Person) Restore(ctx context.Context, tx *database.Tx) (rowsAffected int64, err error) {
+	p.IsDeleted = null.BoolFrom(false)
+	return p.Save(ctx, PersonDeleteColumns, tx)
+}
+
+// Purge removes a deleted person and their auth, preferences and push subscriptions for good (email logs are kept)
+func (p *Person) Purge(ctx context.Context, tx *database.Tx) (err error) {
+	if !p.IsDeleted.Bool {
+		return ErrPersonNotDeleted
+	}
+
+	// Records that belong to the person
+	for _, table := range []string{"auths", "notification_preferences", "push_subscriptions"} {
+		if _, err = tx.ExecContext(ctx, "DELETE FROM `"+table+"` WHERE `person_id` = ?", p.ID); err != nil {
+			return
+		}
+	}
+
+	// The person
+	if _, err = p.Delete(ctx, tx); err != nil {
+		return
+	}
+
+	// Record the event (published after the commit) and the audit (the history is kept)
+	if err = p.recordPurgeEvent(ctx, tx.Tx); err != nil {
+		return
+	}
+	if err = recordAudit(ctx, tx.Tx, p.ID, PersonAuditPurge, &p.Person, nil); err != nil {
+		return
+	}
+
+	// Invalidate the cache once committed
+	invalidateAfterCommit(ctx, tx, p.ID, p.Email)
+
 	return
 }
@@ -0,0 +1,143 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"time"
+
+	"github.com/mrz1836/go-api/database"
+	"github.com/mrz1836/go-api/models/schema"
+	"github.com/mrz1836/go-api/request"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+	"github.com/volatiletech/sqlboiler/v4/queries"
+)
+
+// Person audit actions (person_audit table)
+const (
+	PersonAuditDelete  = "delete" // Soft delete (is_deleted)
+	PersonAuditInsert  = "insert"
+	PersonAuditPurge   = "purge" // Removed for good
+	PersonAuditRestore = "restore"
+	PersonAuditUpdate  = "update"
+)
+
+// auditIgnoredColumns are not part of the changes (set by the database)
+var auditIgnoredColumns = []string{
+	schema.PersonColumns.CreatedAt,
+	schema.PersonColumns.ModifiedAt,
+}
+
+// PersonAudit is a change to a person (person_audit table)
+type PersonAudit struct {
+	Action    string                  `boil:"action" json:"action"`
+	Actor     string                  `boil:"actor" json:"actor"`
+	Changes   map[string]*AuditChange `boil:"-" json:"changes"`
+	CreatedAt time.Time               `boil:"created_at" json:"created_at"`
+	ID        uint64                  `boil:"id" json:"id"`
+	IPAddress string                  `boil:"ip_address" json:"ip_address"`
+	PersonID  uint64                  `boil:"person_id" json:"person_id"`
+	RequestID string                  `boil:"request_id" json:"request_id"`
+	Stored    json.RawMessage         `boil:"changes" json:"-"`
+}
+
+// AuditChange is a changed column (from is null when inserted, to is null when purged)
+type AuditChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// GetPersonHistory gets the person's changes, newest first (page with before, the ID of the last change returned)
+func GetPersonHistory(ctx context.Context, personID, before uint64, limit int) (history []*PersonAudit, err error) {
+	query := "SELECT * FROM `person_audit` WHERE `person_id` = ?"
+	args := []interface{}{personID}
+	if before > 0 {
+		query += " AND `id` < ?"
+		args = append(args, before)
+	}
+	query += " ORDER BY `id` DESC LIMIT ?"
+	args = append(args, limit)
+
+	if err = queries.Raw(query, args...).Bind(ctx, database.ReadDatabase, &history); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	for _, audit := range history {
+		if err = json.Unmarshal(audit.Stored, &audit.Changes); err != nil {
+			return nil, err
+		}
+	}
+	return history, nil
+}
+
+// personAction returns the action of the save (delete and restore only save is_deleted)
+func (p *Person) personAction(inserted bool, columns boil.Columns) string {
+	switch {
+	case inserted:
+		return PersonAuditInsert
+	case columns.IsWhitelist() && len(columns.Cols) == 1 && columns.Cols[0] == schema.PersonColumns.IsDeleted:
+		if p.IsDeleted.Bool {
+			return PersonAuditDelete
+		}
+		return PersonAuditRestore
+	default:
+		return PersonAuditUpdate
+	}
+}
+
+// recordAudit writes the change to the audit in the same transaction (before is nil when inserted, after is nil when purged)
+func recordAudit(ctx context.Context, tx *sql.Tx, personID uint64, action string, before, after *schema.Person) (err error) {
+
+	// The changed columns
+	var changes []byte
+	if changes, err = json.Marshal(auditChanges(before, after)); err != nil {
+		return
+	}
+
+	// The actor comes from the request (auth principal, job or task)
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO `person_audit` (`person_id`, `action`, `actor`, `request_id`, `ip_address`, `changes`, `created_at`) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		personID, action, request.Principal(ctx), request.ID(ctx), request.IPAddress(ctx), changes, time.Now().UTC(),
+	)
+	return
+}
+
+// auditChanges returns the columns that are different between the two versions (either can be nil)
+func auditChanges(before, after *schema.Person) map[string]*AuditChange {
+	from, to := auditColumns(before), auditColumns(after)
+	changes := make(map[string]*AuditChange)
+	for column, value := range to {
+		previous, ok := from[column]
+		if (ok && reflect.DeepEqual(previous, value)) || (!ok && emptyAuditValue(value)) {
+			continue
+		}
+		changes[column] = &AuditChange{From: previous, To: value}
+	}
+	for column, value := range from {
+		if _, ok := to[column]; !ok && !emptyAuditValue(value) {
+			changes[column] = &AuditChange{From: value}
+		}
+	}
+	return changes
+}
+
+// auditColumns returns the person's column values by name (nil is no columns)
+func auditColumns(person *schema.Person) map[string]interface{} {
+	columns := make(map[string]interface{})
+	if person == nil {
+		return columns
+	}
+	if encoded, err := json.Marshal(person); err == nil {
+		_ = json.Unmarshal(encoded, &columns)
+	}
+	for _, column := range auditIgnoredColumns {
+		delete(columns, column)
+	}
+	return columns
+}
+
+// emptyAuditValue returns true if the value is not worth showing when inserted or purged
+func emptyAuditValue(value interface{}) bool {
+	return value == nil || value == "" || value == false
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/friendsofgo/errors"
+	"github.com/mrz1836/go-api/caching"
+	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-api/database"
+	"github.com/mrz1836/go-api/models/schema"
+	"github.com/mrz1836/go-api/request"
+	"github.com/mrz1836/go-logger"
+)
+
+// PersonsCacheTag is purged whenever any person changes (use in response_cache.routes tags)
+const PersonsCacheTag = "persons"
+
+// personCacheKeyID is the cache key for a person by ID
+func personCacheKeyID(id uint64) string {
+	return fmt.Sprintf("person:id:%d", id)
+}
+
+// personCacheKeyEmail is the cache key for a person by email
+func personCacheKeyEmail(email string) string {
+	return "person:email:" + strings.ToLower(email)
+}
+
+// personCacheDependency is the dependency for all cached values of a person
+func personCacheDependency(id uint64) string {
+	return fmt.Sprintf("person:%d", id)
+}
+
+// personFromQuery returns the result of a query for the cache (not found is not an error)
+func personFromQuery(person *schema.Person, err error) (*schema.Person, []string, error) {
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	return person, []string{personCacheDependency(person.ID)}, nil
+}
+
+// invalidateAfterCommit removes the cached person once the transaction is committed (see invalidatePersonCache)
+func invalidateAfterCommit(ctx context.Context, tx *database.Tx, id uint64, email string) {
+	tx.AfterCommit(func() {
+		invalidatePersonCache(ctx, id, email)
+	})
+}
+
+// invalidatePersonCache removes all cached values and responses for the person (including cached misses)
+//
+// Runs after the transaction is committed, otherwise a read before the commit caches the old version again
+func invalidatePersonCache(ctx context.Context, id uint64, email string) {
+
+	// Nothing is cached
+	if !config.Values.ModelCache.Enabled && !config.Values.ResponseCache.Enabled {
+		return
+	}
+
+	// Remove the keys directly (misses have no dependencies)
+	err := caching.Delete(ctx, personCacheKeyID(id), personCacheKeyEmail(email))
+	if err == nil {
+		// Remove anything depending on the person (IE: a previous email address, cached responses)
+		err = caching.Invalidate(ctx, personCacheDependency(id), PersonsCacheTag)
+	}
+
+	// Never fail the write because of the cache (entries will expire)
+	if err != nil {
+		logger.Data(2, logger.ERROR, "error invalidating person cache: "+err.Error(), request.LogParameters(ctx)...)
+	}
+}
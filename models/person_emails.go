@@ -2,69 +2,79 @@ package models
 
 import (
 	"context"
-	"html/template"
-	"path/filepath"
 
-	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-api/database"
+	"github.com/mrz1836/go-api/models/schema"
 	"github.com/mrz1836/go-api/notifications"
 	"github.com/mrz1836/go-mail"
 )
 
-// Define the template vars
-var (
-	emailPersonExampleHTML *template.Template
-	emailPersonExampleText *template.Template
-)
+// EmailExampleData is the email struct for the data
+type EmailExampleData struct {
+	Person
+	SupportEmail string `json:"support_email"`
+}
+
+// EmailKindPersonExample is the kind of the example email (email_outbox table)
+const EmailKindPersonExample = "person_example"
 
-// loadPersonEmails load the email templates (fired from StartUp)
-func loadPersonEmails() (err error) {
+// emailPersonExample is the example email template (static/views/emails/persons/example_email)
+var emailPersonExample = notifications.DefineTemplate[EmailExampleData]("persons/example_email", "Your example email subject line").
+	WithCategory(notifications.CategoryProduct).
+	WithSample(&EmailExampleData{
+		Person:       Person{schema.Person{Email: "jane@example.com", FirstName: "Jane", LastName: "Doe", Locale: "en"}},
+		SupportEmail: "support@example.com",
+	})
 
-	// Load the email
-	email := notifications.Service.EmailService.NewEmail()
-	currentDirectory := config.GetCurrentDir()
+// SendExampleEmail sends an example email
+func (p *Person) SendExampleEmail(ctx context.Context) (err error) {
 
-	// Parse the text version, store in local memory
-	emailPersonExampleText, err = email.ParseTemplate(filepath.Join(currentDirectory, "..", "static", "views", "emails", "persons", "example_email.txt"))
-	if err != nil {
+	// Render the email
+	var email *gomail.Email
+	if email, err = p.exampleEmail(); err != nil {
 		return
 	}
 
-	// Parse the html version, store in local memory
-	emailPersonExampleHTML, err = email.ParseHTMLTemplate(filepath.Join(currentDirectory, "..", "static", "views", "emails", "persons", "example_email.html"))
+	// Send the email (if subscribed, using the configured providers in order)
+	_, _, err = notifications.DeliverToPerson(ctx, p.ID, email)
 
 	return
 }
 
-// EmailExampleData is the email struct for the data
-type EmailExampleData struct {
-	Person
-	SupportEmail string `json:"support_email"`
+// QueueExampleEmail queues the example email in the transaction (sent by the email-outbox job after the commit)
+func (p *Person) QueueExampleEmail(ctx context.Context, tx *database.Tx) (err error) {
+
+	// Render the email
+	var email *gomail.Email
+	if email, err = p.exampleEmail(); err != nil {
+		return
+	}
+
+	// Add to the outbox
+	_, err = notifications.QueueEmailTx(ctx, tx.Tx, EmailKindPersonExample, p.ID, email)
+
+	return
 }
 
-// SendExampleEmail sends an example email
-func (p *Person) SendExampleEmail(ctx context.Context) (err error) {
+// exampleEmail renders the example email
+func (p *Person) exampleEmail() (email *gomail.Email, err error) {
 
 	// Create the data struct
 	data := new(EmailExampleData)
 	data.Person = *p
 	data.SupportEmail = "support@example.com"
 
+	// Render the templates (in the person's language)
+	var rendered *notifications.RenderedEmail
+	if rendered, err = emailPersonExample.RenderLocale(p.Locale, data); err != nil {
+		return
+	}
+
 	// Start a new email
-	email := notifications.Service.EmailService.NewEmail()
-	email.Recipients = append(email.Recipients, data.Email)
+	email = notifications.NewEmail(rendered, data.Email)
 	email.FromName = "Acme"
-	email.Subject = "Your example email subject line"
 	email.Tags = append(email.Tags, "example_tag")
 	email.TrackOpens = true
 
-	// Apply the templates
-	err = email.ApplyTemplates(emailPersonExampleHTML, emailPersonExampleText, data)
-	if err != nil {
-		return
-	}
-
-	// Send the email
-	err = notifications.Service.EmailService.SendEmail(ctx, email, gomail.SMTP)
-
 	return
 }
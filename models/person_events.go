@@ -0,0 +1,47 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/mrz1836/go-api/events"
+	"github.com/mrz1836/go-api/models/schema"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+)
+
+// recordSaveEvent writes the event for the save to the outbox in the same transaction (created, updated, deleted or restored)
+func recordSaveEvent(ctx context.Context, tx *sql.Tx, person *schema.Person, action string, columns boil.Columns) (err error) {
+
+	// The person after the change
+	personEvent := events.PersonEvent{PersonID: person.ID}
+	if personEvent.Person, err = json.Marshal(person); err != nil {
+		return
+	}
+
+	// Which change it was
+	var event events.Event
+	switch action {
+	case PersonAuditInsert:
+		event = events.PersonCreated{PersonEvent: personEvent}
+	case PersonAuditDelete:
+		event = events.PersonDeleted{PersonEvent: personEvent}
+	case PersonAuditRestore:
+		event = events.PersonRestored{PersonEvent: personEvent}
+	default:
+		updated := events.PersonUpdated{PersonEvent: personEvent}
+		if columns.IsWhitelist() {
+			updated.Columns = columns.Cols
+		}
+		event = updated
+	}
+
+	_, err = events.Record(ctx, tx, event)
+	return
+}
+
+// recordPurgeEvent writes the purged event to the outbox in the same transaction (only the ID is kept)
+func (p *Person) recordPurgeEvent(ctx context.Context, tx *sql.Tx) (err error) {
+	_, err = events.Record(ctx, tx, events.PersonPurged{PersonEvent: events.PersonEvent{PersonID: p.ID}})
+	return
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"context"
+
+	"github.com/mrz1836/go-api/notifications"
+	"github.com/mrz1836/go-mail"
+)
+
+// Recipient returns the person's addresses for the notification channels (push subscriptions are loaded when sent)
+func (p *Person) Recipient() *notifications.Recipient {
+	return &notifications.Recipient{
+		Email:    p.Email,
+		PersonID: p.ID,
+		Phone:    p.Phone,
+	}
+}
+
+// NotifyExample sends the example notification on the person's preferred channels (returns the channels that were sent)
+func (p *Person) NotifyExample(ctx context.Context) (sent []string, err error) {
+
+	// Render the email
+	var email *gomail.Email
+	if email, err = p.exampleEmail(); err != nil {
+		return
+	}
+
+	// Send on each channel (if subscribed)
+	return notifications.Notify(ctx, p.Recipient(), &notifications.Notification{
+		Category: notifications.CategoryProduct,
+		Email:    email,
+		Push:     &notifications.PushMessage{Body: "This is an example notification.", Title: "Example notification"},
+		SMS:      "This is an example notification from Acme.",
+	})
+}
@@ -0,0 +1,210 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mrz1836/go-api/request"
+	"github.com/mrz1836/go-logger"
+	"github.com/mrz1836/go-mail"
+)
+
+// Notification channels
+const (
+	ChannelEmail = "email"
+	ChannelPush  = "push"
+	ChannelSMS   = "sms"
+)
+
+var (
+	// ErrNoChannelAvailable is when none of the person's channels are configured, reachable or in the notification
+	ErrNoChannelAvailable = errors.New("no notification channel is available")
+
+	// ErrUnknownChannel is when the channel is not one of the notification channels
+	ErrUnknownChannel = errors.New("unknown notification channel")
+)
+
+// Channel sends a notification to a recipient (email, push or sms)
+type Channel interface {
+	Enabled() bool                                                                    // The provider is configured
+	Name() string                                                                     // IE: email
+	Reaches(recipient *Recipient, notification *Notification) bool                    // The recipient has an address and the notification has content
+	Send(ctx context.Context, recipient *Recipient, notification *Notification) error // Delivers the notification
+}
+
+// Recipient is who receives a notification (the addresses for each channel)
+type Recipient struct {
+	Email             string
+	PersonID          uint64
+	Phone             string              // E.164 (IE: +15555550100)
+	PushSubscriptions []*PushSubscription // Loaded from the database if nil
+}
+
+// Notification is the content for each channel (channels without content are skipped)
+type Notification struct {
+	Category string        // Notification category (empty is transactional)
+	Email    *gomail.Email // Recipients default to the recipient's email
+	Push     *PushMessage
+	SMS      string
+}
+
+// channels are the notification channels (in the order they are tried)
+var channels = []Channel{
+	new(emailChannel),
+	new(pushChannel),
+	new(smsChannel),
+}
+
+// Channels returns the notification channel names
+func Channels() (names []string) {
+	for _, channel := range channels {
+		names = append(names, channel.Name())
+	}
+	return
+}
+
+// UseFakeChannels replaces the push and sms providers with fakes (test helper, use with UseMailbox for email)
+func UseFakeChannels() (push *FakePush, sms *FakeSMS) {
+	if Service == nil {
+		Service = new(notificationService)
+	}
+	push, sms = new(FakePush), new(FakeSMS)
+	Service.push, Service.sms = push, sms
+	return
+}
+
+// Notify sends the notification on the channels the person prefers for the category (returns the channels that were sent)
+func Notify(ctx context.Context, recipient *Recipient, notification *Notification) (sent []string, err error) {
+
+	// Check the preference
+	var preference *NotificationPreference
+	if preference, err = getPreference(ctx, recipient.PersonID, notification.Category); err != nil {
+		return
+	} else if !preference.Subscribed {
+		return nil, fmt.Errorf("%w: %s", ErrUnsubscribed, notification.Category)
+	}
+
+	// Send on each preferred channel (skipping channels that are not configured or can not reach the recipient)
+	var errs []error
+	for _, channel := range channels {
+		if !containsChannel(preference.Channels, channel.Name()) || !channel.Enabled() || !channel.Reaches(recipient, notification) {
+			continue
+		}
+		if sendErr := channel.Send(ctx, recipient, notification); errors.Is(sendErr, ErrNoChannelAvailable) {
+			continue // IE: no push subscriptions
+		} else if sendErr != nil {
+			logger.Data(2, logger.ERROR, "failed sending "+channel.Name()+" notification: "+sendErr.Error(), request.LogParameters(ctx)...)
+			errs = append(errs, fmt.Errorf("%s: %w", channel.Name(), sendErr))
+			continue
+		}
+		sent = append(sent, channel.Name())
+	}
+
+	// Nothing was sent
+	if len(errs) > 0 {
+		return sent, errors.Join(errs...)
+	} else if len(sent) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoChannelAvailable, strings.Join(preference.Channels, ", "))
+	}
+	return
+}
+
+// emailChannel sends the email using the provider chain (with the one-click unsubscribe headers)
+type emailChannel struct{}
+
+// Enabled returns true if the email service is loaded
+func (c *emailChannel) Enabled() bool {
+	return Service != nil && Service.providers != nil
+}
+
+// Name returns the channel name
+func (c *emailChannel) Name() string {
+	return ChannelEmail
+}
+
+// Reaches returns true if there is an email and a recipient
+func (c *emailChannel) Reaches(recipient *Recipient, notification *Notification) bool {
+	return notification.Email != nil && (len(recipient.Email) > 0 || len(notification.Email.Recipients) > 0)
+}
+
+// Send delivers a copy of the email (the recipient's email is used if the email has no recipients)
+func (c *emailChannel) Send(ctx context.Context, recipient *Recipient, notification *Notification) (err error) {
+	email := *notification.Email
+	if len(email.Recipients) == 0 {
+		email.Recipients = []string{recipient.Email}
+	}
+	_, _, err = deliver(ctx, &email, UnsubscribeHeaders(recipient.PersonID, notification.Category))
+	return
+}
+
+// pushChannel sends a web push to each of the person's browsers
+type pushChannel struct{}
+
+// Enabled returns true if a push provider is configured
+func (c *pushChannel) Enabled() bool {
+	return Service != nil && Service.push != nil
+}
+
+// Name returns the channel name
+func (c *pushChannel) Name() string {
+	return ChannelPush
+}
+
+// Reaches returns true if there is a push message and the person could have subscriptions
+func (c *pushChannel) Reaches(recipient *Recipient, notification *Notification) bool {
+	return notification.Push != nil && (recipient.PersonID > 0 || len(recipient.PushSubscriptions) > 0)
+}
+
+// Send pushes the message to every subscription (gone subscriptions are removed)
+func (c *pushChannel) Send(ctx context.Context, recipient *Recipient, notification *Notification) error {
+	return SendPush(ctx, recipient, notification.Push)
+}
+
+// smsChannel sends a text message to the person's phone
+type smsChannel struct{}
+
+// Enabled returns true if an sms provider is configured
+func (c *smsChannel) Enabled() bool {
+	return Service != nil && Service.sms != nil
+}
+
+// Name returns the channel name
+func (c *smsChannel) Name() string {
+	return ChannelSMS
+}
+
+// Reaches returns true if there is a text and a phone number
+func (c *smsChannel) Reaches(recipient *Recipient, notification *Notification) bool {
+	return len(notification.SMS) > 0 && len(recipient.Phone) > 0
+}
+
+// Send sends the text message
+func (c *smsChannel) Send(ctx context.Context, recipient *Recipient, notification *Notification) (err error) {
+	_, err = SendSMS(ctx, recipient.Phone, notification.SMS)
+	return
+}
+
+// checkChannels returns an error if a channel is unknown or the list is empty
+func checkChannels(names []string) error {
+	if len(names) == 0 {
+		return fmt.Errorf("%w: no channels", ErrUnknownChannel)
+	}
+	for _, name := range names {
+		if !containsChannel(Channels(), name) {
+			return fmt.Errorf("%w: %s", ErrUnknownChannel, name)
+		}
+	}
+	return nil
+}
+
+// containsChannel returns true if the channel is in the list
+func containsChannel(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
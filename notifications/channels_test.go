@@ -0,0 +1,114 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mrz1836/go-api/config"
+)
+
+// TestNotify tests routing notifications to the default channels of each category (fake push and sms providers)
+func TestNotify(t *testing.T) {
+	config.Values.Email.FromDomain = "example.com"
+	config.Values.Email.FromName = "No Reply"
+	config.Values.Email.FromUsername = "no-reply"
+	config.Values.Email.Unsubscribe.Secret = "test-unsubscribe-secret-0123456789abcdef"
+	config.Values.Email.Unsubscribe.URL = "https://api.example.com/unsubscribe"
+	Service = nil
+	UseMailbox()
+	push, sms := UseFakeChannels()
+
+	recipient := &Recipient{
+		Email:             "jane@example.com",
+		PersonID:          123,
+		Phone:             "+15555550100",
+		PushSubscriptions: []*PushSubscription{{Endpoint: "https://fcm.googleapis.com/fcm/send/abc", PersonID: 123}},
+	}
+	newNotification := func(category string) *Notification {
+		return &Notification{
+			Category: category,
+			Email:    NewEmail(&RenderedEmail{HTML: "<p>Hello</p>", Subject: "Hello", Text: "Hello"}),
+			Push:     &PushMessage{Body: "Hello", Title: "Hello"},
+			SMS:      "Hello",
+		}
+	}
+
+	tests := []struct {
+		name     string
+		category string
+		expected []string
+		headers  bool
+	}{
+		{"transactional", "", []string{ChannelEmail, ChannelPush, ChannelSMS}, false},
+		{"product", CategoryProduct, []string{ChannelEmail, ChannelPush}, true},
+		{"security", CategorySecurity, []string{ChannelEmail, ChannelSMS}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			UseMailbox()
+			pushed, texted := len(push.Messages()), len(sms.Messages())
+
+			notification := newNotification(test.category)
+			sent, err := Notify(context.Background(), recipient, notification)
+			if err != nil {
+				t.Fatalf("error sending notification: %s", err.Error())
+			}
+			if len(sent) != len(test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, sent)
+			}
+			for i, channel := range test.expected {
+				if sent[i] != channel {
+					t.Fatalf("expected %v, got %v", test.expected, sent)
+				}
+			}
+
+			// Each channel received it once (the caller's email is not changed)
+			emails := SentEmailsTo(recipient.Email)
+			if len(emails) != 1 {
+				t.Fatalf("expected 1 email, got %d", len(emails))
+			}
+			if len(notification.Email.Recipients) != 0 {
+				t.Fatalf("expected the notification email to have no recipients, got %v", notification.Email.Recipients)
+			}
+			if _, ok := emails[0].Headers["List-Unsubscribe"]; ok != test.headers {
+				t.Fatalf("expected the unsubscribe headers %t, got %t", test.headers, ok)
+			}
+			if containsChannel(test.expected, ChannelPush) != (len(push.Messages()) == pushed+1) {
+				t.Fatalf("expected push %t, got %d messages", containsChannel(test.expected, ChannelPush), len(push.Messages())-pushed)
+			}
+			if containsChannel(test.expected, ChannelSMS) != (len(sms.Messages()) == texted+1) {
+				t.Fatalf("expected sms %t, got %d messages", containsChannel(test.expected, ChannelSMS), len(sms.Messages())-texted)
+			}
+		})
+	}
+
+	t.Run("unsubscribed by default", func(t *testing.T) {
+		if _, err := Notify(context.Background(), recipient, newNotification(CategoryMarketing)); !errors.Is(err, ErrUnsubscribed) {
+			t.Fatalf("expected %s, got %v", ErrUnsubscribed, err)
+		}
+	})
+
+	t.Run("failed channel", func(t *testing.T) {
+		UseMailbox()
+		sms.Fail(errors.New("sms provider error"))
+		defer sms.Fail(nil)
+
+		sent, err := Notify(context.Background(), recipient, newNotification(CategorySecurity))
+		if err == nil {
+			t.Fatal("expected the sms error")
+		}
+		if len(sent) != 1 || sent[0] != ChannelEmail {
+			t.Fatalf("expected [%s], got %v", ChannelEmail, sent)
+		}
+	})
+
+	t.Run("expired push subscription", func(t *testing.T) {
+		push.Expire(recipient.PushSubscriptions[0].Endpoint)
+		notification := newNotification(CategoryProduct)
+		notification.Email = nil
+		if _, err := Notify(context.Background(), recipient, notification); !errors.Is(err, ErrNoChannelAvailable) {
+			t.Fatalf("expected %s, got %v", ErrNoChannelAvailable, err)
+		}
+	})
+}
@@ -1,6 +1,8 @@
 package notifications
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
@@ -46,3 +48,20 @@ func loadEmailService() (service *gomail.MailService, err error) {
 
 	return
 }
+
+// CheckEmailService checks that the email service is loaded and has a provider configured (used for readiness)
+//
+// Open breakers are not checked (a provider outage should not take the service out of rotation, see ProvidersHealth)
+func CheckEmailService(_ context.Context) error {
+
+	// Service not started?
+	if Service == nil || Service.EmailService == nil {
+		return errors.New("email service is not loaded")
+	}
+
+	// At least one provider needs to be configured
+	if len(ProvidersHealth()) == 0 {
+		return errors.New("no email provider is configured")
+	}
+	return nil
+}
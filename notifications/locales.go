@@ -0,0 +1,86 @@
+package notifications
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/mrz1836/go-api/config"
+)
+
+// subjectKeySuffix is the catalog key for a template subject (IE: persons/example_email.subject)
+const subjectKeySuffix = ".subject"
+
+// NormalizeLocale returns the locale in lowercase with dashes (IE: es_MX is es-mx), empty if it is not a valid locale
+func NormalizeLocale(locale string) string {
+	locale = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(locale)), "_", "-")
+	if !config.LocalePattern.MatchString(locale) {
+		return ""
+	}
+	return locale
+}
+
+// localeCandidates returns the locales to try in order (IE: es-mx, es, then the default)
+func localeCandidates(locale string) (candidates []string) {
+	if locale = NormalizeLocale(locale); len(locale) > 0 && locale != config.Values.Email.DefaultLocale {
+		candidates = append(candidates, locale)
+		if language, _, found := strings.Cut(locale, "-"); found && language != config.Values.Email.DefaultLocale {
+			candidates = append(candidates, language)
+		}
+	}
+	return append(candidates, "")
+}
+
+// splitLocale splits the locale suffix from a template name (IE: persons/example_email.es)
+func splitLocale(name string) (string, string) {
+	ext := path.Ext(name)
+	if locale := NormalizeLocale(strings.TrimPrefix(ext, ".")); len(locale) > 0 {
+		return strings.TrimSuffix(name, ext), locale
+	}
+	return name, ""
+}
+
+// localizedName returns the template name with the locale suffix (used for template names and errors)
+func localizedName(name, locale string) string {
+	if len(locale) == 0 {
+		return name
+	}
+	return name + "." + locale
+}
+
+// loadCatalog reads a message catalog (IE: locales/es.json with {"persons/example_email.subject": "..."})
+func loadCatalog(catalogs map[string]map[string]string, filePath string, content []byte) error {
+	locale := NormalizeLocale(strings.TrimSuffix(path.Base(filePath), extJSON))
+	if len(locale) == 0 {
+		return fmt.Errorf("invalid locale for message catalog: %s", filePath)
+	}
+	messages := make(map[string]string)
+	if err := json.Unmarshal(content, &messages); err != nil {
+		return fmt.Errorf("error parsing message catalog %s: %w", filePath, err)
+	}
+	catalogs[locale] = messages
+	return nil
+}
+
+// applyCatalogs parses the translated subjects for each template and locale
+func applyCatalogs(loaded map[string]localizedTemplates, catalogs map[string]map[string]string) error {
+	for locale, messages := range catalogs {
+		for key, message := range messages {
+			name, ok := strings.CutSuffix(key, subjectKeySuffix)
+			if !ok || loaded[name] == nil {
+				continue
+			}
+			subject, err := texttemplate.New(localizedName(name, locale) + subjectKeySuffix).Option("missingkey=error").Parse(message)
+			if err != nil {
+				return fmt.Errorf("error parsing message %s for locale %s: %w", key, locale, err)
+			}
+			if loaded[name][locale] == nil {
+				loaded[name][locale] = new(emailTemplate)
+			}
+			loaded[name][locale].subject = subject
+		}
+	}
+	return nil
+}
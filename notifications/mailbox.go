@@ -0,0 +1,438 @@
+package notifications
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-mail"
+)
+
+// Local sinks (not go-mail providers, SendEmail keeps these emails on disk or in the process)
+const (
+	ProviderFile   gomail.ServiceProvider = 100 + iota // Writes .eml files to config email.mailbox_dir
+	ProviderMemory                                     // Keeps the emails in the in-process mailbox
+)
+
+// Mailbox settings
+const (
+	emlExt             = ".eml"
+	mailboxIDFormat    = "20060102T150405.000000000"
+	mailboxMaxMessages = 500 // Oldest in-memory emails are dropped
+	tagsHeader         = "X-Tags"
+)
+
+// ErrMailboxMessageNotFound is when the message is not in the mailbox
+var ErrMailboxMessageNotFound = errors.New("mailbox message not found")
+
+// mailboxIDPattern matches the message ids (also the .eml file names)
+var mailboxIDPattern = regexp.MustCompile(`^\d{8}T\d{6}\.\d{9}-\d+$`)
+
+// MailboxMessage is an email kept by a local sink (file or memory provider)
+type MailboxMessage struct {
+	Bcc      []string          `json:"bcc,omitempty"`
+	Cc       []string          `json:"cc,omitempty"`
+	From     string            `json:"from"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	HTML     string            `json:"html,omitempty"`
+	ID       string            `json:"id"`
+	Provider string            `json:"provider"`
+	ReplyTo  string            `json:"reply_to,omitempty"`
+	SentAt   time.Time         `json:"sent_at"`
+	Subject  string            `json:"subject"`
+	Tags     []string          `json:"tags,omitempty"`
+	Text     string            `json:"text,omitempty"`
+	To       []string          `json:"to"`
+}
+
+// mailbox is the in-process mailbox (memory provider)
+var mailbox struct {
+	sync.Mutex
+	messages []*MailboxMessage
+	sequence atomic.Uint64
+}
+
+// UseMailbox replaces the providers with the in-process mailbox (for tests, no credentials are needed)
+//
+// The email service is started without the providers if needed (new emails use the config email from values)
+func UseMailbox() {
+	if Service == nil {
+		Service = new(notificationService)
+	}
+	if Service.EmailService == nil {
+		Service.EmailService = &gomail.MailService{
+			FromDomain:   config.Values.Email.FromDomain,
+			FromName:     config.Values.Email.FromName,
+			FromUsername: config.Values.Email.FromUsername,
+		}
+	}
+	Service.providers = &providerChain{providers: []*providerState{{
+		health:   ProviderHealth{Name: config.EmailProviderMemory, State: BreakerClosed},
+		provider: ProviderMemory,
+	}}}
+	mailbox.Lock()
+	mailbox.messages = nil
+	mailbox.Unlock()
+}
+
+// SentEmails returns the emails in the in-process mailbox (oldest first, used by tests to assert on sent emails)
+func SentEmails() []*MailboxMessage {
+	mailbox.Lock()
+	defer mailbox.Unlock()
+	return append([]*MailboxMessage{}, mailbox.messages...)
+}
+
+// SentEmailsTo returns the emails in the in-process mailbox sent to the address (oldest first)
+func SentEmailsTo(address string) (messages []*MailboxMessage) {
+	for _, message := range SentEmails() {
+		for _, recipient := range append(append(append([]string{}, message.To...), message.Cc...), message.Bcc...) {
+			if strings.EqualFold(recipient, address) {
+				messages = append(messages, message)
+				break
+			}
+		}
+	}
+	return
+}
+
+// MailboxMessages returns the emails in the in-process mailbox and the mailbox directory (newest first)
+func MailboxMessages() (messages []*MailboxMessage, err error) {
+
+	// In-process mailbox
+	messages = SentEmails()
+
+	// Mailbox directory (file provider)
+	var files []string
+	if files, err = mailboxFiles(); err != nil {
+		return
+	}
+	for _, file := range files {
+		var message *MailboxMessage
+		if message, err = readEML(file); err != nil {
+			return
+		}
+		messages = append(messages, message)
+	}
+
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].SentAt.After(messages[j].SentAt)
+	})
+	return
+}
+
+// MailboxMessageByID returns the email from the in-process mailbox or the mailbox directory
+func MailboxMessageByID(id string) (*MailboxMessage, error) {
+	if !mailboxIDPattern.MatchString(id) {
+		return nil, ErrMailboxMessageNotFound
+	}
+
+	// In-process mailbox
+	for _, message := range SentEmails() {
+		if message.ID == id {
+			return message, nil
+		}
+	}
+
+	// Mailbox directory (file provider)
+	if dir := mailboxDir(); len(dir) > 0 {
+		message, err := readEML(filepath.Join(dir, id+emlExt))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrMailboxMessageNotFound
+		}
+		return message, err
+	}
+	return nil, ErrMailboxMessageNotFound
+}
+
+// ClearMailbox removes the emails from the in-process mailbox and the mailbox directory
+func ClearMailbox() (err error) {
+	mailbox.Lock()
+	mailbox.messages = nil
+	mailbox.Unlock()
+
+	var files []string
+	if files, err = mailboxFiles(); err != nil {
+		return
+	}
+	for _, file := range files {
+		if err = os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("error removing mailbox file: %w", err)
+		}
+	}
+	return nil
+}
+
+// isLocalSink returns true if the provider is a local sink (file or memory)
+func isLocalSink(provider gomail.ServiceProvider) bool {
+	return provider == ProviderFile || provider == ProviderMemory
+}
+
+// deliverToSink keeps the email in the mailbox directory or the in-process mailbox (returns the Message-ID)
+func deliverToSink(email *gomail.Email, headers map[string]string, provider gomail.ServiceProvider) (messageID string, err error) {
+
+	// Same basic checks as the providers
+	if len(email.Recipients)+len(email.CcRecipients)+len(email.BccRecipients) == 0 {
+		return "", errors.New("email has no recipients")
+	}
+	if len(email.Subject) == 0 {
+		return "", errors.New("email has no subject")
+	}
+
+	// Create the message
+	now := time.Now().UTC()
+	message := &MailboxMessage{
+		Bcc:      email.BccRecipients,
+		Cc:       email.CcRecipients,
+		From:     fromAddress(email),
+		Headers:  headers,
+		HTML:     email.HTMLContent,
+		ID:       now.Format(mailboxIDFormat) + "-" + strconv.FormatUint(mailbox.sequence.Add(1), 10),
+		Provider: ProviderName(provider),
+		ReplyTo:  email.ReplyToAddress,
+		SentAt:   now,
+		Subject:  email.Subject,
+		Tags:     email.Tags,
+		Text:     email.PlainTextContent,
+		To:       email.Recipients,
+	}
+
+	// Write the .eml file
+	if provider == ProviderFile {
+		dir := mailboxDir()
+		if err = os.MkdirAll(dir, 0o750); err != nil {
+			return "", fmt.Errorf("error creating mailbox directory: %w", err)
+		}
+		var eml []byte
+		if eml, err = message.EML(); err != nil {
+			return
+		}
+		if err = os.WriteFile(filepath.Join(dir, message.ID+emlExt), eml, 0o600); err != nil {
+			return "", fmt.Errorf("error writing mailbox file: %w", err)
+		}
+		return message.messageID(), nil
+	}
+
+	// Keep the message in memory
+	mailbox.Lock()
+	mailbox.messages = append(mailbox.messages, message)
+	if len(mailbox.messages) > mailboxMaxMessages {
+		mailbox.messages = mailbox.messages[len(mailbox.messages)-mailboxMaxMessages:]
+	}
+	mailbox.Unlock()
+	return message.messageID(), nil
+}
+
+// messageID returns the Message-ID header (IE: <20240101T000000.000000000-1@example.com>)
+func (m *MailboxMessage) messageID() string {
+	return "<" + m.ID + "@" + config.Values.Email.FromDomain + ">"
+}
+
+// EML returns the message as an .eml file (multipart/alternative with the text and html parts)
+func (m *MailboxMessage) EML() ([]byte, error) {
+
+	// Body parts
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct{ content, contentType string }{
+		{m.Text, "text/plain"},
+		{m.HTML, "text/html"},
+	} {
+		if len(part.content) == 0 {
+			continue
+		}
+		writer, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Transfer-Encoding": {"quoted-printable"},
+			"Content-Type":              {part.contentType + "; charset=utf-8"},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error creating email part: %w", err)
+		}
+		encoder := quotedprintable.NewWriter(writer)
+		if _, err = encoder.Write([]byte(part.content)); err != nil {
+			return nil, fmt.Errorf("error writing email part: %w", err)
+		}
+		if err = encoder.Close(); err != nil {
+			return nil, fmt.Errorf("error writing email part: %w", err)
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, fmt.Errorf("error closing email parts: %w", err)
+	}
+
+	// Headers
+	var eml bytes.Buffer
+	header := func(name, value string) {
+		if len(value) > 0 {
+			eml.WriteString(name + ": " + value + "\r\n")
+		}
+	}
+	header("Message-ID", m.messageID())
+	header("Date", m.SentAt.Format(time.RFC1123Z))
+	if from, err := mail.ParseAddress(m.From); err == nil {
+		header("From", from.String())
+	} else {
+		header("From", m.From)
+	}
+	header("To", strings.Join(m.To, ", "))
+	header("Cc", strings.Join(m.Cc, ", "))
+	header("Bcc", strings.Join(m.Bcc, ", "))
+	header("Reply-To", m.ReplyTo)
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header(tagsHeader, strings.Join(m.Tags, ", "))
+	names := make([]string, 0, len(m.Headers))
+	for name := range m.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		header(name, m.Headers[name])
+	}
+	header("MIME-Version", "1.0")
+	header("Content-Type", "multipart/alternative; boundary="+parts.Boundary())
+	eml.WriteString("\r\n")
+	eml.Write(body.Bytes())
+
+	return eml.Bytes(), nil
+}
+
+// readEML reads a message written by the file provider
+func readEML(path string) (message *MailboxMessage, err error) {
+
+	// Open the file
+	var file *os.File
+	if file, err = os.Open(path); err != nil { //nolint:gosec // path is the mailbox directory and a validated id
+		return
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	// Read the headers
+	var parsed *mail.Message
+	if parsed, err = mail.ReadMessage(file); err != nil {
+		return nil, fmt.Errorf("error reading mailbox file %s: %w", filepath.Base(path), err)
+	}
+	decoder := new(mime.WordDecoder)
+	message = &MailboxMessage{
+		Bcc:      splitHeader(parsed.Header.Get("Bcc")),
+		Cc:       splitHeader(parsed.Header.Get("Cc")),
+		ID:       strings.TrimSuffix(filepath.Base(path), emlExt),
+		Provider: config.EmailProviderFile,
+		ReplyTo:  parsed.Header.Get("Reply-To"),
+		Tags:     splitHeader(parsed.Header.Get(tagsHeader)),
+		To:       splitHeader(parsed.Header.Get("To")),
+	}
+	if message.From, err = decoder.DecodeHeader(parsed.Header.Get("From")); err != nil {
+		return nil, fmt.Errorf("error decoding from: %w", err)
+	}
+	if message.Subject, err = decoder.DecodeHeader(parsed.Header.Get("Subject")); err != nil {
+		return nil, fmt.Errorf("error decoding subject: %w", err)
+	}
+
+	// Extra headers (IE: List-Unsubscribe)
+	for name, values := range parsed.Header {
+		if strings.HasPrefix(name, "List-") && len(values) > 0 {
+			if message.Headers == nil {
+				message.Headers = make(map[string]string)
+			}
+			message.Headers[name] = values[0]
+		}
+	}
+
+	// The id has the exact time (the date header is in seconds)
+	sentAt, _, _ := strings.Cut(message.ID, "-")
+	if message.SentAt, err = time.Parse(mailboxIDFormat, sentAt); err != nil {
+		return nil, fmt.Errorf("error reading sent time: %w", err)
+	}
+
+	// Read the text and html parts (quoted-printable is decoded by the reader)
+	var params map[string]string
+	if _, params, err = mime.ParseMediaType(parsed.Header.Get("Content-Type")); err != nil {
+		return nil, fmt.Errorf("error reading content type: %w", err)
+	}
+	parts := multipart.NewReader(parsed.Body, params["boundary"])
+	for {
+		var part *multipart.Part
+		if part, err = parts.NextPart(); errors.Is(err, io.EOF) {
+			return message, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("error reading email part: %w", err)
+		}
+		var content []byte
+		if content, err = io.ReadAll(part); err != nil {
+			return nil, fmt.Errorf("error reading email part: %w", err)
+		}
+		if strings.HasPrefix(part.Header.Get("Content-Type"), "text/html") {
+			message.HTML = string(content)
+		} else {
+			message.Text = string(content)
+		}
+	}
+}
+
+// mailboxFiles returns the .eml files in the mailbox directory (none if the directory is not set or created yet)
+func mailboxFiles() (files []string, err error) {
+	dir := mailboxDir()
+	if len(dir) == 0 {
+		return
+	}
+	var entries []os.DirEntry
+	if entries, err = os.ReadDir(dir); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading mailbox directory: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && mailboxIDPattern.MatchString(strings.TrimSuffix(entry.Name(), emlExt)) {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return
+}
+
+// mailboxDir returns the mailbox directory (relative paths are from the project root)
+func mailboxDir() string {
+	dir := config.Values.Email.MailboxDir
+	if len(dir) == 0 || filepath.IsAbs(dir) {
+		return dir
+	}
+	return filepath.Join(config.GetCurrentDir(), "..", dir)
+}
+
+// fromAddress returns the sender as "Name <address>" (the same defaults as the email service)
+func fromAddress(email *gomail.Email) string {
+	address, name := email.FromAddress, email.FromName
+	if len(address) == 0 {
+		address = config.Values.Email.FromUsername + "@" + config.Values.Email.FromDomain
+	}
+	if len(name) == 0 {
+		name = config.Values.Email.FromName
+	}
+	return name + " <" + address + ">"
+}
+
+// splitHeader splits a comma separated header (addresses or tags)
+func splitHeader(value string) (values []string) {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			values = append(values, item)
+		}
+	}
+	return
+}
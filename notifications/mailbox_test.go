@@ -0,0 +1,72 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mrz1836/go-api/config"
+)
+
+// TestUseMailbox tests sending emails to the in-process mailbox
+func TestUseMailbox(t *testing.T) {
+	config.Values.Email.FromDomain = "example.com"
+	config.Values.Email.FromName = "No Reply"
+	config.Values.Email.FromUsername = "no-reply"
+	Service = nil
+	UseMailbox()
+
+	t.Run("service is loaded", func(t *testing.T) {
+		if Service.EmailService == nil {
+			t.Fatal("expected the email service to be loaded")
+		}
+		if err := CheckEmailService(context.Background()); err != nil {
+			t.Fatalf("expected the email service to be ready: %s", err.Error())
+		}
+	})
+
+	t.Run("send to the mailbox", func(t *testing.T) {
+		email := NewEmail(&RenderedEmail{HTML: "<p>Hello</p>", Subject: "Hello", Text: "Hello"}, "jane@example.com")
+		provider, messageID, err := Deliver(context.Background(), email)
+		if err != nil {
+			t.Fatalf("error delivering email: %s", err.Error())
+		}
+		if provider != ProviderMemory {
+			t.Fatalf("expected the memory provider, got %s", ProviderName(provider))
+		}
+		if len(messageID) == 0 {
+			t.Fatal("expected a message ID")
+		}
+
+		sent := SentEmails()
+		if len(sent) != 1 {
+			t.Fatalf("expected 1 email, got %d", len(sent))
+		}
+		if sent[0].Subject != "Hello" || sent[0].Text != "Hello" || sent[0].From != "No Reply <no-reply@example.com>" {
+			t.Fatalf("unexpected email: %+v", sent[0])
+		}
+		if len(SentEmailsTo("JANE@example.com")) != 1 {
+			t.Fatal("expected the email to be found by recipient")
+		}
+		if len(SentEmailsTo("john@example.com")) != 0 {
+			t.Fatal("expected no emails for another recipient")
+		}
+	})
+
+	t.Run("invalid email", func(t *testing.T) {
+		email := NewEmail(&RenderedEmail{Subject: "No recipients"})
+		if _, _, err := Deliver(context.Background(), email); err == nil || errors.Is(err, ErrNoProviderAvailable) {
+			t.Fatalf("expected a permanent error, got %v", err)
+		}
+		if len(SentEmails()) != 1 {
+			t.Fatalf("expected 1 email, got %d", len(SentEmails()))
+		}
+	})
+
+	t.Run("reset the mailbox", func(t *testing.T) {
+		UseMailbox()
+		if len(SentEmails()) != 0 {
+			t.Fatalf("expected an empty mailbox, got %d", len(SentEmails()))
+		}
+	})
+}
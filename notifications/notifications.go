@@ -3,11 +3,24 @@ Package notifications handles all outbound notifications (email, sms, push, etc)
 */
 package notifications
 
-import "github.com/mrz1836/go-mail"
+import (
+	"context"
+
+	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-api/metrics"
+	"github.com/mrz1836/go-api/request"
+	"github.com/mrz1836/go-api/tracing"
+	"github.com/mrz1836/go-logger"
+	"github.com/mrz1836/go-mail"
+	"go.opentelemetry.io/otel/attribute"
+)
 
 // notificationService is the configuration and services for all notifications
 type notificationService struct {
 	EmailService *gomail.MailService `json:"email_service"`
+	providers    *providerChain
+	push         PushProvider
+	sms          SMSProvider
 }
 
 var (
@@ -22,7 +35,76 @@ func StartUp() (err error) {
 	Service = new(notificationService)
 
 	// load the email service
-	Service.EmailService, err = loadEmailService()
+	if Service.EmailService, err = loadEmailService(); err != nil {
+		return
+	}
+
+	// load the email providers (in order)
+	if Service.providers, err = loadProviderChain(); err != nil {
+		return
+	}
+
+	// load the push and sms providers (nil if disabled)
+	if Service.push, err = loadPushProvider(); err != nil {
+		return
+	}
+	if Service.sms, err = loadSMSProvider(); err != nil {
+		return
+	}
+
+	// load and validate the email templates
+	err = loadTemplates()
+
+	return
+}
+
+// SendEmail sends the email using the provider and records the outcome (returns the Message-ID, only kept by the local sinks)
+func SendEmail(ctx context.Context, email *gomail.Email, provider gomail.ServiceProvider) (string, error) {
+	return sendEmail(ctx, email, nil, provider)
+}
+
+// sendEmail sends the email with the extra headers (go-mail can not set custom headers, only the local sinks keep them)
+//
+// go-mail does not return the provider message ID, provider webhooks are matched by the outbox tag instead
+func sendEmail(ctx context.Context, email *gomail.Email, headers map[string]string, provider gomail.ServiceProvider) (messageID string, err error) {
 
+	// Start the span
+	ctx, span := tracing.StartSpan(ctx, "email send",
+		attribute.String("email.provider", ProviderName(provider)),
+		attribute.Int("email.recipients", len(email.Recipients)),
+	)
+	defer func() {
+		tracing.EndSpan(span, err)
+	}()
+
+	// Send the email (local sinks keep the email on disk or in the process)
+	if isLocalSink(provider) {
+		messageID, err = deliverToSink(email, headers, provider)
+	} else {
+		err = Service.EmailService.SendEmail(ctx, email, provider)
+	}
+	if err != nil {
+		logger.Data(2, logger.ERROR, "failed sending email: "+err.Error(), request.LogParameters(ctx)...)
+	}
+	metrics.ObserveEmail(ProviderName(provider), err)
 	return
 }
+
+// ProviderName returns the name of the email provider (used for metrics and logs)
+func ProviderName(provider gomail.ServiceProvider) string {
+	switch provider {
+	case gomail.AwsSes:
+		return config.EmailProviderAwsSes
+	case gomail.Mandrill:
+		return config.EmailProviderMandrill
+	case ProviderFile:
+		return config.EmailProviderFile
+	case ProviderMemory:
+		return config.EmailProviderMemory
+	case gomail.Postmark:
+		return config.EmailProviderPostmark
+	case gomail.SMTP:
+		return config.EmailProviderSMTP
+	}
+	return "unknown"
+}
@@ -0,0 +1,366 @@
+package notifications
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-api/database"
+	"github.com/mrz1836/go-api/request"
+	"github.com/mrz1836/go-logger"
+	"github.com/mrz1836/go-mail"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/queries"
+)
+
+// Outbox email statuses
+const (
+	OutboxStatusFailed  = "failed"
+	OutboxStatusPending = "pending"
+	OutboxStatusSending = "sending"
+	OutboxStatusSent    = "sent"
+	OutboxStatusSkipped = "skipped" // Person is unsubscribed from the category of the email
+)
+
+// outboxTagPrefix is the tag sent with each outbox email (IE: outbox-123, providers return it in webhooks)
+const outboxTagPrefix = "outbox-"
+
+// ErrOutboxEmailNotFound is when the outbox email does not exist
+var ErrOutboxEmailNotFound = errors.New("outbox email not found")
+
+// OutboxEmail is a queued email (email_outbox table)
+type OutboxEmail struct {
+	Attempts          int             `boil:"attempts" json:"attempts"`
+	CreatedAt         time.Time       `boil:"created_at" json:"created_at"`
+	ID                uint64          `boil:"id" json:"id"`
+	Kind              string          `boil:"kind" json:"kind"`
+	LastError         string          `boil:"last_error" json:"last_error,omitempty"`
+	LockedUntil       null.Time       `boil:"locked_until" json:"locked_until,omitempty"`
+	MaxAttempts       int             `boil:"max_attempts" json:"max_attempts"`
+	Message           json.RawMessage `boil:"message" json:"message"`
+	ModifiedAt        time.Time       `boil:"modified_at" json:"modified_at"`
+	NextAttemptAt     time.Time       `boil:"next_attempt_at" json:"next_attempt_at"`
+	PersonID          null.Uint64     `boil:"person_id" json:"person_id,omitempty"`
+	Provider          string          `boil:"provider" json:"provider,omitempty"`
+	ProviderMessageID string          `boil:"provider_message_id" json:"provider_message_id,omitempty"`
+	SentAt            null.Time       `boil:"sent_at" json:"sent_at,omitempty"`
+	Status            string          `boil:"status" json:"status"`
+}
+
+// EmailDelivery is a single attempt to send an outbox email (email_deliveries table)
+type EmailDelivery struct {
+	Attempt           int       `boil:"attempt" json:"attempt"`
+	CreatedAt         time.Time `boil:"created_at" json:"created_at"`
+	Error             string    `boil:"error" json:"error,omitempty"`
+	ID                uint64    `boil:"id" json:"id"`
+	OutboxID          uint64    `boil:"outbox_id" json:"outbox_id"`
+	Provider          string    `boil:"provider" json:"provider"`
+	ProviderMessageID string    `boil:"provider_message_id" json:"provider_message_id,omitempty"`
+	Status            string    `boil:"status" json:"status"`
+}
+
+// outboxMessage is the rendered email stored in the outbox (attachments are not supported)
+type outboxMessage struct {
+	BccRecipients    []string `json:"bcc_recipients,omitempty"`
+	CcRecipients     []string `json:"cc_recipients,omitempty"`
+	FromAddress      string   `json:"from_address,omitempty"`
+	FromName         string   `json:"from_name,omitempty"`
+	HTMLContent      string   `json:"html_content,omitempty"`
+	PlainTextContent string   `json:"plain_text_content,omitempty"`
+	Recipients       []string `json:"recipients"`
+	ReplyToAddress   string   `json:"reply_to_address,omitempty"`
+	Subject          string   `json:"subject"`
+	Tags             []string `json:"tags,omitempty"`
+	TrackClicks      bool     `json:"track_clicks"`
+	TrackOpens       bool     `json:"track_opens"`
+}
+
+// executor is a database or a transaction
+type executor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// QueueEmail adds the email to the outbox (sent by the email-outbox job)
+func QueueEmail(ctx context.Context, kind string, personID uint64, email *gomail.Email) (uint64, error) {
+	return queueEmail(ctx, database.WriteDatabase, kind, personID, email)
+}
+
+// QueueEmailTx adds the email to the outbox in the transaction (only sent if the transaction commits)
+func QueueEmailTx(ctx context.Context, tx *sql.Tx, kind string, personID uint64, email *gomail.Email) (uint64, error) {
+	return queueEmail(ctx, tx, kind, personID, email)
+}
+
+// queueEmail inserts the rendered email (emails with a category need the person, their preference is checked when sent)
+func queueEmail(ctx context.Context, exec executor, kind string, personID uint64, email *gomail.Email) (id uint64, err error) {
+	if category := EmailCategory(email); personID == 0 && len(category) > 0 {
+		return 0, fmt.Errorf("%w: %s is %s", ErrPersonRequired, kind, category)
+	}
+
+	// Encode the rendered email
+	var message []byte
+	if message, err = json.Marshal(outboxMessage{
+		BccRecipients:    email.BccRecipients,
+		CcRecipients:     email.CcRecipients,
+		FromAddress:      email.FromAddress,
+		FromName:         email.FromName,
+		HTMLContent:      email.HTMLContent,
+		PlainTextContent: email.PlainTextContent,
+		Recipients:       email.Recipients,
+		ReplyToAddress:   email.ReplyToAddress,
+		Subject:          email.Subject,
+		Tags:             email.Tags,
+		TrackClicks:      email.TrackClicks,
+		TrackOpens:       email.TrackOpens,
+	}); err != nil {
+		return
+	}
+
+	// Person is optional
+	person := null.NewUint64(personID, personID > 0)
+
+	// Insert the record
+	var result sql.Result
+	if result, err = exec.ExecContext(ctx,
+		"INSERT INTO `email_outbox` (`person_id`, `kind`, `message`, `status`, `max_attempts`, `next_attempt_at`, `last_error`) VALUES (?, ?, ?, ?, ?, ?, '')",
+		person, kind, message, OutboxStatusPending, config.Values.Email.Outbox.MaxAttempts, time.Now().UTC(),
+	); err != nil {
+		return
+	}
+
+	var lastID int64
+	if lastID, err = result.LastInsertId(); err != nil {
+		return
+	}
+	return uint64(lastID), nil
+}
+
+// DispatchOutbox sends the due emails until the outbox is empty (the email-outbox job)
+//
+// Emails are claimed one at a time, so the visibility timeout only has to cover one email on every provider
+func DispatchOutbox(ctx context.Context) error {
+	conf := config.Values.Email.Outbox
+	for ctx.Err() == nil {
+		email, err := claimOutbox(ctx, conf.VisibilityTimeout)
+		if err != nil {
+			return fmt.Errorf("error claiming outbox email: %w", err)
+		} else if email == nil {
+			return nil
+		}
+		deliverOutboxEmail(ctx, email)
+	}
+	return ctx.Err()
+}
+
+// claimOutbox locks the next due email (or an abandoned email) for this dispatcher (nil if there are none)
+func claimOutbox(ctx context.Context, visibility time.Duration) (email *OutboxEmail, err error) {
+
+	// Start a transaction (the row is only locked until the claim is committed)
+	var tx *sql.Tx
+	if tx, err = database.WriteDatabase.BeginTx(ctx, nil); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	// Next due email, skipping emails claimed by other dispatchers
+	now := time.Now().UTC()
+	var emails []*OutboxEmail
+	if err = queries.Raw(
+		"SELECT * FROM `email_outbox` "+
+			"WHERE (`status` = ? AND `next_attempt_at` <= ?) OR (`status` = ? AND `locked_until` < ?) "+
+			"ORDER BY `next_attempt_at` LIMIT 1 FOR UPDATE SKIP LOCKED",
+		OutboxStatusPending, now, OutboxStatusSending, now,
+	).Bind(ctx, tx, &emails); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+	if len(emails) == 0 {
+		return nil, tx.Rollback()
+	}
+
+	// Claim it
+	email = emails[0]
+	email.Attempts++
+	email.LockedUntil = null.TimeFrom(now.Add(visibility))
+	email.Status = OutboxStatusSending
+	if _, err = tx.ExecContext(ctx,
+		"UPDATE `email_outbox` SET `status` = ?, `attempts` = ?, `locked_until` = ? WHERE `id` = ?",
+		email.Status, email.Attempts, email.LockedUntil, email.ID,
+	); err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	return
+}
+
+// deliverOutboxEmail sends the email and records the attempt (sent, retried with backoff or failed)
+func deliverOutboxEmail(ctx context.Context, outbox *OutboxEmail) {
+
+	// Send using the providers in order (checking the person's preferences)
+	provider := ""
+	email, err := outbox.email()
+	if err == nil {
+		var used gomail.ServiceProvider
+		if outbox.PersonID.Valid {
+			used, outbox.ProviderMessageID, err = DeliverToPerson(ctx, outbox.PersonID.Uint64, email)
+		} else {
+			used, outbox.ProviderMessageID, err = Deliver(ctx, email)
+		}
+		if !errors.Is(err, ErrNoProviderAvailable) && !errors.Is(err, ErrUnsubscribed) && !errors.Is(err, ErrRecipientsSuppressed) {
+			provider = ProviderName(used)
+		}
+	}
+
+	// Sent, skipped (unsubscribed), retried with backoff, or failed (permanent error or the last attempt)
+	now := time.Now().UTC()
+	deliveryStatus := OutboxStatusSent
+	switch {
+	case err == nil:
+		outbox.LastError = ""
+		outbox.Provider = provider
+		outbox.SentAt = null.TimeFrom(now)
+		outbox.Status = OutboxStatusSent
+	case errors.Is(err, ErrUnsubscribed):
+		deliveryStatus = OutboxStatusSkipped
+		outbox.LastError = err.Error()
+		outbox.Status = OutboxStatusSkipped
+	case !IsTransient(err) || outbox.Attempts >= outbox.MaxAttempts:
+		deliveryStatus = OutboxStatusFailed
+		outbox.LastError = err.Error()
+		outbox.Status = OutboxStatusFailed
+	default:
+		deliveryStatus = OutboxStatusFailed
+		outbox.LastError = err.Error()
+		outbox.NextAttemptAt = now.Add(outboxBackoff(outbox.Attempts))
+		outbox.Status = OutboxStatusPending
+	}
+
+	if err != nil && outbox.Status != OutboxStatusSkipped {
+		logger.Data(2, logger.ERROR, fmt.Sprintf("outbox email %d failed (attempt %d of %d): %s", outbox.ID, outbox.Attempts, outbox.MaxAttempts, err.Error()),
+			request.LogParameters(ctx)...,
+		)
+	}
+
+	// Update the outbox (only while this dispatcher still holds the claim) and the delivery log
+	if result, updateErr := database.WriteDatabase.ExecContext(ctx,
+		"UPDATE `email_outbox` SET `status` = ?, `provider` = ?, `provider_message_id` = ?, `last_error` = ?, `next_attempt_at` = ?, `sent_at` = ?, `locked_until` = NULL "+
+			"WHERE `id` = ? AND `status` = ? AND `attempts` = ?",
+		outbox.Status, outbox.Provider, outbox.ProviderMessageID, outbox.LastError, outbox.NextAttemptAt, outbox.SentAt,
+		outbox.ID, OutboxStatusSending, outbox.Attempts,
+	); updateErr != nil {
+		logger.Data(2, logger.ERROR, fmt.Sprintf("outbox email %d error updating: %s", outbox.ID, updateErr.Error()), request.LogParameters(ctx)...)
+	} else if rows, _ := result.RowsAffected(); rows == 0 {
+		logger.Data(2, logger.ERROR, fmt.Sprintf("outbox email %d claim expired before attempt %d finished (status not updated)", outbox.ID, outbox.Attempts),
+			request.LogParameters(ctx)...,
+		)
+	}
+	if _, logErr := database.WriteDatabase.ExecContext(ctx,
+		"INSERT INTO `email_deliveries` (`outbox_id`, `attempt`, `status`, `provider`, `provider_message_id`, `error`) VALUES (?, ?, ?, ?, ?, ?)",
+		outbox.ID, outbox.Attempts, deliveryStatus, provider, outbox.ProviderMessageID, outbox.LastError,
+	); logErr != nil {
+		logger.Data(2, logger.ERROR, fmt.Sprintf("outbox email %d error logging delivery: %s", outbox.ID, logErr.Error()), request.LogParameters(ctx)...)
+	}
+}
+
+// outboxBackoff returns the wait before the next attempt (doubles after each attempt, up to the max)
+func outboxBackoff(attempts int) time.Duration {
+	conf := config.Values.Email.Outbox
+	backoff := conf.RetryBackoff
+	for i := 1; i < attempts && backoff < conf.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > conf.MaxBackoff {
+		backoff = conf.MaxBackoff
+	}
+	return backoff
+}
+
+// email returns the stored email (with the outbox tag, used to match provider webhooks)
+func (o *OutboxEmail) email() (*gomail.Email, error) {
+	message := new(outboxMessage)
+	if err := json.Unmarshal(o.Message, message); err != nil {
+		return nil, fmt.Errorf("error decoding outbox email %d: %w", o.ID, err)
+	}
+
+	email := Service.EmailService.NewEmail()
+	email.BccRecipients = message.BccRecipients
+	email.CcRecipients = message.CcRecipients
+	email.FromAddress = message.FromAddress
+	email.FromName = message.FromName
+	email.HTMLContent = message.HTMLContent
+	email.PlainTextContent = message.PlainTextContent
+	email.Recipients = message.Recipients
+	email.ReplyToAddress = message.ReplyToAddress
+	email.Subject = message.Subject
+	email.Tags = append([]string{outboxTagPrefix + strconv.FormatUint(o.ID, 10)}, message.Tags...) // First, Postmark only keeps one tag
+	email.TrackClicks = message.TrackClicks
+	email.TrackOpens = message.TrackOpens
+	return email, nil
+}
+
+// GetOutboxEmails gets the latest outbox emails (newest first, status and person are optional filters)
+func GetOutboxEmails(ctx context.Context, status string, personID uint64, limit int) (emails []*OutboxEmail, err error) {
+	query := "SELECT * FROM `email_outbox` WHERE 1 = 1"
+	var args []interface{}
+	if len(status) > 0 {
+		query += " AND `status` = ?"
+		args = append(args, status)
+	}
+	if personID > 0 {
+		query += " AND `person_id` = ?"
+		args = append(args, personID)
+	}
+	query += " ORDER BY `id` DESC LIMIT ?"
+	args = append(args, limit)
+
+	err = queries.Raw(query, args...).Bind(ctx, database.ReadDatabase, &emails)
+	return
+}
+
+// GetOutboxEmail gets an outbox email by ID
+func GetOutboxEmail(ctx context.Context, id uint64) (email *OutboxEmail, err error) {
+	email = new(OutboxEmail)
+	if err = queries.Raw(
+		"SELECT * FROM `email_outbox` WHERE `id` = ?", id,
+	).Bind(ctx, database.ReadDatabase, email); errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrOutboxEmailNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// GetEmailDeliveries gets the delivery log of an outbox email (oldest first)
+func GetEmailDeliveries(ctx context.Context, outboxID uint64) (deliveries []*EmailDelivery, err error) {
+	err = queries.Raw(
+		"SELECT * FROM `email_deliveries` WHERE `outbox_id` = ? ORDER BY `id`", outboxID,
+	).Bind(ctx, database.ReadDatabase, &deliveries)
+	return
+}
+
+// ResendOutboxEmail queues the email again (sent, failed or skipped emails get a fresh set of attempts)
+func ResendOutboxEmail(ctx context.Context, id uint64) error {
+	result, err := database.WriteDatabase.ExecContext(ctx,
+		"UPDATE `email_outbox` SET `status` = ?, `attempts` = 0, `max_attempts` = ?, `next_attempt_at` = ?, `last_error` = '', `locked_until` = NULL "+
+			"WHERE `id` = ? AND `status` IN (?, ?, ?)",
+		OutboxStatusPending, config.Values.Email.Outbox.MaxAttempts, time.Now().UTC(), id, OutboxStatusFailed, OutboxStatusSent, OutboxStatusSkipped,
+	)
+	if err != nil {
+		return err
+	}
+	var rows int64
+	if rows, err = result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return fmt.Errorf("%w (or it is already queued): %d", ErrOutboxEmailNotFound, id)
+	}
+	return nil
+}
@@ -0,0 +1,313 @@
+package notifications
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-api/database"
+	"github.com/mrz1836/go-mail"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/queries"
+)
+
+// Notification categories (templates without a category are transactional and always sent)
+const (
+	CategoryMarketing = "marketing" // Opt-in (not subscribed by default)
+	CategoryProduct   = "product"
+	CategorySecurity  = "security" // Required (can not be unsubscribed)
+)
+
+// Preference sources (what changed the preference last)
+const (
+	PreferenceSourceAPI         = "api"
+	PreferenceSourceUnsubscribe = "unsubscribe"
+)
+
+// categoryTagPrefix is the tag with the category of the email (IE: category-product)
+const categoryTagPrefix = "category-"
+
+// unsubscribeTokenVersion starts the unsubscribe tokens (older formats are not valid)
+const unsubscribeTokenVersion = "v1"
+
+var (
+	// ErrCategoryRequired is when unsubscribing from a required category (security)
+	ErrCategoryRequired = errors.New("notification category can not be unsubscribed")
+
+	// ErrPersonRequired is when sending an email with a category without the person (see DeliverToPerson)
+	ErrPersonRequired = errors.New("email with a notification category needs a person")
+
+	// ErrUnknownCategory is when the category is not one of the notification categories
+	ErrUnknownCategory = errors.New("unknown notification category")
+
+	// ErrUnsubscribed is when the person is not subscribed to the category of the email
+	ErrUnsubscribed = errors.New("person is unsubscribed from the notification category")
+
+	// ErrUnsubscribeToken is when the unsubscribe token is missing or the signature is not valid
+	ErrUnsubscribeToken = errors.New("unsubscribe token is not valid")
+
+	// ErrUnsubscribeTokenExpired is when the unsubscribe token is older than config email.unsubscribe.token_ttl
+	ErrUnsubscribeTokenExpired = errors.New("unsubscribe token has expired")
+)
+
+// categoryDefaults are the categories, if a person is subscribed and the channels without a preference (in display order)
+var categoryDefaults = []struct {
+	category   string
+	channels   []string
+	subscribed bool
+}{
+	{CategoryMarketing, []string{ChannelEmail}, false},
+	{CategoryProduct, []string{ChannelEmail, ChannelPush}, true},
+	{CategorySecurity, []string{ChannelEmail, ChannelSMS}, true},
+}
+
+// NotificationPreference is a person's preference for a category (notification_preferences table)
+type NotificationPreference struct {
+	Category       string    `boil:"category" json:"category"`
+	Channels       []string  `boil:"-" json:"channels"`
+	ModifiedAt     null.Time `boil:"modified_at" json:"modified_at,omitempty"`
+	PersonID       uint64    `boil:"person_id" json:"person_id"`
+	Required       bool      `boil:"-" json:"required"`
+	Source         string    `boil:"source" json:"source,omitempty"`
+	StoredChannels string    `boil:"channels" json:"-"` // Comma separated, empty is the category default
+	Subscribed     bool      `boil:"subscribed" json:"subscribed"`
+}
+
+// preferenceColumns are the columns of a stored preference
+const preferenceColumns = "`person_id`, `category`, `subscribed`, `channels`, `source`, `modified_at`"
+
+// Categories returns the notification categories (in display order)
+func Categories() (categories []string) {
+	for _, category := range categoryDefaults {
+		categories = append(categories, category.category)
+	}
+	return
+}
+
+// CategoryRequired returns true if the category can not be unsubscribed (security, or transactional without a category)
+func CategoryRequired(category string) bool {
+	return len(category) == 0 || category == CategorySecurity
+}
+
+// GetPreferences gets the person's preference for every category (the default if not set)
+func GetPreferences(ctx context.Context, personID uint64) (preferences []*NotificationPreference, err error) {
+
+	// Stored preferences
+	var stored []*NotificationPreference
+	if err = queries.Raw(
+		"SELECT "+preferenceColumns+" FROM `notification_preferences` WHERE `person_id` = ?", personID,
+	).Bind(ctx, database.ReadDatabase, &stored); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	byCategory := make(map[string]*NotificationPreference, len(stored))
+	for _, preference := range stored {
+		byCategory[preference.Category] = preference
+	}
+
+	// Every category (stored or the default)
+	for _, category := range categoryDefaults {
+		preference, ok := byCategory[category.category]
+		if !ok {
+			preference = &NotificationPreference{Category: category.category, PersonID: personID, Subscribed: category.subscribed}
+		}
+		preference.Channels = preferenceChannels(preference.StoredChannels, category.channels)
+		preference.Required = CategoryRequired(category.category)
+		preferences = append(preferences, preference)
+	}
+	return preferences, nil
+}
+
+// SetPreference subscribes or unsubscribes the person from the category
+func SetPreference(ctx context.Context, personID uint64, category string, subscribed bool, source string) (err error) {
+	if _, err = categoryDefault(category); err != nil {
+		return
+	} else if !subscribed && CategoryRequired(category) {
+		return fmt.Errorf("%w: %s", ErrCategoryRequired, category)
+	}
+
+	_, err = database.WriteDatabase.ExecContext(ctx,
+		"INSERT INTO `notification_preferences` (`person_id`, `category`, `subscribed`, `source`) VALUES (?, ?, ?, ?) "+
+			"ON DUPLICATE KEY UPDATE `subscribed` = VALUES(`subscribed`), `source` = VALUES(`source`)",
+		personID, category, subscribed, source,
+	)
+	return
+}
+
+// SetChannels sets the channels the person receives the category on (the subscription is unchanged)
+func SetChannels(ctx context.Context, personID uint64, category string, names []string, source string) (err error) {
+	var subscribed bool
+	if subscribed, err = categoryDefault(category); err != nil {
+		return
+	} else if err = checkChannels(names); err != nil {
+		return
+	}
+
+	_, err = database.WriteDatabase.ExecContext(ctx,
+		"INSERT INTO `notification_preferences` (`person_id`, `category`, `subscribed`, `channels`, `source`) VALUES (?, ?, ?, ?, ?) "+
+			"ON DUPLICATE KEY UPDATE `channels` = VALUES(`channels`), `source` = VALUES(`source`)",
+		personID, category, subscribed, strings.Join(names, ","), source,
+	)
+	return
+}
+
+// Subscribed returns true if the person receives the category (required categories are always sent)
+func Subscribed(ctx context.Context, personID uint64, category string) (bool, error) {
+	preference, err := getPreference(ctx, personID, category)
+	if err != nil {
+		return false, err
+	}
+	return preference.Subscribed, nil
+}
+
+// getPreference gets the person's preference for the category (transactional is every channel and always sent)
+func getPreference(ctx context.Context, personID uint64, category string) (preference *NotificationPreference, err error) {
+
+	// Transactional (no category)
+	if len(category) == 0 {
+		return &NotificationPreference{Channels: Channels(), PersonID: personID, Required: true, Subscribed: true}, nil
+	}
+
+	// Category default
+	preference = &NotificationPreference{Category: category, PersonID: personID, Required: CategoryRequired(category)}
+	if preference.Subscribed, err = categoryDefault(category); err != nil {
+		return nil, err
+	}
+
+	// Stored preference (no database is the default, IE: tests using the in-process mailbox)
+	if database.ReadDatabase != nil && personID > 0 {
+		var stored []*NotificationPreference
+		if err = queries.Raw(
+			"SELECT "+preferenceColumns+" FROM `notification_preferences` WHERE `person_id` = ? AND `category` = ?",
+			personID, category,
+		).Bind(ctx, database.ReadDatabase, &stored); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		if len(stored) > 0 {
+			stored[0].Required = preference.Required
+			preference = stored[0]
+		}
+	}
+
+	// Required categories are always sent
+	preference.Subscribed = preference.Subscribed || preference.Required
+	preference.Channels = preferenceChannels(preference.StoredChannels, categoryChannels(category))
+	return preference, nil
+}
+
+// DeliverToPerson sends the email if the person is subscribed to its category (with the one-click unsubscribe headers)
+func DeliverToPerson(ctx context.Context, personID uint64, email *gomail.Email) (provider gomail.ServiceProvider, messageID string, err error) {
+
+	// Check the preference
+	category := EmailCategory(email)
+	var subscribed bool
+	if subscribed, err = Subscribed(ctx, personID, category); err != nil {
+		return
+	} else if !subscribed {
+		return provider, "", fmt.Errorf("%w: %s", ErrUnsubscribed, category)
+	}
+
+	return deliver(ctx, email, UnsubscribeHeaders(personID, category))
+}
+
+// EmailCategory returns the category of the email (from the category tag, empty if transactional)
+func EmailCategory(email *gomail.Email) string {
+	for _, tag := range email.Tags {
+		if strings.HasPrefix(tag, categoryTagPrefix) {
+			return strings.TrimPrefix(tag, categoryTagPrefix)
+		}
+	}
+	return ""
+}
+
+// UnsubscribeHeaders returns the RFC 8058 one-click unsubscribe headers (none for required categories)
+func UnsubscribeHeaders(personID uint64, category string) map[string]string {
+	if personID == 0 || CategoryRequired(category) {
+		return nil
+	}
+	return map[string]string{
+		"List-Unsubscribe":      "<" + UnsubscribeURL(personID, category) + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+}
+
+// UnsubscribeURL returns the signed unsubscribe link for the person and category
+func UnsubscribeURL(personID uint64, category string) string {
+	return config.Values.Email.Unsubscribe.URL + "?token=" + url.QueryEscape(UnsubscribeToken(personID, category))
+}
+
+// UnsubscribeToken returns the signed token (version.person.category.expires.signature, expires after config email.unsubscribe.token_ttl)
+func UnsubscribeToken(personID uint64, category string) string {
+	expires := time.Now().Add(config.Values.Email.Unsubscribe.TokenTTL).Unix()
+	payload := unsubscribeTokenVersion + "." + strconv.FormatUint(personID, 10) + "." + category + "." + strconv.FormatInt(expires, 10)
+	return payload + "." + unsubscribeSignature(payload)
+}
+
+// ParseUnsubscribeToken checks the version, signature and expiry, and returns the person and category
+func ParseUnsubscribeToken(token string) (personID uint64, category string, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) < 5 || parts[0] != unsubscribeTokenVersion {
+		return 0, "", ErrUnsubscribeToken
+	}
+	last := len(parts) - 1
+	payload := strings.Join(parts[:last], ".")
+	if !hmac.Equal([]byte(parts[last]), []byte(unsubscribeSignature(payload))) {
+		return 0, "", ErrUnsubscribeToken
+	}
+	if personID, err = strconv.ParseUint(parts[1], 10, 64); err != nil || personID == 0 {
+		return 0, "", ErrUnsubscribeToken
+	}
+	var expires int64
+	if expires, err = strconv.ParseInt(parts[last-1], 10, 64); err != nil {
+		return 0, "", ErrUnsubscribeToken
+	}
+	if time.Now().Unix() > expires {
+		return 0, "", ErrUnsubscribeTokenExpired
+	}
+
+	// The category is between the person and the expiry (it may have dots)
+	return personID, strings.Join(parts[2:last-1], "."), nil
+}
+
+// unsubscribeSignature signs the token payload (HMAC-SHA256 with config email.unsubscribe.secret)
+func unsubscribeSignature(payload string) string {
+	mac := hmac.New(sha256.New, []byte(config.Values.Email.Unsubscribe.Secret))
+	_, _ = mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// categoryChannels returns the channels of the category without a preference
+func categoryChannels(category string) []string {
+	for _, defaults := range categoryDefaults {
+		if defaults.category == category {
+			return defaults.channels
+		}
+	}
+	return nil
+}
+
+// preferenceChannels returns the stored channels (comma separated) or the defaults if none are stored
+func preferenceChannels(stored string, defaults []string) []string {
+	if len(stored) == 0 {
+		return append([]string{}, defaults...)
+	}
+	return strings.Split(stored, ",")
+}
+
+// categoryDefault returns if a person is subscribed to the category without a preference
+func categoryDefault(category string) (bool, error) {
+	for _, defaults := range categoryDefaults {
+		if defaults.category == category {
+			return defaults.subscribed, nil
+		}
+	}
+	return false, fmt.Errorf("%w: %s", ErrUnknownCategory, category)
+}
@@ -0,0 +1,107 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-mail"
+)
+
+// TestUnsubscribeToken tests signing and parsing the unsubscribe tokens
+func TestUnsubscribeToken(t *testing.T) {
+	config.Values.Email.Unsubscribe.Secret = "test-unsubscribe-secret-0123456789abcdef"
+	config.Values.Email.Unsubscribe.TokenTTL = 24 * time.Hour
+
+	t.Run("round trip", func(t *testing.T) {
+		personID, category, err := ParseUnsubscribeToken(UnsubscribeToken(123, CategoryMarketing))
+		if err != nil {
+			t.Fatalf("error parsing token: %s", err.Error())
+		}
+		if personID != 123 || category != CategoryMarketing {
+			t.Fatalf("expected 123 %s, got %d %s", CategoryMarketing, personID, category)
+		}
+	})
+
+	t.Run("category with a dot", func(t *testing.T) {
+		personID, category, err := ParseUnsubscribeToken(UnsubscribeToken(123, "product.updates"))
+		if err != nil {
+			t.Fatalf("error parsing token: %s", err.Error())
+		}
+		if personID != 123 || category != "product.updates" {
+			t.Fatalf("expected 123 product.updates, got %d %s", personID, category)
+		}
+	})
+
+	t.Run("version prefix", func(t *testing.T) {
+		token := UnsubscribeToken(123, CategoryMarketing)
+		if !strings.HasPrefix(token, unsubscribeTokenVersion+".") {
+			t.Fatalf("expected the %s prefix, got %s", unsubscribeTokenVersion, token)
+		}
+
+		// Another version, or the format without the version (signed with the same secret)
+		payload := "v2" + strings.TrimPrefix(token[:strings.LastIndex(token, ".")], unsubscribeTokenVersion)
+		unversioned := strings.TrimPrefix(token[:strings.LastIndex(token, ".")], unsubscribeTokenVersion+".")
+		for _, other := range []string{payload + "." + unsubscribeSignature(payload), unversioned + "." + unsubscribeSignature(unversioned)} {
+			if _, _, err := ParseUnsubscribeToken(other); !errors.Is(err, ErrUnsubscribeToken) {
+				t.Fatalf("expected %s for %s, got %v", ErrUnsubscribeToken, other, err)
+			}
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		config.Values.Email.Unsubscribe.TokenTTL = -time.Minute
+		defer func() {
+			config.Values.Email.Unsubscribe.TokenTTL = 24 * time.Hour
+		}()
+		if _, _, err := ParseUnsubscribeToken(UnsubscribeToken(123, CategoryMarketing)); !errors.Is(err, ErrUnsubscribeTokenExpired) {
+			t.Fatalf("expected %s, got %v", ErrUnsubscribeTokenExpired, err)
+		}
+	})
+
+	// Every change to the token is rejected (the signature covers the version, person, category and expiry)
+	token := UnsubscribeToken(123, CategoryMarketing)
+	parts := strings.Split(token, ".")
+	expires, _ := strconv.ParseInt(parts[3], 10, 64)
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"another person", strings.Join([]string{parts[0], "124", parts[2], parts[3], parts[4]}, ".")},
+		{"another category", strings.Join([]string{parts[0], parts[1], CategoryProduct, parts[3], parts[4]}, ".")},
+		{"later expiry", strings.Join([]string{parts[0], parts[1], parts[2], strconv.FormatInt(expires+3600, 10), parts[4]}, ".")},
+		{"another signature", strings.Join([]string{parts[0], parts[1], parts[2], parts[3], unsubscribeSignature("other")}, ".")},
+		{"no signature", strings.Join(parts[:4], ".")},
+		{"extra dot in the category", strings.Join([]string{parts[0], parts[1], parts[2], "", parts[3], parts[4]}, ".")},
+	}
+	for _, test := range tests {
+		t.Run("tampered "+test.name, func(t *testing.T) {
+			if _, _, err := ParseUnsubscribeToken(test.token); !errors.Is(err, ErrUnsubscribeToken) {
+				t.Fatalf("expected %s, got %v", ErrUnsubscribeToken, err)
+			}
+		})
+	}
+
+	t.Run("another secret", func(t *testing.T) {
+		config.Values.Email.Unsubscribe.Secret = "another-unsubscribe-secret-0123456789abcdef"
+		defer func() {
+			config.Values.Email.Unsubscribe.Secret = "test-unsubscribe-secret-0123456789abcdef"
+		}()
+		if _, _, err := ParseUnsubscribeToken(token); !errors.Is(err, ErrUnsubscribeToken) {
+			t.Fatalf("expected %s, got %v", ErrUnsubscribeToken, err)
+		}
+	})
+}
+
+// TestQueueEmailCategory tests that emails with a category are only queued for a person
+func TestQueueEmailCategory(t *testing.T) {
+	email := &gomail.Email{Recipients: []string{"jane@example.com"}, Subject: "News", Tags: []string{categoryTagPrefix + CategoryMarketing}}
+	if _, err := QueueEmail(context.Background(), "newsletter", 0, email); !errors.Is(err, ErrPersonRequired) {
+		t.Fatalf("expected %s, got %v", ErrPersonRequired, err)
+	}
+}
@@ -0,0 +1,84 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aymerick/douceur/inliner"
+	"github.com/mrz1836/go-api/config"
+)
+
+// ErrRecipientNotAllowed is when a test email is sent to an address that is not in the allowlist
+var ErrRecipientNotAllowed = errors.New("recipient is not in the test recipients allowlist")
+
+// Preview renders the template with the sample data (or the supplied JSON) and inlines the styles
+func Preview(name, locale string, data []byte) (rendered *RenderedEmail, err error) {
+
+	// Sample data with the supplied data on top
+	var sample interface{}
+	if sample, err = SampleData(name, data); err != nil {
+		return
+	}
+
+	// Render in the locale
+	if rendered, err = RenderLocale(name, locale, sample); err != nil {
+		return
+	}
+
+	// Inline the styles (what the recipient sees)
+	rendered.HTML, err = InlineStyles(rendered.HTML)
+
+	return
+}
+
+// SendTest renders the preview and sends it to an allowlisted address (config email.test_recipients)
+func SendTest(ctx context.Context, name, locale, to string, data []byte) (rendered *RenderedEmail, err error) {
+
+	// Only allowlisted recipients
+	if !TestRecipientAllowed(to) {
+		return nil, fmt.Errorf("%w: %s", ErrRecipientNotAllowed, to)
+	}
+
+	// Render the preview
+	if rendered, err = Preview(name, locale, data); err != nil {
+		return
+	}
+
+	// Send the email (marked as a test)
+	email := NewEmail(rendered, to)
+	email.Subject = "[TEST] " + email.Subject
+	email.Tags = append(email.Tags, "test_send")
+	_, _, err = Deliver(ctx, email)
+
+	return
+}
+
+// InlineStyles moves the <style> rules into style attributes (the same as the email service before sending)
+func InlineStyles(html string) (string, error) {
+	if len(html) == 0 {
+		return html, nil
+	}
+	inlined, err := inliner.Inline(html)
+	if err != nil {
+		return "", fmt.Errorf("error inlining email styles: %w", err)
+	}
+	return inlined, nil
+}
+
+// TestRecipientAllowed returns true if the address (or its @domain) is in the allowlist
+func TestRecipientAllowed(address string) bool {
+	address = strings.ToLower(strings.TrimSpace(address))
+	_, domain, found := strings.Cut(address, "@")
+	if !found || len(domain) == 0 {
+		return false
+	}
+	for _, allowed := range config.Values.Email.TestRecipients {
+		allowed = strings.ToLower(allowed)
+		if allowed == address || allowed == "@"+domain {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,247 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"sync"
+	"time"
+
+	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-api/request"
+	"github.com/mrz1836/go-logger"
+	"github.com/mrz1836/go-mail"
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"    // Provider is used
+	BreakerHalfOpen = "half_open" // Cooldown is over, the next email is a trial
+	BreakerOpen     = "open"      // Provider is skipped until the cooldown is over
+)
+
+// ErrNoProviderAvailable is when every configured provider is missing credentials or has an open breaker
+var ErrNoProviderAvailable = errors.New("no email provider is available")
+
+// ProviderHealth is the health of a provider (used for the admin endpoint)
+type ProviderHealth struct {
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastFailure         *time.Time `json:"last_failure,omitempty"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	Name                string     `json:"name"`
+	OpenUntil           *time.Time `json:"open_until,omitempty"`
+	State               string     `json:"state"`
+}
+
+// providerState is the health and circuit breaker for a provider
+type providerState struct {
+	sync.Mutex
+	health   ProviderHealth
+	provider gomail.ServiceProvider
+	trial    bool // A half-open trial is in flight
+}
+
+// providerChain is the ordered list of providers (from config email.providers)
+type providerChain struct {
+	providers []*providerState
+}
+
+// loadProviderChain loads the providers in order (providers without credentials are skipped)
+func loadProviderChain() (chain *providerChain, err error) {
+
+	chain = new(providerChain)
+	for _, name := range config.Values.Email.Providers {
+		provider, ok := ProviderFromName(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown email provider: %s", name)
+		}
+		if !config.Values.Email.ProviderConfigured(name) {
+			logger.Data(2, logger.WARN, "email provider "+name+" is missing its credentials and will not be used")
+			continue
+		}
+		chain.providers = append(chain.providers, &providerState{
+			health:   ProviderHealth{Name: name, State: BreakerClosed},
+			provider: provider,
+		})
+	}
+
+	return
+}
+
+// Deliver sends the email using the configured providers in order, failing over to the next provider on transient errors
+//
+// Returns the provider that was used last and the Message-ID (only the local sinks return one, see sendEmail)
+func Deliver(ctx context.Context, email *gomail.Email) (gomail.ServiceProvider, string, error) {
+	return deliver(ctx, email, nil)
+}
+
+// deliver sends the email with the extra headers (IE: List-Unsubscribe) using the providers in order
+func deliver(ctx context.Context, email *gomail.Email, headers map[string]string) (provider gomail.ServiceProvider, messageID string, err error) {
+
+	// Suppressed recipients (hard bounces and complaints) are removed
+	if err = removeSuppressed(ctx, email); err != nil {
+		return
+	}
+
+	var errs []error
+	for _, state := range Service.providers.providers {
+
+		// Skip providers with an open breaker
+		if !state.allow() {
+			continue
+		}
+
+		// Send the email
+		provider = state.provider
+		if messageID, err = sendEmail(ctx, email, headers, provider); err == nil {
+			state.success()
+			return
+		}
+
+		// Permanent errors (IE: invalid recipient) will fail on every provider
+		if !IsTransient(err) {
+			state.release()
+			return
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", state.health.Name, err))
+		if state.failure(err) {
+			logger.Data(2, logger.WARN, "email provider "+state.health.Name+" breaker is open", request.LogParameters(ctx)...)
+		}
+	}
+
+	// Every provider failed or was skipped
+	if len(errs) == 0 {
+		return provider, "", ErrNoProviderAvailable
+	}
+	return provider, "", fmt.Errorf("all email providers failed: %w", errors.Join(errs...))
+}
+
+// IsTransient returns true if the error is temporary (network, timeouts, smtp 4xx replies or a transient sms provider error)
+//
+// Anything else is permanent, including the API providers' error replies (go-mail returns them as plain errors)
+func IsTransient(err error) bool {
+
+	// Network errors, timeouts and every provider skipped (open breakers)
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, ErrNoProviderAvailable) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	// SMTP replies (4xx is temporary, 5xx is permanent)
+	var smtpErr *textproto.Error
+	if errors.As(err, &smtpErr) {
+		return smtpErr.Code >= 400 && smtpErr.Code < 500
+	}
+
+	// SMS providers (from the status code, see ProviderError)
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) {
+		return providerErr.Transient
+	}
+	return false
+}
+
+// ProvidersHealth returns the health of each configured provider (in order)
+func ProvidersHealth() (health []ProviderHealth) {
+	if Service == nil || Service.providers == nil {
+		return
+	}
+	for _, state := range Service.providers.providers {
+		health = append(health, state.snapshot())
+	}
+	return
+}
+
+// allow returns true if the provider can be used (an open breaker allows one trial after the cooldown)
+func (p *providerState) allow() bool {
+	p.Lock()
+	defer p.Unlock()
+
+	switch p.health.State {
+	case BreakerOpen:
+		if time.Now().Before(*p.health.OpenUntil) {
+			return false
+		}
+		p.health.State = BreakerHalfOpen
+		p.trial = true
+		return true
+	case BreakerHalfOpen:
+		if p.trial {
+			return false
+		}
+		p.trial = true
+	}
+	return true
+}
+
+// release ends a half-open trial without changing the breaker (the error was not the provider's fault)
+func (p *providerState) release() {
+	p.Lock()
+	p.trial = false
+	p.Unlock()
+}
+
+// success closes the breaker
+func (p *providerState) success() {
+	p.Lock()
+	defer p.Unlock()
+
+	now := time.Now().UTC()
+	p.health.ConsecutiveFailures = 0
+	p.health.LastSuccess = &now
+	p.health.OpenUntil = nil
+	p.health.State = BreakerClosed
+	p.trial = false
+}
+
+// failure records a transient failure and returns true if the breaker was opened
+func (p *providerState) failure(err error) bool {
+	p.Lock()
+	defer p.Unlock()
+
+	now := time.Now().UTC()
+	p.health.ConsecutiveFailures++
+	p.health.LastError = err.Error()
+	p.health.LastFailure = &now
+	p.trial = false
+
+	// A failed trial or too many failures opens the breaker
+	if p.health.State == BreakerHalfOpen || p.health.ConsecutiveFailures >= config.Values.Email.BreakerThreshold {
+		openUntil := now.Add(config.Values.Email.BreakerCooldown)
+		p.health.OpenUntil = &openUntil
+		p.health.State = BreakerOpen
+		return true
+	}
+	return false
+}
+
+// snapshot returns a copy of the health
+func (p *providerState) snapshot() ProviderHealth {
+	p.Lock()
+	defer p.Unlock()
+	return p.health
+}
+
+// ProviderFromName returns the email provider for the name (false if unknown)
+func ProviderFromName(name string) (gomail.ServiceProvider, bool) {
+	switch name {
+	case config.EmailProviderAwsSes:
+		return gomail.AwsSes, true
+	case config.EmailProviderFile:
+		return ProviderFile, true
+	case config.EmailProviderMandrill:
+		return gomail.Mandrill, true
+	case config.EmailProviderMemory:
+		return ProviderMemory, true
+	case config.EmailProviderPostmark:
+		return gomail.Postmark, true
+	case config.EmailProviderSMTP:
+		return gomail.SMTP, true
+	}
+	return 0, false
+}
@@ -0,0 +1,489 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-api/database"
+	"github.com/mrz1836/go-api/request"
+	"github.com/mrz1836/go-api/tracing"
+	"github.com/mrz1836/go-api/webhooks"
+	"github.com/mrz1836/go-logger"
+	"github.com/volatiletech/sqlboiler/v4/queries"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// pushRecordSize is the aes128gcm record size (one record, also the most a push service accepts)
+const pushRecordSize = 4096
+
+// pushHostPattern matches the browser push services (FCM, Mozilla autopush, Apple and Windows WNS)
+var pushHostPattern = regexp.MustCompile(`^(fcm\.googleapis\.com|android\.googleapis\.com|updates\.push\.services\.mozilla\.com|web\.push\.apple\.com|[a-z0-9-]+\.notify\.windows\.com)$`)
+
+var (
+	// ErrPushNotConfigured is when sending a push without a push provider
+	ErrPushNotConfigured = errors.New("push provider is not configured")
+
+	// ErrPushPayloadTooLarge is when the encrypted push message does not fit in one record
+	ErrPushPayloadTooLarge = errors.New("push message is too large")
+
+	// ErrPushSubscriptionGone is when the push service no longer knows the subscription (404 or 410)
+	ErrPushSubscriptionGone = errors.New("push subscription is gone")
+
+	// ErrPushSubscriptionInvalid is when the subscription endpoint or keys are not valid
+	ErrPushSubscriptionInvalid = errors.New("push subscription is not valid")
+)
+
+// PushMessage is the web push payload (JSON for the service worker)
+type PushMessage struct {
+	Body  string            `json:"body"`
+	Data  map[string]string `json:"data,omitempty"`
+	Title string            `json:"title"`
+	URL   string            `json:"url,omitempty"` // Opened when the notification is clicked
+}
+
+// PushSubscription is a browser subscription (push_subscriptions table)
+type PushSubscription struct {
+	Auth      string    `boil:"auth" json:"auth"`
+	CreatedAt time.Time `boil:"created_at" json:"created_at"`
+	Endpoint  string    `boil:"endpoint" json:"endpoint"`
+	ID        uint64    `boil:"id" json:"id"`
+	P256dh    string    `boil:"p256dh" json:"p256dh"`
+	PersonID  uint64    `boil:"person_id" json:"person_id"`
+}
+
+// PushProvider sends an encrypted payload to a subscription (config push.provider)
+type PushProvider interface {
+	Name() string
+	SendPush(ctx context.Context, subscription *PushSubscription, payload []byte) error
+}
+
+// loadPushProvider loads the push provider (nil if push is disabled)
+func loadPushProvider() (PushProvider, error) {
+	switch config.Values.Push.Provider {
+	case "":
+		return nil, nil
+	case config.ChannelProviderFake:
+		return new(FakePush), nil
+	case config.PushProviderVapid:
+		return NewVAPIDPush(config.Values.Push.VapidPrivateKey, config.Values.Push.VapidPublicKey, config.Values.Push.Subject, config.Values.Push.TTL)
+	}
+	return nil, fmt.Errorf("unknown push provider: %s", config.Values.Push.Provider)
+}
+
+// SendPush sends the message to each of the recipient's subscriptions (gone subscriptions are removed)
+func SendPush(ctx context.Context, recipient *Recipient, message *PushMessage) (err error) {
+	if Service == nil || Service.push == nil {
+		return ErrPushNotConfigured
+	}
+
+	// Start the span
+	ctx, span := tracing.StartSpan(ctx, "push send", attribute.String("push.provider", Service.push.Name()))
+	defer func() {
+		tracing.EndSpan(span, err)
+	}()
+
+	// The person's subscriptions
+	subscriptions := recipient.PushSubscriptions
+	if subscriptions == nil && database.ReadDatabase != nil {
+		if subscriptions, err = GetPushSubscriptions(ctx, recipient.PersonID); err != nil {
+			return
+		}
+	}
+	if len(subscriptions) == 0 {
+		return fmt.Errorf("%w: person %d has no push subscriptions", ErrNoChannelAvailable, recipient.PersonID)
+	}
+
+	var payload []byte
+	if payload, err = json.Marshal(message); err != nil {
+		return
+	}
+
+	// Send to each browser (one delivered is a success)
+	var errs []error
+	delivered := 0
+	for _, subscription := range subscriptions {
+		sendErr := Service.push.SendPush(ctx, subscription, payload)
+		switch {
+		case sendErr == nil:
+			delivered++
+		case errors.Is(sendErr, ErrPushSubscriptionGone):
+			if database.WriteDatabase != nil {
+				if deleteErr := DeletePushSubscription(ctx, subscription.PersonID, subscription.Endpoint); deleteErr != nil {
+					logger.Data(2, logger.ERROR, "failed removing push subscription: "+deleteErr.Error(), request.LogParameters(ctx)...)
+				}
+			}
+		default:
+			errs = append(errs, sendErr)
+		}
+	}
+	if delivered == 0 && len(errs) > 0 {
+		return errors.Join(errs...)
+	} else if delivered == 0 {
+		return fmt.Errorf("%w: person %d has no active push subscriptions", ErrNoChannelAvailable, recipient.PersonID)
+	}
+	return nil
+}
+
+// SavePushSubscription saves the browser subscription for the person (the endpoint moves if it was another person's)
+func SavePushSubscription(ctx context.Context, subscription *PushSubscription) (err error) {
+	if err = subscription.check(); err != nil {
+		return
+	}
+	_, err = database.WriteDatabase.ExecContext(ctx,
+		"INSERT INTO `push_subscriptions` (`person_id`, `endpoint`, `p256dh`, `auth`) VALUES (?, ?, ?, ?) "+
+			"ON DUPLICATE KEY UPDATE `person_id` = VALUES(`person_id`), `p256dh` = VALUES(`p256dh`), `auth` = VALUES(`auth`)",
+		subscription.PersonID, subscription.Endpoint, subscription.P256dh, subscription.Auth,
+	)
+	return
+}
+
+// DeletePushSubscription removes the person's browser subscription
+func DeletePushSubscription(ctx context.Context, personID uint64, endpoint string) (err error) {
+	_, err = database.WriteDatabase.ExecContext(ctx,
+		"DELETE FROM `push_subscriptions` WHERE `person_id` = ? AND `endpoint` = ?", personID, endpoint,
+	)
+	return
+}
+
+// GetPushSubscriptions gets the person's browser subscriptions (newest first)
+func GetPushSubscriptions(ctx context.Context, personID uint64) (subscriptions []*PushSubscription, err error) {
+	if err = queries.Raw(
+		"SELECT * FROM `push_subscriptions` WHERE `person_id` = ? ORDER BY `id` DESC", personID,
+	).Bind(ctx, database.ReadDatabase, &subscriptions); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+// PushPublicKey returns the VAPID public key the browsers subscribe with (empty if push is not VAPID)
+func PushPublicKey() string {
+	if config.Values.Push.Provider != config.PushProviderVapid {
+		return ""
+	}
+	return config.Values.Push.VapidPublicKey
+}
+
+// check returns an error if the endpoint is not https or the keys are not P-256 and 16 bytes
+func (s *PushSubscription) check() error {
+	if err := checkPushEndpoint(s.Endpoint); err != nil {
+		return err
+	}
+	_, _, err := s.keys()
+	return err
+}
+
+// checkPushEndpoint returns an error if the endpoint is not https on a known push service (the url comes from the browser)
+func checkPushEndpoint(endpoint string) error {
+	parsed, err := url.Parse(endpoint)
+	if err != nil || parsed.Scheme != "https" || len(parsed.Port()) > 0 {
+		return fmt.Errorf("%w: endpoint must be an https url", ErrPushSubscriptionInvalid)
+	}
+	if !pushHostPattern.MatchString(strings.ToLower(parsed.Hostname())) {
+		return fmt.Errorf("%w: endpoint host %s is not a known push service", ErrPushSubscriptionInvalid, parsed.Hostname())
+	}
+	return nil
+}
+
+// keys decodes the browser public key and auth secret
+func (s *PushSubscription) keys() (publicKey *ecdh.PublicKey, auth []byte, err error) {
+	var raw []byte
+	if raw, err = decodeBase64URL(s.P256dh); err != nil {
+		return nil, nil, fmt.Errorf("%w: p256dh %s", ErrPushSubscriptionInvalid, err.Error())
+	}
+	if publicKey, err = ecdh.P256().NewPublicKey(raw); err != nil {
+		return nil, nil, fmt.Errorf("%w: p256dh %s", ErrPushSubscriptionInvalid, err.Error())
+	}
+	if auth, err = decodeBase64URL(s.Auth); err != nil || len(auth) != 16 {
+		return nil, nil, fmt.Errorf("%w: auth must be 16 bytes", ErrPushSubscriptionInvalid)
+	}
+	return publicKey, auth, nil
+}
+
+// VAPIDPush sends web push messages (RFC 8030) encrypted with aes128gcm (RFC 8291) and signed with VAPID (RFC 8292)
+type VAPIDPush struct {
+	client     *http.Client
+	privateKey *ecdsa.PrivateKey
+	publicKey  string // base64url uncompressed point
+	subject    string
+	ttl        time.Duration
+}
+
+// NewVAPIDPush creates a VAPID provider (keys are base64url, see GenerateVAPIDKeys)
+func NewVAPIDPush(privateKey, publicKey, subject string, ttl time.Duration) (*VAPIDPush, error) {
+
+	// Private key (the scalar)
+	raw, err := decodeBase64URL(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("vapid private key is not base64url: %w", err)
+	}
+	var key *ecdh.PrivateKey
+	if key, err = ecdh.P256().NewPrivateKey(raw); err != nil {
+		return nil, fmt.Errorf("vapid private key is not valid: %w", err)
+	}
+
+	// The public key needs to match (browsers subscribe with it)
+	public := key.PublicKey().Bytes()
+	if base64.RawURLEncoding.EncodeToString(public) != strings.TrimRight(publicKey, "=") {
+		return nil, errors.New("vapid public key does not match the private key")
+	}
+
+	return &VAPIDPush{
+		client: webhooks.NewClient(10*time.Second, false), // Public addresses only, no redirects
+		privateKey: &ecdsa.PrivateKey{
+			D: new(big.Int).SetBytes(raw),
+			PublicKey: ecdsa.PublicKey{
+				Curve: elliptic.P256(),
+				X:     new(big.Int).SetBytes(public[1:33]),
+				Y:     new(big.Int).SetBytes(public[33:]),
+			},
+		},
+		publicKey: base64.RawURLEncoding.EncodeToString(public),
+		subject:   subject,
+		ttl:       ttl,
+	}, nil
+}
+
+// GenerateVAPIDKeys returns a new key pair for config push.vapid_private_key and push.vapid_public_key (base64url)
+func GenerateVAPIDKeys() (privateKey, publicKey string, err error) {
+	var key *ecdh.PrivateKey
+	if key, err = ecdh.P256().GenerateKey(rand.Reader); err != nil {
+		return
+	}
+	return base64.RawURLEncoding.EncodeToString(key.Bytes()), base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
+
+// Name returns the provider name
+func (v *VAPIDPush) Name() string {
+	return config.PushProviderVapid
+}
+
+// SendPush encrypts the payload and posts it to the push service (404 and 410 are ErrPushSubscriptionGone)
+func (v *VAPIDPush) SendPush(ctx context.Context, subscription *PushSubscription, payload []byte) (err error) {
+
+	// Only the known push services (stored subscriptions are checked again)
+	if err = checkPushEndpoint(subscription.Endpoint); err != nil {
+		return
+	}
+
+	// Encrypt for the browser
+	var body []byte
+	if body, err = encryptPushPayload(subscription, payload); err != nil {
+		return
+	}
+
+	// Sign for the push service (the audience is its origin)
+	var endpoint *url.URL
+	if endpoint, err = url.Parse(subscription.Endpoint); err != nil {
+		return fmt.Errorf("%w: %s", ErrPushSubscriptionInvalid, err.Error())
+	}
+	var token string
+	if token, err = v.token(endpoint.Scheme + "://" + endpoint.Host); err != nil {
+		return
+	}
+
+	// Create the request
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodPost, subscription.Endpoint, bytes.NewReader(body)); err != nil {
+		return
+	}
+	req.Header.Set("Authorization", "vapid t="+token+", k="+v.publicKey)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", strconv.Itoa(int(v.ttl.Seconds())))
+	req.Header.Set("Urgency", "normal")
+
+	// Send the message
+	var resp *http.Response
+	if resp, err = v.client.Do(req); err != nil {
+		return fmt.Errorf("error sending push: %w", err)
+	}
+	_ = resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return fmt.Errorf("%w: status %d", ErrPushSubscriptionGone, resp.StatusCode)
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return fmt.Errorf("push service error: status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// token returns the VAPID JWT (ES256) for the push service origin (valid for 12 hours)
+func (v *VAPIDPush) token(audience string) (string, error) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": audience,
+		"exp": time.Now().Add(12 * time.Hour).Unix(),
+		"sub": v.subject,
+	})
+	if err != nil {
+		return "", err
+	}
+	signed := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	// The signature is r and s (32 bytes each)
+	digest := sha256.Sum256([]byte(signed))
+	var r, s *big.Int
+	if r, s, err = ecdsa.Sign(rand.Reader, v.privateKey, digest[:]); err != nil {
+		return "", err
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// encryptPushPayload encrypts the payload for the subscription (RFC 8291, one aes128gcm record)
+func encryptPushPayload(subscription *PushSubscription, payload []byte) ([]byte, error) {
+	// Push services accept 4096 bytes, including the 86 byte header, the delimiter and the tag
+	if len(payload)+1+16 > pushRecordSize-86 {
+		return nil, fmt.Errorf("%w: %d bytes", ErrPushPayloadTooLarge, len(payload))
+	}
+	browserKey, auth, err := subscription.keys()
+	if err != nil {
+		return nil, err
+	}
+
+	// Shared secret with a new key pair (the public key goes in the header)
+	var serverKey *ecdh.PrivateKey
+	if serverKey, err = ecdh.P256().GenerateKey(rand.Reader); err != nil {
+		return nil, err
+	}
+	var secret []byte
+	if secret, err = serverKey.ECDH(browserKey); err != nil {
+		return nil, err
+	}
+	serverPublic := serverKey.PublicKey().Bytes()
+
+	// Input key (combines the auth secret and both public keys)
+	info := append(append([]byte("WebPush: info\x00"), browserKey.Bytes()...), serverPublic...)
+	ikm := hkdfExpand(hkdfExtract(auth, secret), info, 32)
+
+	// Content key and nonce (random salt)
+	salt := make([]byte, 16)
+	if _, err = rand.Read(salt); err != nil {
+		return nil, err
+	}
+	prk := hkdfExtract(salt, ikm)
+	key := hkdfExpand(prk, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdfExpand(prk, []byte("Content-Encoding: nonce\x00"), 12)
+
+	// Encrypt the record (0x02 is the last record delimiter)
+	var block cipher.Block
+	if block, err = aes.NewCipher(key); err != nil {
+		return nil, err
+	}
+	var gcm cipher.AEAD
+	if gcm, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+	encrypted := gcm.Seal(nil, nonce, append(append([]byte{}, payload...), 0x02), nil)
+
+	// Header: salt, record size, key id length and the server public key
+	body := make([]byte, 0, 21+len(serverPublic)+len(encrypted))
+	body = append(body, salt...)
+	body = binary.BigEndian.AppendUint32(body, pushRecordSize)
+	body = append(body, byte(len(serverPublic)))
+	body = append(body, serverPublic...)
+	return append(body, encrypted...), nil
+}
+
+// hkdfExtract is HKDF-Extract with SHA-256 (RFC 5869)
+func hkdfExtract(salt, ikm []byte) []byte {
+	mac := hmac.New(sha256.New, salt)
+	_, _ = mac.Write(ikm)
+	return mac.Sum(nil)
+}
+
+// hkdfExpand is HKDF-Expand with SHA-256 for one block (length is at most 32)
+func hkdfExpand(prk, info []byte, length int) []byte {
+	mac := hmac.New(sha256.New, prk)
+	_, _ = mac.Write(info)
+	_, _ = mac.Write([]byte{0x01})
+	return mac.Sum(nil)[:length]
+}
+
+// decodeBase64URL decodes base64url with or without padding (browsers send either)
+func decodeBase64URL(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+// FakePushMessage is a push recorded by FakePush (the payload is not encrypted)
+type FakePushMessage struct {
+	Endpoint string       `json:"endpoint"`
+	Message  *PushMessage `json:"message"`
+	PersonID uint64       `json:"person_id"`
+	SentAt   time.Time    `json:"sent_at"`
+}
+
+// FakePush records the push messages in-process (config push.provider fake, used by tests)
+type FakePush struct {
+	sync.Mutex
+	gone     map[string]bool
+	messages []*FakePushMessage
+}
+
+// Name returns the provider name
+func (f *FakePush) Name() string {
+	return config.ChannelProviderFake
+}
+
+// SendPush records the message (expired endpoints return ErrPushSubscriptionGone)
+func (f *FakePush) SendPush(_ context.Context, subscription *PushSubscription, payload []byte) error {
+	f.Lock()
+	defer f.Unlock()
+	if f.gone[subscription.Endpoint] {
+		return fmt.Errorf("%w: status %d", ErrPushSubscriptionGone, http.StatusGone)
+	}
+	message := new(PushMessage)
+	if err := json.Unmarshal(payload, message); err != nil {
+		return err
+	}
+	f.messages = append(f.messages, &FakePushMessage{
+		Endpoint: subscription.Endpoint,
+		Message:  message,
+		PersonID: subscription.PersonID,
+		SentAt:   time.Now().UTC(),
+	})
+	return nil
+}
+
+// Expire makes the endpoint return ErrPushSubscriptionGone (like an unsubscribed browser)
+func (f *FakePush) Expire(endpoint string) {
+	f.Lock()
+	defer f.Unlock()
+	if f.gone == nil {
+		f.gone = make(map[string]bool)
+	}
+	f.gone[endpoint] = true
+}
+
+// Messages returns the sent messages (oldest first)
+func (f *FakePush) Messages() []*FakePushMessage {
+	f.Lock()
+	defer f.Unlock()
+	return append([]*FakePushMessage{}, f.messages...)
+}
@@ -0,0 +1,205 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-api/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ErrSMSNotConfigured is when sending a text message without an sms provider
+var ErrSMSNotConfigured = errors.New("sms provider is not configured")
+
+// ProviderError is an error response from a provider (transient is set from the status code)
+type ProviderError struct {
+	Code       string `json:"code,omitempty"` // Provider error code (IE: twilio 21211)
+	Message    string `json:"message"`
+	Provider   string `json:"provider"`
+	StatusCode int    `json:"status_code,omitempty"` // HTTP status (IE: 429)
+	Transient  bool   `json:"transient"`             // Worth trying again
+}
+
+// Error returns the provider, status, code and message
+func (e *ProviderError) Error() string {
+	return fmt.Sprintf("%s error: status %d code %s %s", e.Provider, e.StatusCode, e.Code, e.Message)
+}
+
+// transientStatus returns true if the HTTP status is worth trying again (timeouts, rate limits and server errors)
+func transientStatus(status int) bool {
+	return status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// SMSMessage is a text message
+type SMSMessage struct {
+	Body   string    `json:"body"`
+	From   string    `json:"from"`
+	ID     string    `json:"id"` // Provider message ID (IE: SM12345)
+	SentAt time.Time `json:"sent_at"`
+	To     string    `json:"to"`
+}
+
+// SMSProvider sends text messages (config sms.provider)
+type SMSProvider interface {
+	Name() string
+	SendSMS(ctx context.Context, message *SMSMessage) (id string, err error)
+}
+
+// loadSMSProvider loads the sms provider (nil if sms is disabled)
+func loadSMSProvider() (SMSProvider, error) {
+	switch config.Values.SMS.Provider {
+	case "":
+		return nil, nil
+	case config.ChannelProviderFake:
+		return new(FakeSMS), nil
+	case config.SMSProviderTwilio:
+		return NewTwilioSMS(
+			config.Values.SMS.TwilioURL, config.Values.SMS.TwilioAccountSID, config.Values.SMS.TwilioAuthToken, config.Values.SMS.TwilioFrom,
+		), nil
+	}
+	return nil, fmt.Errorf("unknown sms provider: %s", config.Values.SMS.Provider)
+}
+
+// SendSMS sends the text message using the configured provider (returns the provider message ID)
+func SendSMS(ctx context.Context, to, body string) (id string, err error) {
+	if Service == nil || Service.sms == nil {
+		return "", ErrSMSNotConfigured
+	}
+
+	// Start the span
+	ctx, span := tracing.StartSpan(ctx, "sms send", attribute.String("sms.provider", Service.sms.Name()))
+	defer func() {
+		tracing.EndSpan(span, err)
+	}()
+
+	return Service.sms.SendSMS(ctx, &SMSMessage{Body: body, To: to})
+}
+
+// TwilioSMS sends text messages using the Twilio Messages API (or a compatible API)
+type TwilioSMS struct {
+	accountSID string
+	authToken  string
+	baseURL    string
+	client     *http.Client
+	from       string
+}
+
+// twilioResponse is the Twilio message resource (or error)
+type twilioResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	SID     string `json:"sid"`
+	Status  string `json:"status"`
+}
+
+// NewTwilioSMS creates a Twilio provider (baseURL IE: https://api.twilio.com)
+func NewTwilioSMS(baseURL, accountSID, authToken, from string) *TwilioSMS {
+	return &TwilioSMS{
+		accountSID: accountSID,
+		authToken:  authToken,
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		client:     &http.Client{Timeout: 10 * time.Second},
+		from:       from,
+	}
+}
+
+// Name returns the provider name
+func (t *TwilioSMS) Name() string {
+	return config.SMSProviderTwilio
+}
+
+// SendSMS creates the message (rate limits and server errors are transient, see IsTransient)
+func (t *TwilioSMS) SendSMS(ctx context.Context, message *SMSMessage) (id string, err error) {
+	if len(message.From) == 0 {
+		message.From = t.from
+	}
+
+	// Create the request (form encoded with basic auth)
+	form := url.Values{"Body": {message.Body}, "From": {message.From}, "To": {message.To}}
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodPost,
+		t.baseURL+"/2010-04-01/Accounts/"+url.PathEscape(t.accountSID)+"/Messages.json", strings.NewReader(form.Encode()),
+	); err != nil {
+		return
+	}
+	req.SetBasicAuth(t.accountSID, t.authToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	// Send the message
+	var resp *http.Response
+	if resp, err = t.client.Do(req); err != nil {
+		return "", fmt.Errorf("error sending sms: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	// Read the message resource (or the error)
+	result := new(twilioResponse)
+	var body []byte
+	if body, err = io.ReadAll(io.LimitReader(resp.Body, 1<<20)); err != nil {
+		return "", fmt.Errorf("error reading sms response: %w", err)
+	}
+	_ = json.Unmarshal(body, result)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", &ProviderError{
+			Code:       strconv.Itoa(result.Code),
+			Message:    result.Message,
+			Provider:   config.SMSProviderTwilio,
+			StatusCode: resp.StatusCode,
+			Transient:  transientStatus(resp.StatusCode),
+		}
+	}
+
+	message.ID, message.SentAt = result.SID, time.Now().UTC()
+	return result.SID, nil
+}
+
+// FakeSMS records the text messages in-process (config sms.provider fake, used by tests)
+type FakeSMS struct {
+	sync.Mutex
+	err      error
+	messages []*SMSMessage
+}
+
+// Name returns the provider name
+func (f *FakeSMS) Name() string {
+	return config.ChannelProviderFake
+}
+
+// SendSMS records the message (or returns the error set with Fail)
+func (f *FakeSMS) SendSMS(_ context.Context, message *SMSMessage) (string, error) {
+	f.Lock()
+	defer f.Unlock()
+	if f.err != nil {
+		return "", f.err
+	}
+	message.ID, message.SentAt = fmt.Sprintf("fake-%d", len(f.messages)+1), time.Now().UTC()
+	f.messages = append(f.messages, message)
+	return message.ID, nil
+}
+
+// Fail makes the next sends return the error (nil sends again)
+func (f *FakeSMS) Fail(err error) {
+	f.Lock()
+	f.err = err
+	f.Unlock()
+}
+
+// Messages returns the sent messages (oldest first)
+func (f *FakeSMS) Messages() []*SMSMessage {
+	f.Lock()
+	defer f.Unlock()
+	return append([]*SMSMessage{}, f.messages...)
+}
@@ -0,0 +1,305 @@
+package notifications
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1" //nolint:gosec // SNS signature version 1 is SHA1
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mrz1836/go-api/config"
+)
+
+// SNS message types
+const (
+	SNSTypeNotification             = "Notification"
+	SNSTypeSubscriptionConfirmation = "SubscriptionConfirmation"
+	SNSTypeUnsubscribeConfirmation  = "UnsubscribeConfirmation"
+)
+
+// snsHostPattern matches the SNS hosts (signing certificates and subscribe urls)
+var snsHostPattern = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+var (
+	// snsCertificates are the signing certificates by url (they rarely change)
+	snsCertificates sync.Map
+
+	// snsClient is used for the signing certificates and subscription confirmations
+	snsClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// SNSMessage is an SNS HTTP(S) delivery (SES events are in the message)
+type SNSMessage struct {
+	Message          string `json:"Message"`
+	MessageID        string `json:"MessageId"`
+	Signature        string `json:"Signature"`
+	SignatureVersion string `json:"SignatureVersion"`
+	SigningCertURL   string `json:"SigningCertURL"`
+	Subject          string `json:"Subject,omitempty"`
+	SubscribeURL     string `json:"SubscribeURL,omitempty"`
+	Timestamp        string `json:"Timestamp"`
+	Token            string `json:"Token,omitempty"`
+	TopicArn         string `json:"TopicArn"`
+	Type             string `json:"Type"`
+}
+
+// sesPayload is an SES notification or event publishing record
+type sesPayload struct {
+	Bounce *struct {
+		BounceSubType     string `json:"bounceSubType"`
+		BounceType        string `json:"bounceType"`
+		BouncedRecipients []struct {
+			DiagnosticCode string `json:"diagnosticCode"`
+			EmailAddress   string `json:"emailAddress"`
+		} `json:"bouncedRecipients"`
+		Timestamp string `json:"timestamp"`
+	} `json:"bounce"`
+	Click *struct {
+		Link      string `json:"link"`
+		Timestamp string `json:"timestamp"`
+	} `json:"click"`
+	Complaint *struct {
+		ComplainedRecipients []struct {
+			EmailAddress string `json:"emailAddress"`
+		} `json:"complainedRecipients"`
+		ComplaintFeedbackType string `json:"complaintFeedbackType"`
+		Timestamp             string `json:"timestamp"`
+	} `json:"complaint"`
+	Delivery *struct {
+		Recipients []string `json:"recipients"`
+		Timestamp  string   `json:"timestamp"`
+	} `json:"delivery"`
+	EventType string `json:"eventType"`
+	Mail      struct {
+		Destination []string            `json:"destination"`
+		MessageID   string              `json:"messageId"`
+		Tags        map[string][]string `json:"tags"`
+	} `json:"mail"`
+	NotificationType string `json:"notificationType"`
+	Open             *struct {
+		Timestamp string `json:"timestamp"`
+	} `json:"open"`
+}
+
+// VerifySNSMessage checks the topic (config email.webhooks.ses_topic_arns) and the signature of the message
+func VerifySNSMessage(ctx context.Context, message *SNSMessage) error {
+
+	// Only the configured topics
+	allowed := false
+	for _, arn := range config.Values.Email.Webhooks.SesTopicArns {
+		if arn == message.TopicArn {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("%w: topic %s is not allowed", ErrWebhookUnauthorized, message.TopicArn)
+	}
+
+	// Hash of the signed string (version 1 is SHA1, version 2 is SHA256)
+	var hash crypto.Hash
+	var digest []byte
+	switch message.SignatureVersion {
+	case "1":
+		sum := sha1.Sum([]byte(message.signedString())) //nolint:gosec // SNS signature version 1 is SHA1
+		hash, digest = crypto.SHA1, sum[:]
+	case "2":
+		sum := sha256.Sum256([]byte(message.signedString()))
+		hash, digest = crypto.SHA256, sum[:]
+	default:
+		return fmt.Errorf("%w: signature version %s", ErrWebhookUnauthorized, message.SignatureVersion)
+	}
+
+	// Signing certificate (only from SNS)
+	certificate, err := snsCertificate(ctx, message.SigningCertURL)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrWebhookUnauthorized, err.Error())
+	}
+
+	// Check the signature (x509 no longer accepts SHA1, so the RSA signature is checked directly)
+	publicKey, ok := certificate.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: signing certificate is not rsa", ErrWebhookUnauthorized)
+	}
+	var signature []byte
+	if signature, err = base64.StdEncoding.DecodeString(message.Signature); err != nil {
+		return fmt.Errorf("%w: %s", ErrWebhookUnauthorized, err.Error())
+	}
+	if err = rsa.VerifyPKCS1v15(publicKey, hash, digest, signature); err != nil {
+		return fmt.Errorf("%w: %s", ErrWebhookUnauthorized, err.Error())
+	}
+	return nil
+}
+
+// ConfirmSNSSubscription visits the subscribe url (the message needs to be verified first)
+func ConfirmSNSSubscription(ctx context.Context, message *SNSMessage) (err error) {
+	if !isSNSURL(message.SubscribeURL) {
+		return fmt.Errorf("%w: subscribe url %s", ErrWebhookPayload, message.SubscribeURL)
+	}
+
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, message.SubscribeURL, nil); err != nil {
+		return
+	}
+	var resp *http.Response
+	if resp, err = snsClient.Do(req); err != nil {
+		return fmt.Errorf("error confirming sns subscription: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error confirming sns subscription: status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// RecordSESEvents records the SES events in the SNS message (bounces, complaints, deliveries, opens and clicks)
+func RecordSESEvents(ctx context.Context, message *SNSMessage) (int, error) {
+	payload := new(sesPayload)
+	if err := json.Unmarshal([]byte(message.Message), payload); err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrWebhookPayload, err.Error())
+	}
+
+	// SES notifications use notificationType, event publishing uses eventType
+	kind := payload.EventType
+	if len(kind) == 0 {
+		kind = payload.NotificationType
+	}
+
+	// One event per recipient
+	var events []*webhookEvent
+	add := func(address, eventType, description, occurredAt string) {
+		events = append(events, &webhookEvent{
+			event: &EmailEvent{
+				Description:       description,
+				Email:             address,
+				EventType:         eventType,
+				OccurredAt:        parseEventTime(occurredAt),
+				Payload:           json.RawMessage(message.Message),
+				Provider:          config.EmailProviderAwsSes,
+				ProviderEventID:   config.EmailProviderAwsSes + ":" + message.MessageID + ":" + normalizeAddress(address),
+				ProviderMessageID: payload.Mail.MessageID,
+			},
+			tags: sesTags(payload.Mail.Tags),
+		})
+	}
+	switch {
+	case kind == "Bounce" && payload.Bounce != nil:
+		eventType := EmailEventSoftBounce
+		if payload.Bounce.BounceType == "Permanent" {
+			eventType = EmailEventHardBounce
+		}
+		for _, recipient := range payload.Bounce.BouncedRecipients {
+			description := recipient.DiagnosticCode
+			if len(description) == 0 {
+				description = payload.Bounce.BounceType + ": " + payload.Bounce.BounceSubType
+			}
+			add(recipient.EmailAddress, eventType, description, payload.Bounce.Timestamp)
+		}
+	case kind == "Complaint" && payload.Complaint != nil:
+		for _, recipient := range payload.Complaint.ComplainedRecipients {
+			add(recipient.EmailAddress, EmailEventComplaint, payload.Complaint.ComplaintFeedbackType, payload.Complaint.Timestamp)
+		}
+	case kind == "Delivery" && payload.Delivery != nil:
+		for _, recipient := range payload.Delivery.Recipients {
+			add(recipient, EmailEventDelivered, "", payload.Delivery.Timestamp)
+		}
+	case kind == "Open" && payload.Open != nil:
+		for _, recipient := range payload.Mail.Destination {
+			add(recipient, EmailEventOpened, "", payload.Open.Timestamp)
+		}
+	case kind == "Click" && payload.Click != nil:
+		for _, recipient := range payload.Mail.Destination {
+			add(recipient, EmailEventClicked, payload.Click.Link, payload.Click.Timestamp)
+		}
+	}
+
+	return recordWebhookEvents(ctx, events)
+}
+
+// signedString returns the string that SNS signs (the fields depend on the message type)
+func (m *SNSMessage) signedString() string {
+	fields := [][2]string{{"Message", m.Message}, {"MessageId", m.MessageID}}
+	if m.Type == SNSTypeNotification {
+		if len(m.Subject) > 0 {
+			fields = append(fields, [2]string{"Subject", m.Subject})
+		}
+		fields = append(fields, [2]string{"Timestamp", m.Timestamp})
+	} else {
+		fields = append(fields, [2]string{"SubscribeURL", m.SubscribeURL}, [2]string{"Timestamp", m.Timestamp}, [2]string{"Token", m.Token})
+	}
+	fields = append(fields, [2]string{"TopicArn", m.TopicArn}, [2]string{"Type", m.Type})
+
+	var signed strings.Builder
+	for _, field := range fields {
+		signed.WriteString(field[0] + "\n" + field[1] + "\n")
+	}
+	return signed.String()
+}
+
+// snsCertificate gets the signing certificate (cached by url)
+func snsCertificate(ctx context.Context, certURL string) (certificate *x509.Certificate, err error) {
+	if cached, ok := snsCertificates.Load(certURL); ok {
+		return cached.(*x509.Certificate), nil
+	}
+	if !isSNSURL(certURL) || !strings.HasSuffix(certURL, ".pem") {
+		return nil, fmt.Errorf("signing certificate url %s is not from sns", certURL)
+	}
+
+	// Download the certificate
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, certURL, nil); err != nil {
+		return
+	}
+	var resp *http.Response
+	if resp, err = snsClient.Do(req); err != nil {
+		return nil, fmt.Errorf("error getting signing certificate: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	var body []byte
+	if body, err = io.ReadAll(io.LimitReader(resp.Body, 1<<20)); err != nil {
+		return nil, fmt.Errorf("error reading signing certificate: %w", err)
+	}
+
+	// Parse the certificate
+	block, _ := pem.Decode(body)
+	if block == nil {
+		return nil, fmt.Errorf("signing certificate is not pem")
+	}
+	if certificate, err = x509.ParseCertificate(block.Bytes); err != nil {
+		return nil, fmt.Errorf("error parsing signing certificate: %w", err)
+	}
+
+	snsCertificates.Store(certURL, certificate)
+	return
+}
+
+// isSNSURL returns true if the url is https on an SNS host
+func isSNSURL(value string) bool {
+	parsed, err := url.Parse(value)
+	return err == nil && parsed.Scheme == "https" && snsHostPattern.MatchString(parsed.Hostname())
+}
+
+// sesTags flattens the SES message tags (names and values, used to find the outbox tag)
+func sesTags(tags map[string][]string) (flat []string) {
+	for name, values := range tags {
+		flat = append(flat, name)
+		flat = append(flat, values...)
+	}
+	return
+}
@@ -0,0 +1,172 @@
+package notifications
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1" //nolint:gosec // SNS signature version 1 is SHA1
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/mrz1836/go-api/config"
+)
+
+// testSNSCertURL is the signing certificate url used by the tests (cached, never downloaded)
+const testSNSCertURL = "https://sns.us-east-1.amazonaws.com/SimpleNotificationService-test.pem"
+
+// testSNSSigner creates a signing key and caches its certificate at testSNSCertURL
+func testSNSSigner(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating key: %s", err.Error())
+	}
+	template := &x509.Certificate{
+		NotAfter:     time.Now().Add(time.Hour),
+		NotBefore:    time.Now().Add(-time.Hour),
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+	}
+	var der []byte
+	if der, err = x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key); err != nil {
+		t.Fatalf("error creating certificate: %s", err.Error())
+	}
+	var certificate *x509.Certificate
+	if certificate, err = x509.ParseCertificate(der); err != nil {
+		t.Fatalf("error parsing certificate: %s", err.Error())
+	}
+	snsCertificates.Store(testSNSCertURL, certificate)
+	return key
+}
+
+// signSNSMessage signs the message with the key (version 1 is SHA1, version 2 is SHA256)
+func signSNSMessage(t *testing.T, key *rsa.PrivateKey, message *SNSMessage) {
+	t.Helper()
+	hash, digest := crypto.SHA256, sha256.Sum256([]byte(message.signedString()))
+	sum := digest[:]
+	if message.SignatureVersion == "1" {
+		sha1Digest := sha1.Sum([]byte(message.signedString())) //nolint:gosec // SNS signature version 1 is SHA1
+		hash, sum = crypto.SHA1, sha1Digest[:]
+	}
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, hash, sum)
+	if err != nil {
+		t.Fatalf("error signing message: %s", err.Error())
+	}
+	message.Signature = base64.StdEncoding.EncodeToString(signature)
+}
+
+// TestVerifySNSMessage tests the topic, certificate and signature checks
+func TestVerifySNSMessage(t *testing.T) {
+	const topic = "arn:aws:sns:us-east-1:123456789012:ses-events"
+	config.Values.Email.Webhooks.SesTopicArns = []string{topic}
+	key := testSNSSigner(t)
+
+	newMessage := func(version string) *SNSMessage {
+		message := &SNSMessage{
+			Message:          `{"notificationType":"Bounce"}`,
+			MessageID:        "22b80b92-fdea-4c2c-8f9d-bdfb0c7bf324",
+			SignatureVersion: version,
+			SigningCertURL:   testSNSCertURL,
+			Timestamp:        "2024-01-01T00:00:00.000Z",
+			TopicArn:         topic,
+			Type:             SNSTypeNotification,
+		}
+		signSNSMessage(t, key, message)
+		return message
+	}
+
+	tests := []struct {
+		name   string
+		modify func(message *SNSMessage)
+		valid  bool
+	}{
+		{"known good version 1", func(message *SNSMessage) { message.SignatureVersion = "1"; signSNSMessage(t, key, message) }, true},
+		{"known good version 2", func(*SNSMessage) {}, true},
+		{"tampered message", func(message *SNSMessage) { message.Message = `{"notificationType":"Complaint"}` }, false},
+		{"tampered timestamp", func(message *SNSMessage) { message.Timestamp = "2024-01-02T00:00:00.000Z" }, false},
+		{"tampered type", func(message *SNSMessage) { message.Type = SNSTypeSubscriptionConfirmation }, false},
+		{"tampered signature", func(message *SNSMessage) { message.Signature = "AAAA" + message.Signature[4:] }, false},
+		{"signature not base64", func(message *SNSMessage) { message.Signature = "not base64!" }, false},
+		{"unknown topic", func(message *SNSMessage) { message.TopicArn = "arn:aws:sns:us-east-1:123456789012:other" }, false},
+		{"unknown signature version", func(message *SNSMessage) { message.SignatureVersion = "3" }, false},
+		{"certificate not from sns", func(message *SNSMessage) {
+			message.SigningCertURL = "https://sns.evil.amazonaws.com.attacker.io/x.pem"
+		}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			message := newMessage("2")
+			test.modify(message)
+			err := VerifySNSMessage(context.Background(), message)
+			if test.valid && err != nil {
+				t.Fatalf("expected a valid message, got %s", err.Error())
+			} else if !test.valid && !errors.Is(err, ErrWebhookUnauthorized) {
+				t.Fatalf("expected %s, got %v", ErrWebhookUnauthorized, err)
+			}
+		})
+	}
+}
+
+// TestSNSMessageSignedString tests the signed fields for each message type
+func TestSNSMessageSignedString(t *testing.T) {
+	tests := []struct {
+		name     string
+		message  *SNSMessage
+		expected string
+	}{
+		{"notification", &SNSMessage{
+			Message: "hello", MessageID: "1", Timestamp: "2024-01-01T00:00:00.000Z", TopicArn: "arn", Type: SNSTypeNotification,
+		}, "Message\nhello\nMessageId\n1\nTimestamp\n2024-01-01T00:00:00.000Z\nTopicArn\narn\nType\nNotification\n"},
+		{"notification with subject", &SNSMessage{
+			Message: "hello", MessageID: "1", Subject: "hi", Timestamp: "2024-01-01T00:00:00.000Z", TopicArn: "arn", Type: SNSTypeNotification,
+		}, "Message\nhello\nMessageId\n1\nSubject\nhi\nTimestamp\n2024-01-01T00:00:00.000Z\nTopicArn\narn\nType\nNotification\n"},
+		{"subscription confirmation", &SNSMessage{
+			Message: "confirm", MessageID: "2", Subject: "ignored", SubscribeURL: "https://sns.us-east-1.amazonaws.com/?Action=ConfirmSubscription",
+			Timestamp: "2024-01-01T00:00:00.000Z", Token: "token", TopicArn: "arn", Type: SNSTypeSubscriptionConfirmation,
+		}, "Message\nconfirm\nMessageId\n2\nSubscribeURL\nhttps://sns.us-east-1.amazonaws.com/?Action=ConfirmSubscription\n" +
+			"Timestamp\n2024-01-01T00:00:00.000Z\nToken\ntoken\nTopicArn\narn\nType\nSubscriptionConfirmation\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if signed := test.message.signedString(); signed != test.expected {
+				t.Fatalf("expected %q, got %q", test.expected, signed)
+			}
+		})
+	}
+}
+
+// TestSNSCertificate tests that signing certificates are only taken from sns urls
+func TestSNSCertificate(t *testing.T) {
+	testSNSSigner(t)
+
+	t.Run("cached certificate", func(t *testing.T) {
+		if _, err := snsCertificate(context.Background(), testSNSCertURL); err != nil {
+			t.Fatalf("expected the cached certificate, got %s", err.Error())
+		}
+	})
+
+	// Rejected before any request is made
+	for _, certURL := range []string{
+		"https://sns.evil.amazonaws.com.attacker.io/x.pem",
+		"https://attacker.io/sns.us-east-1.amazonaws.com/x.pem",
+		"https://sns.us-east-1.amazonaws.com.attacker.io/x.pem",
+		"https://evil.sns.us-east-1.amazonaws.com@attacker.io/x.pem",
+		"http://sns.us-east-1.amazonaws.com/x.pem",
+		"https://sns.us-east-1.amazonaws.com/x.txt",
+		"https://s3.amazonaws.com/x.pem",
+		"not a url",
+	} {
+		t.Run(certURL, func(t *testing.T) {
+			if _, err := snsCertificate(context.Background(), certURL); err == nil {
+				t.Fatalf("expected %s to be rejected", certURL)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package notifications
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mrz1836/go-api/database"
+	"github.com/mrz1836/go-mail"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/queries"
+)
+
+// ErrRecipientsSuppressed is when every recipient of the email is suppressed (hard bounce or complaint)
+var ErrRecipientsSuppressed = errors.New("all recipients are suppressed")
+
+// ErrSuppressionNotFound is when the address is not suppressed
+var ErrSuppressionNotFound = errors.New("email suppression not found")
+
+// EmailSuppression is an address that is never sent to (email_suppressions table)
+type EmailSuppression struct {
+	CreatedAt time.Time   `boil:"created_at" json:"created_at"`
+	Email     string      `boil:"email" json:"email"`
+	EventID   null.Uint64 `boil:"event_id" json:"event_id,omitempty"`
+	ID        uint64      `boil:"id" json:"id"`
+	Provider  string      `boil:"provider" json:"provider,omitempty"`
+	Reason    string      `boil:"reason" json:"reason"`
+}
+
+// SuppressEmail stops all future sends to the address (the first reason is kept)
+func SuppressEmail(ctx context.Context, address, reason, provider string, eventID uint64) (err error) {
+	_, err = database.WriteDatabase.ExecContext(ctx,
+		"INSERT IGNORE INTO `email_suppressions` (`email`, `reason`, `provider`, `event_id`) VALUES (?, ?, ?, ?)",
+		normalizeAddress(address), reason, provider, null.NewUint64(eventID, eventID > 0),
+	)
+	return
+}
+
+// UnsuppressEmail allows sending to the address again
+func UnsuppressEmail(ctx context.Context, address string) error {
+	result, err := database.WriteDatabase.ExecContext(ctx,
+		"DELETE FROM `email_suppressions` WHERE `email` = ?", normalizeAddress(address),
+	)
+	if err != nil {
+		return err
+	}
+	var rows int64
+	if rows, err = result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return fmt.Errorf("%w: %s", ErrSuppressionNotFound, address)
+	}
+	return nil
+}
+
+// GetSuppressions gets the latest suppressed addresses (newest first)
+func GetSuppressions(ctx context.Context, limit int) (suppressions []*EmailSuppression, err error) {
+	err = queries.Raw(
+		"SELECT * FROM `email_suppressions` ORDER BY `id` DESC LIMIT ?", limit,
+	).Bind(ctx, database.ReadDatabase, &suppressions)
+	return
+}
+
+// SuppressedAddresses returns the addresses that are suppressed (lowercase)
+func SuppressedAddresses(ctx context.Context, addresses []string) (suppressed map[string]bool, err error) {
+	suppressed = make(map[string]bool)
+	if len(addresses) == 0 {
+		return
+	}
+
+	// Find the suppressed addresses
+	args := make([]interface{}, 0, len(addresses))
+	for _, address := range addresses {
+		args = append(args, normalizeAddress(address))
+	}
+	var suppressions []*EmailSuppression
+	if err = queries.Raw(
+		"SELECT * FROM `email_suppressions` WHERE `email` IN (?"+strings.Repeat(", ?", len(args)-1)+")", args...,
+	).Bind(ctx, database.ReadDatabase, &suppressions); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	for _, suppression := range suppressions {
+		suppressed[suppression.Email] = true
+	}
+	return suppressed, nil
+}
+
+// removeSuppressed removes the suppressed recipients from the email (errors if none are left)
+func removeSuppressed(ctx context.Context, email *gomail.Email) error {
+
+	// No database (IE: tests using the in-process mailbox)
+	if database.ReadDatabase == nil {
+		return nil
+	}
+
+	// Find the suppressed recipients
+	all := append(append(append([]string{}, email.Recipients...), email.CcRecipients...), email.BccRecipients...)
+	suppressed, err := SuppressedAddresses(ctx, all)
+	if err != nil {
+		return fmt.Errorf("error checking email suppressions: %w", err)
+	} else if len(suppressed) == 0 {
+		return nil
+	}
+
+	// Remove them
+	keep := func(addresses []string) (kept []string) {
+		for _, address := range addresses {
+			if !suppressed[normalizeAddress(address)] {
+				kept = append(kept, address)
+			}
+		}
+		return
+	}
+	email.Recipients = keep(email.Recipients)
+	email.CcRecipients = keep(email.CcRecipients)
+	email.BccRecipients = keep(email.BccRecipients)
+
+	if len(email.Recipients)+len(email.CcRecipients)+len(email.BccRecipients) == 0 {
+		return fmt.Errorf("%w: %s", ErrRecipientsSuppressed, strings.Join(all, ", "))
+	}
+	return nil
+}
+
+// normalizeAddress returns the address used for suppressions and events (lowercase)
+func normalizeAddress(address string) string {
+	return strings.ToLower(strings.TrimSpace(address))
+}
@@ -0,0 +1,489 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+
+	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-logger"
+	"github.com/mrz1836/go-mail"
+)
+
+// Template layout conventions (paths are relative to static/views/emails)
+const (
+	contentBlock  = "content"         // Templates that define this block are rendered inside the default layout
+	extHTML       = ".html"           // HTML variant
+	extJSON       = ".json"           // Message catalog
+	extText       = ".txt"            // Plain text variant
+	layoutDefault = "layouts/default" // Default layout (must call {{template "content" .}})
+	layoutsDir    = "layouts/"        // Layouts are shared by every template
+	localesDir    = "locales/"        // Message catalogs (IE: locales/es.json)
+	partialsDir   = "partials/"       // Partials ({{define "name"}}) are shared by every template
+)
+
+// Template errors
+var (
+	ErrTemplateNotDefined = errors.New("email template is not defined")
+	ErrTemplateNotFound   = errors.New("email template not found")
+)
+
+// TemplatesFS is where the templates are loaded from (IE: an embed.FS), the default is static/views/emails
+var TemplatesFS fs.FS
+
+// RenderedEmail is the output of a template
+type RenderedEmail struct {
+	Category string `json:"category,omitempty"`
+	HTML     string `json:"html"`
+	Subject  string `json:"subject"`
+	Text     string `json:"text"`
+}
+
+// Template is an email template with a typed data struct
+type Template[T any] struct {
+	Name string // Path without the extension (IE: persons/example_email)
+}
+
+// TemplateInfo is a loaded template (used for previews)
+type TemplateInfo struct {
+	Category string   `json:"category,omitempty"`
+	DataType string   `json:"data_type"`
+	Defined  bool     `json:"defined"`
+	Locales  []string `json:"locales"`
+	Name     string   `json:"name"`
+}
+
+// templateDefinition is the data type, subject and sample data for a template name
+type templateDefinition struct {
+	category string
+	dataType reflect.Type
+	sample   interface{}
+	subject  *texttemplate.Template
+}
+
+// emailTemplate is a loaded template for a locale (any missing variant or subject falls back to the next locale)
+type emailTemplate struct {
+	html     *htmltemplate.Template
+	htmlRoot string // Template executed (the layout or the file)
+	subject  *texttemplate.Template
+	text     *texttemplate.Template
+	textRoot string // Template executed (the layout or the file)
+}
+
+// localizedTemplates are the loaded templates by locale ("" is the default, files without a locale suffix)
+type localizedTemplates map[string]*emailTemplate
+
+// definitions and loaded templates by name
+var (
+	definitions     = make(map[string]*templateDefinition)
+	definitionMutex sync.RWMutex
+	templates       = make(map[string]localizedTemplates)
+	templatesMutex  sync.RWMutex
+)
+
+// DefineTemplate registers the data type and subject (text/template) for a template (call from a package var or init)
+func DefineTemplate[T any](name, subject string) *Template[T] {
+	definitionMutex.Lock()
+	defer definitionMutex.Unlock()
+	if _, ok := definitions[name]; ok {
+		panic("email template already defined: " + name)
+	}
+	definitions[name] = &templateDefinition{
+		dataType: reflect.TypeOf((*T)(nil)).Elem(),
+		subject:  texttemplate.Must(texttemplate.New(name).Option("missingkey=error").Parse(subject)),
+	}
+	return &Template[T]{Name: name}
+}
+
+// WithSample sets the sample data used for previews (the default is an empty struct)
+func (t *Template[T]) WithSample(sample *T) *Template[T] {
+	definitionMutex.Lock()
+	definitions[t.Name].sample = sample
+	definitionMutex.Unlock()
+	return t
+}
+
+// WithCategory sets the notification category (checked against the person's preferences, the default is transactional)
+func (t *Template[T]) WithCategory(category string) *Template[T] {
+	if _, err := categoryDefault(category); err != nil {
+		panic(err)
+	}
+	definitionMutex.Lock()
+	definitions[t.Name].category = category
+	definitionMutex.Unlock()
+	return t
+}
+
+// Render renders the template with the data
+func (t *Template[T]) Render(data *T) (*RenderedEmail, error) {
+	return Render(t.Name, data)
+}
+
+// RenderLocale renders the template in the locale (falls back to the language, then the default)
+func (t *Template[T]) RenderLocale(locale string, data *T) (*RenderedEmail, error) {
+	return RenderLocale(t.Name, locale, data)
+}
+
+// Send renders the template and sends it to the recipient (transactional templates only, see ErrPersonRequired)
+func (t *Template[T]) Send(ctx context.Context, to string, data *T) error {
+	return Send(ctx, t.Name, to, data)
+}
+
+// SendLocale renders the template in the locale and sends it to the recipient
+func (t *Template[T]) SendLocale(ctx context.Context, locale, to string, data *T) error {
+	return SendLocale(ctx, t.Name, locale, to, data)
+}
+
+// Render renders the template by name in the default locale (the data must be the defined type)
+func Render(name string, data interface{}) (*RenderedEmail, error) {
+	return RenderLocale(name, "", data)
+}
+
+// RenderLocale renders the template by name in the locale (IE: es-mx, then es, then the default)
+func RenderLocale(name, locale string, data interface{}) (*RenderedEmail, error) {
+
+	// Find the definition and the template
+	definitionMutex.RLock()
+	definition, ok := definitions[name]
+	definitionMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotDefined, name)
+	}
+	templatesMutex.RLock()
+	loaded, found := templates[name]
+	templatesMutex.RUnlock()
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+
+	// Check the data type
+	if dataType := reflect.TypeOf(data); dataType != definition.dataType && dataType != reflect.PointerTo(definition.dataType) {
+		return nil, fmt.Errorf("email template %s expects %s data but got %v", name, definition.dataType, dataType)
+	}
+
+	rendered, err := loaded.render(definition, locale, data)
+	if err != nil {
+		return nil, err
+	}
+	rendered.Category = definition.category
+	return rendered, nil
+}
+
+// Send renders the template by name and sends it to the recipient (using the configured providers in order)
+//
+// Templates with a category return ErrPersonRequired (DeliverToPerson checks the preference and adds the unsubscribe headers)
+func Send(ctx context.Context, name, to string, data interface{}) error {
+	return SendLocale(ctx, name, "", to, data)
+}
+
+// SendLocale renders the template by name in the locale and sends it to the recipient
+func SendLocale(ctx context.Context, name, locale, to string, data interface{}) error {
+	rendered, err := RenderLocale(name, locale, data)
+	if err != nil {
+		return err
+	} else if len(rendered.Category) > 0 {
+		return fmt.Errorf("%w: %s is %s", ErrPersonRequired, name, rendered.Category)
+	}
+	_, _, err = Deliver(ctx, NewEmail(rendered, to))
+	return err
+}
+
+// NewEmail starts a new email with the rendered content (tagged with the notification category)
+func NewEmail(rendered *RenderedEmail, to ...string) *gomail.Email {
+	email := Service.EmailService.NewEmail()
+	email.HTMLContent = rendered.HTML
+	email.PlainTextContent = rendered.Text
+	email.Recipients = append(email.Recipients, to...)
+	email.Subject = rendered.Subject
+	if len(rendered.Category) > 0 {
+		email.Tags = append(email.Tags, categoryTagPrefix+rendered.Category)
+	}
+	return email
+}
+
+// Templates returns all loaded templates with their locales (sorted by name)
+func Templates() (list []TemplateInfo) {
+	templatesMutex.RLock()
+	defer templatesMutex.RUnlock()
+	definitionMutex.RLock()
+	defer definitionMutex.RUnlock()
+
+	for name, localized := range templates {
+		info := TemplateInfo{Name: name}
+		if definition, ok := definitions[name]; ok {
+			info.Category, info.DataType, info.Defined = definition.category, definition.dataType.String(), true
+		}
+		for locale := range localized {
+			if len(locale) > 0 {
+				info.Locales = append(info.Locales, locale)
+			}
+		}
+		sort.Strings(info.Locales)
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return
+}
+
+// SampleData returns the sample data for the template with any supplied JSON decoded on top
+func SampleData(name string, supplied []byte) (interface{}, error) {
+	definitionMutex.RLock()
+	definition, ok := definitions[name]
+	definitionMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotDefined, name)
+	}
+
+	// Copy the sample (so the supplied data never changes it)
+	data := reflect.New(definition.dataType).Interface()
+	if definition.sample != nil {
+		sample, err := json.Marshal(definition.sample)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding sample data for %s: %w", name, err)
+		}
+		if err = json.Unmarshal(sample, data); err != nil {
+			return nil, fmt.Errorf("error decoding sample data for %s: %w", name, err)
+		}
+	}
+
+	// Supplied data
+	if len(supplied) > 0 {
+		if err := json.Unmarshal(supplied, data); err != nil {
+			return nil, fmt.Errorf("invalid data for %s (expects %s): %w", name, definition.dataType, err)
+		}
+	}
+
+	return data, nil
+}
+
+// render executes the html and text variants and the subject (each from the first locale that has it)
+func (l localizedTemplates) render(definition *templateDefinition, locale string, data interface{}) (rendered *RenderedEmail, err error) {
+
+	// Find each part (IE: es-mx, es, then the default)
+	var html, text *emailTemplate
+	subject := definition.subject
+	subjectFound := false
+	for _, candidate := range localeCandidates(locale) {
+		loaded, ok := l[candidate]
+		if !ok {
+			continue
+		}
+		if html == nil && loaded.html != nil {
+			html = loaded
+		}
+		if text == nil && loaded.text != nil {
+			text = loaded
+		}
+		if !subjectFound && loaded.subject != nil {
+			subject, subjectFound = loaded.subject, true
+		}
+	}
+
+	rendered = new(RenderedEmail)
+	var buffer bytes.Buffer
+
+	// HTML variant
+	if html != nil {
+		if err = html.html.ExecuteTemplate(&buffer, html.htmlRoot, data); err != nil {
+			return nil, err
+		}
+		rendered.HTML = buffer.String()
+		buffer.Reset()
+	}
+
+	// Text variant
+	if text != nil {
+		if err = text.text.ExecuteTemplate(&buffer, text.textRoot, data); err != nil {
+			return nil, err
+		}
+		rendered.Text = buffer.String()
+		buffer.Reset()
+	}
+
+	// Subject
+	if err = subject.Execute(&buffer, data); err != nil {
+		return nil, err
+	}
+	rendered.Subject = strings.TrimSpace(buffer.String())
+
+	return
+}
+
+// loadTemplates walks the templates, pairs the html and text variants by name and validates every definition
+func loadTemplates() (err error) {
+
+	// Default is the static directory
+	fsys := TemplatesFS
+	if fsys == nil {
+		fsys = os.DirFS(filepath.Join(config.GetCurrentDir(), "..", "static", "views", "emails"))
+	}
+
+	// Shared layouts and partials (parsed first), then the templates
+	htmlShared := htmltemplate.New("").Option("missingkey=error").Funcs(templateFuncs())
+	textShared := texttemplate.New("").Option("missingkey=error")
+	variants := make(map[string]map[string]map[string]string) // name, locale, extension
+	catalogs := make(map[string]map[string]string)            // locale, key
+	if err = fs.WalkDir(fsys, ".", func(filePath string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil || entry.IsDir() {
+			return walkErr
+		}
+
+		// Only html and text files (and the message catalogs)
+		ext := path.Ext(filePath)
+		if ext != extHTML && ext != extText && !(ext == extJSON && strings.HasPrefix(filePath, localesDir)) {
+			return nil
+		}
+		content, readErr := fs.ReadFile(fsys, filePath)
+		if readErr != nil {
+			return readErr
+		}
+		name := strings.TrimSuffix(filePath, ext)
+
+		// Message catalog
+		if ext == extJSON {
+			return loadCatalog(catalogs, filePath, content)
+		}
+
+		// Shared by every template
+		if strings.HasPrefix(filePath, layoutsDir) || strings.HasPrefix(filePath, partialsDir) {
+			var parseErr error
+			if ext == extHTML {
+				_, parseErr = htmlShared.New(name).Parse(string(content))
+			} else {
+				_, parseErr = textShared.New(name).Parse(string(content))
+			}
+			if parseErr != nil {
+				return fmt.Errorf("error parsing email %s: %w", filePath, parseErr)
+			}
+			return nil
+		}
+
+		// Template variant (IE: persons/example_email.es.html is the es locale)
+		name, locale := splitLocale(name)
+		if variants[name] == nil {
+			variants[name] = make(map[string]map[string]string)
+		}
+		if variants[name][locale] == nil {
+			variants[name][locale] = make(map[string]string)
+		}
+		variants[name][locale][ext] = string(content)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("error loading email templates: %w", err)
+	}
+
+	// Parse each template with its own copy of the layouts and partials
+	loaded := make(map[string]localizedTemplates, len(variants))
+	for name, locales := range variants {
+		loaded[name] = make(localizedTemplates, len(locales))
+		for locale, files := range locales {
+			if loaded[name][locale], err = parseTemplate(localizedName(name, locale), files, htmlShared, textShared); err != nil {
+				return
+			}
+		}
+	}
+
+	// Translated subjects from the message catalogs
+	if err = applyCatalogs(loaded, catalogs); err != nil {
+		return
+	}
+
+	// Swap in the new templates
+	templatesMutex.Lock()
+	templates = loaded
+	templatesMutex.Unlock()
+
+	return validateTemplates(loaded)
+}
+
+// parseTemplate parses the html and text variants (rendered inside the default layout if they define a content block)
+func parseTemplate(name string, files map[string]string, htmlShared *htmltemplate.Template,
+	textShared *texttemplate.Template) (loaded *emailTemplate, err error) {
+
+	loaded = &emailTemplate{htmlRoot: name, textRoot: name}
+
+	// HTML variant
+	if content, ok := files[extHTML]; ok {
+		if loaded.html, err = htmlShared.Clone(); err != nil {
+			return
+		}
+		if _, err = loaded.html.New(name).Parse(content); err != nil {
+			return nil, fmt.Errorf("error parsing email %s%s: %w", name, extHTML, err)
+		}
+		if loaded.html.Lookup(contentBlock) != nil {
+			if loaded.html.Lookup(layoutDefault) == nil {
+				return nil, fmt.Errorf("email %s%s defines a %s block but %s%s is missing", name, extHTML, contentBlock, layoutDefault, extHTML)
+			}
+			loaded.htmlRoot = layoutDefault
+		}
+	}
+
+	// Text variant
+	if content, ok := files[extText]; ok {
+		if loaded.text, err = textShared.Clone(); err != nil {
+			return
+		}
+		if _, err = loaded.text.New(name).Parse(content); err != nil {
+			return nil, fmt.Errorf("error parsing email %s%s: %w", name, extText, err)
+		}
+		if loaded.text.Lookup(contentBlock) != nil {
+			if loaded.text.Lookup(layoutDefault) == nil {
+				return nil, fmt.Errorf("email %s%s defines a %s block but %s%s is missing", name, extText, contentBlock, layoutDefault, extText)
+			}
+			loaded.textRoot = layoutDefault
+		}
+	}
+
+	return
+}
+
+// validateTemplates renders every defined template in every locale with an empty data struct (catches unknown fields and missing files)
+func validateTemplates(loaded map[string]localizedTemplates) error {
+	definitionMutex.RLock()
+	defer definitionMutex.RUnlock()
+
+	var errs []error
+	for name, definition := range definitions {
+		localized, ok := loaded[name]
+		if !ok || localized[""] == nil {
+			errs = append(errs, fmt.Errorf("%w: %s", ErrTemplateNotFound, name))
+			continue
+		}
+		for locale := range localized {
+			if _, err := localized.render(definition, locale, reflect.New(definition.dataType).Interface()); err != nil {
+				errs = append(errs, fmt.Errorf("email template %s does not match %s: %w", localizedName(name, locale), definition.dataType, err))
+			}
+		}
+	}
+	for name := range loaded {
+		if _, ok := definitions[name]; !ok {
+			logger.Data(2, logger.WARN, "email template is not defined and can't be rendered: "+name)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// templateFuncs are the functions available in html templates
+func templateFuncs() htmltemplate.FuncMap {
+	return htmltemplate.FuncMap{
+		// styles returns the default email styles (static/css/email.css)
+		"styles": func() htmltemplate.CSS {
+			if Service == nil || Service.EmailService == nil {
+				return ""
+			}
+			return htmltemplate.CSS(Service.EmailService.EmailCSS) // #nosec G203 -- loaded from the static directory
+		},
+	}
+}
@@ -0,0 +1,381 @@
+package notifications
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec // Mandrill signs webhooks with HMAC-SHA1
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-api/database"
+	"github.com/mrz1836/go-api/request"
+	"github.com/mrz1836/go-logger"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/queries"
+)
+
+// Email event types (normalized from the provider webhooks)
+const (
+	EmailEventClicked    = "clicked"
+	EmailEventComplaint  = "complaint"
+	EmailEventDelivered  = "delivered"
+	EmailEventHardBounce = "hard_bounce"
+	EmailEventOpened     = "opened"
+	EmailEventSoftBounce = "soft_bounce"
+)
+
+// mandrillEventsParam is the form field with the Mandrill events (JSON array)
+const mandrillEventsParam = "mandrill_events"
+
+var (
+	// ErrWebhookUnauthorized is when the webhook signature (or credentials) is missing, not configured or not valid
+	ErrWebhookUnauthorized = errors.New("webhook signature is not valid")
+
+	// ErrWebhookPayload is when the webhook payload can not be decoded
+	ErrWebhookPayload = errors.New("webhook payload is not valid")
+)
+
+// EmailEvent is a delivery event from a provider webhook (email_events table)
+type EmailEvent struct {
+	CreatedAt         time.Time       `boil:"created_at" json:"created_at"`
+	Description       string          `boil:"description" json:"description,omitempty"`
+	Email             string          `boil:"email" json:"email"`
+	EventType         string          `boil:"event_type" json:"event_type"`
+	ID                uint64          `boil:"id" json:"id"`
+	OccurredAt        time.Time       `boil:"occurred_at" json:"occurred_at"`
+	OutboxID          null.Uint64     `boil:"outbox_id" json:"outbox_id,omitempty"`
+	Payload           json.RawMessage `boil:"payload" json:"payload"`
+	PersonID          null.Uint64     `boil:"person_id" json:"person_id,omitempty"`
+	Provider          string          `boil:"provider" json:"provider"`
+	ProviderEventID   string          `boil:"provider_event_id" json:"provider_event_id"`
+	ProviderMessageID string          `boil:"provider_message_id" json:"provider_message_id,omitempty"`
+}
+
+// webhookEvent is a parsed event and the tags sent with the email (used to find the outbox email)
+type webhookEvent struct {
+	event *EmailEvent
+	tags  []string
+}
+
+// postmarkPayload is a Postmark webhook (one record per request)
+type postmarkPayload struct {
+	BouncedAt   string      `json:"BouncedAt"`
+	DeliveredAt string      `json:"DeliveredAt"`
+	Description string      `json:"Description"`
+	Details     string      `json:"Details"`
+	Email       string      `json:"Email"`
+	ID          json.Number `json:"ID"`
+	MessageID   string      `json:"MessageID"`
+	ReceivedAt  string      `json:"ReceivedAt"`
+	Recipient   string      `json:"Recipient"`
+	RecordType  string      `json:"RecordType"`
+	Tag         string      `json:"Tag"`
+	Type        string      `json:"Type"`
+}
+
+// mandrillPayload is a Mandrill webhook event (sync events without a message are ignored)
+type mandrillPayload struct {
+	Event string `json:"event"`
+	Msg   *struct {
+		BounceDescription string   `json:"bounce_description"`
+		Diag              string   `json:"diag"`
+		Email             string   `json:"email"`
+		ID                string   `json:"_id"`
+		Tags              []string `json:"tags"`
+	} `json:"msg"`
+	TS int64 `json:"ts"`
+}
+
+// VerifyPostmarkAuth returns true if the basic auth matches the config (Postmark does not sign webhooks)
+func VerifyPostmarkAuth(user, password string) bool {
+	conf := config.Values.Email.Webhooks
+	if len(conf.PostmarkUser) == 0 || len(conf.PostmarkPassword) == 0 {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(user), []byte(conf.PostmarkUser)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(password), []byte(conf.PostmarkPassword)) == 1
+}
+
+// VerifyMandrillSignature returns true if X-Mandrill-Signature matches (HMAC-SHA1 of the webhook url and the sorted form fields)
+func VerifyMandrillSignature(signature string, form url.Values) bool {
+	conf := config.Values.Email.Webhooks
+	if len(conf.MandrillKey) == 0 || len(signature) == 0 {
+		return false
+	}
+
+	// The url, then each key and value (sorted by key)
+	signed := conf.MandrillURL
+	keys := make([]string, 0, len(form))
+	for key := range form {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range form[key] {
+			signed += key + value
+		}
+	}
+
+	mac := hmac.New(sha1.New, []byte(conf.MandrillKey))
+	_, _ = mac.Write([]byte(signed))
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(signature), []byte(expected))
+}
+
+// RecordPostmarkEvent records a Postmark webhook (bounce, spam complaint, delivery, open or click)
+func RecordPostmarkEvent(ctx context.Context, body []byte) (int, error) {
+	payload := new(postmarkPayload)
+	if err := json.Unmarshal(body, payload); err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrWebhookPayload, err.Error())
+	}
+
+	// Event type and time
+	var eventType, occurredAt string
+	address, description := payload.Recipient, payload.Description
+	switch payload.RecordType {
+	case "Bounce":
+		eventType, occurredAt, address = EmailEventSoftBounce, payload.BouncedAt, payload.Email
+		if payload.Type == "HardBounce" || payload.Type == "BadEmailAddress" {
+			eventType = EmailEventHardBounce
+		} else if payload.Type == "SpamComplaint" {
+			eventType = EmailEventComplaint
+		}
+	case "SpamComplaint":
+		eventType, occurredAt, address = EmailEventComplaint, payload.BouncedAt, payload.Email
+	case "Delivery":
+		eventType, occurredAt, description = EmailEventDelivered, payload.DeliveredAt, payload.Details
+	case "Open":
+		eventType, occurredAt = EmailEventOpened, payload.ReceivedAt
+	case "Click":
+		eventType, occurredAt = EmailEventClicked, payload.ReceivedAt
+	default:
+		return 0, nil
+	}
+
+	// Bounces have an ID, the other events are unique per message, type and time
+	eventID := payload.ID.String()
+	if len(eventID) == 0 {
+		eventID = payload.MessageID + ":" + eventType + ":" + occurredAt
+	}
+
+	return recordWebhookEvents(ctx, []*webhookEvent{{
+		event: &EmailEvent{
+			Description:       description,
+			Email:             address,
+			EventType:         eventType,
+			OccurredAt:        parseEventTime(occurredAt),
+			Payload:           body,
+			Provider:          config.EmailProviderPostmark,
+			ProviderEventID:   config.EmailProviderPostmark + ":" + eventID,
+			ProviderMessageID: payload.MessageID,
+		},
+		tags: []string{payload.Tag},
+	}})
+}
+
+// RecordMandrillEvents records the Mandrill webhook events (the mandrill_events form field)
+func RecordMandrillEvents(ctx context.Context, form url.Values) (int, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal([]byte(form.Get(mandrillEventsParam)), &raw); err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrWebhookPayload, err.Error())
+	}
+
+	events := make([]*webhookEvent, 0, len(raw))
+	for _, body := range raw {
+		payload := new(mandrillPayload)
+		if err := json.Unmarshal(body, payload); err != nil {
+			return 0, fmt.Errorf("%w: %s", ErrWebhookPayload, err.Error())
+		} else if payload.Msg == nil {
+			continue
+		}
+
+		// Event type
+		var eventType string
+		switch payload.Event {
+		case "hard_bounce":
+			eventType = EmailEventHardBounce
+		case "soft_bounce", "deferral":
+			eventType = EmailEventSoftBounce
+		case "spam":
+			eventType = EmailEventComplaint
+		case "delivered":
+			eventType = EmailEventDelivered
+		case "open":
+			eventType = EmailEventOpened
+		case "click":
+			eventType = EmailEventClicked
+		default:
+			continue
+		}
+
+		description := payload.Msg.BounceDescription
+		if len(payload.Msg.Diag) > 0 {
+			description = strings.TrimSpace(description + " " + payload.Msg.Diag)
+		}
+		events = append(events, &webhookEvent{
+			event: &EmailEvent{
+				Description:       description,
+				Email:             payload.Msg.Email,
+				EventType:         eventType,
+				OccurredAt:        time.Unix(payload.TS, 0).UTC(),
+				Payload:           body,
+				Provider:          config.EmailProviderMandrill,
+				ProviderEventID:   config.EmailProviderMandrill + ":" + payload.Msg.ID + ":" + payload.Event + ":" + strconv.FormatInt(payload.TS, 10),
+				ProviderMessageID: payload.Msg.ID,
+			},
+			tags: payload.Msg.Tags,
+		})
+	}
+
+	return recordWebhookEvents(ctx, events)
+}
+
+// recordWebhookEvents stores the events (retried webhooks are ignored) and suppresses hard bounces and complaints
+func recordWebhookEvents(ctx context.Context, events []*webhookEvent) (recorded int, err error) {
+	for _, webhook := range events {
+		event := webhook.event
+		event.Email = normalizeAddress(event.Email)
+		if len(event.Email) == 0 {
+			continue
+		}
+
+		// Find the outbox email and the person
+		if err = matchEmailEvent(ctx, event, webhook.tags); err != nil {
+			return
+		}
+
+		// Store the event (once)
+		var result sql.Result
+		if result, err = database.WriteDatabase.ExecContext(ctx,
+			"INSERT IGNORE INTO `email_events` (`outbox_id`, `person_id`, `provider`, `provider_event_id`, `provider_message_id`, `event_type`, `email`, `description`, `payload`, `occurred_at`) "+
+				"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			event.OutboxID, event.PersonID, event.Provider, event.ProviderEventID, event.ProviderMessageID,
+			event.EventType, event.Email, event.Description, []byte(event.Payload), event.OccurredAt,
+		); err != nil {
+			return
+		}
+		var id int64
+		if id, err = result.LastInsertId(); err != nil {
+			return
+		} else if rows, _ := result.RowsAffected(); rows == 0 {
+			continue
+		}
+		recorded++
+
+		// Never send to hard bounces or complaints again
+		if event.EventType == EmailEventHardBounce || event.EventType == EmailEventComplaint {
+			if err = SuppressEmail(ctx, event.Email, event.EventType, event.Provider, uint64(id)); err != nil {
+				return
+			}
+			logger.Data(2, logger.WARN, "suppressed "+event.Email+" ("+event.EventType+" from "+event.Provider+")", request.LogParameters(ctx)...)
+		}
+	}
+	return
+}
+
+// matchEmailEvent sets the outbox email (outbox tag, then provider message ID) and the person (outbox, then email)
+func matchEmailEvent(ctx context.Context, event *EmailEvent, tags []string) (err error) {
+
+	// Outbox tag (IE: outbox-123)
+	var outbox *OutboxEmail
+	for _, tag := range tags {
+		if !strings.HasPrefix(tag, outboxTagPrefix) {
+			continue
+		}
+		id, parseErr := strconv.ParseUint(strings.TrimPrefix(tag, outboxTagPrefix), 10, 64)
+		if parseErr != nil {
+			continue
+		}
+		if outbox, err = GetOutboxEmail(ctx, id); errors.Is(err, ErrOutboxEmailNotFound) {
+			outbox, err = nil, nil
+		} else if err != nil {
+			return
+		}
+		break
+	}
+
+	// Provider message ID (set by an earlier event)
+	if outbox == nil && len(event.ProviderMessageID) > 0 {
+		var emails []*OutboxEmail
+		if err = queries.Raw(
+			"SELECT * FROM `email_outbox` WHERE `provider_message_id` = ? LIMIT 1", event.ProviderMessageID,
+		).Bind(ctx, database.ReadDatabase, &emails); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return
+		}
+		if len(emails) > 0 {
+			outbox = emails[0]
+		}
+	}
+
+	// Remember the provider message ID for the next events
+	if outbox != nil {
+		event.OutboxID = null.Uint64From(outbox.ID)
+		event.PersonID = outbox.PersonID
+		if len(outbox.ProviderMessageID) == 0 && len(event.ProviderMessageID) > 0 {
+			if _, err = database.WriteDatabase.ExecContext(ctx,
+				"UPDATE `email_outbox` SET `provider_message_id` = ? WHERE `id` = ? AND `provider_message_id` = ''",
+				event.ProviderMessageID, outbox.ID,
+			); err != nil {
+				return
+			}
+		}
+	}
+
+	// Person by email
+	if !event.PersonID.Valid {
+		var persons []*struct {
+			ID uint64 `boil:"id"`
+		}
+		if err = queries.Raw(
+			"SELECT `id` FROM `persons` WHERE `email` = ? AND `is_deleted` = 0 ORDER BY `id` LIMIT 1", event.Email,
+		).Bind(ctx, database.ReadDatabase, &persons); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return
+		}
+		if len(persons) > 0 {
+			event.PersonID = null.Uint64From(persons[0].ID)
+		}
+	}
+
+	return nil
+}
+
+// GetEmailEvents gets the latest email events (newest first, outbox, person and email are optional filters)
+func GetEmailEvents(ctx context.Context, outboxID, personID uint64, address string, limit int) (events []*EmailEvent, err error) {
+	query := "SELECT * FROM `email_events` WHERE 1 = 1"
+	var args []interface{}
+	if outboxID > 0 {
+		query += " AND `outbox_id` = ?"
+		args = append(args, outboxID)
+	}
+	if personID > 0 {
+		query += " AND `person_id` = ?"
+		args = append(args, personID)
+	}
+	if len(address) > 0 {
+		query += " AND `email` = ?"
+		args = append(args, normalizeAddress(address))
+	}
+	query += " ORDER BY `occurred_at` DESC, `id` DESC LIMIT ?"
+	args = append(args, limit)
+
+	err = queries.Raw(query, args...).Bind(ctx, database.ReadDatabase, &events)
+	return
+}
+
+// parseEventTime parses the provider time (the current time if missing or not valid)
+func parseEventTime(value string) time.Time {
+	if parsed, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return parsed.UTC()
+	}
+	return time.Now().UTC()
+}
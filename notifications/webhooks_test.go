@@ -0,0 +1,65 @@
+package notifications
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/mrz1836/go-api/config"
+)
+
+// TestVerifyMandrillSignature tests the X-Mandrill-Signature check
+func TestVerifyMandrillSignature(t *testing.T) {
+	config.Values.Email.Webhooks.MandrillKey = "test-webhook-key"
+	config.Values.Email.Webhooks.MandrillURL = "https://api.example.com/emails/webhooks/mandrill"
+	const signature = "KDcwgn7vQ6dKULrM4enE4QUjxUU="
+
+	tests := []struct {
+		name      string
+		form      url.Values
+		key       string
+		signature string
+		expected  bool
+	}{
+		{"known good", url.Values{"mandrill_events": {`[{"event":"hard_bounce"}]`}}, "test-webhook-key", signature, true},
+		{"tampered events", url.Values{"mandrill_events": {`[{"event":"soft_bounce"}]`}}, "test-webhook-key", signature, false},
+		{"extra field", url.Values{"mandrill_events": {`[{"event":"hard_bounce"}]`}, "extra": {"1"}}, "test-webhook-key", signature, false},
+		{"wrong key", url.Values{"mandrill_events": {`[{"event":"hard_bounce"}]`}}, "another-key", signature, false},
+		{"no key", url.Values{"mandrill_events": {`[{"event":"hard_bounce"}]`}}, "", signature, false},
+		{"no signature", url.Values{"mandrill_events": {`[{"event":"hard_bounce"}]`}}, "test-webhook-key", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config.Values.Email.Webhooks.MandrillKey = test.key
+			if valid := VerifyMandrillSignature(test.signature, test.form); valid != test.expected {
+				t.Fatalf("expected %t, got %t", test.expected, valid)
+			}
+		})
+	}
+}
+
+// TestVerifyPostmarkAuth tests the Postmark webhook basic auth check
+func TestVerifyPostmarkAuth(t *testing.T) {
+	tests := []struct {
+		name         string
+		confUser     string
+		confPassword string
+		user         string
+		password     string
+		expected     bool
+	}{
+		{"known good", "postmark", "secret123", "postmark", "secret123", true},
+		{"wrong password", "postmark", "secret123", "postmark", "secret124", false},
+		{"wrong user", "postmark", "secret123", "mandrill", "secret123", false},
+		{"not configured", "", "", "", "", false},
+		{"no password configured", "postmark", "", "postmark", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config.Values.Email.Webhooks.PostmarkUser = test.confUser
+			config.Values.Email.Webhooks.PostmarkPassword = test.confPassword
+			if valid := VerifyPostmarkAuth(test.user, test.password); valid != test.expected {
+				t.Fatalf("expected %t, got %t", test.expected, valid)
+			}
+		})
+	}
+}
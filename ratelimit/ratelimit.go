@@ -0,0 +1,168 @@
+/*
+Package ratelimit is a token bucket rate limiter (redis for all instances, or the local memory store)
+*/
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/OrlovEvgeny/go-mcache"
+	"github.com/gomodule/redigo/redis"
+	"github.com/mrz1836/go-api/tracing"
+	"github.com/mrz1836/go-cache"
+)
+
+// keyPrefix is used for all rate limit keys
+const keyPrefix = "ratelimit:"
+
+// Rule is the limit of requests per period (IE: 10 requests per 60 seconds)
+type Rule struct {
+	Limit  int `json:"limit" mapstructure:"limit"`   // 10
+	Period int `json:"period" mapstructure:"period"` // 60 (seconds)
+}
+
+// Enabled returns true if the rule has a limit and period
+func (r Rule) Enabled() bool {
+	return r.Limit > 0 && r.Period > 0
+}
+
+// ratePerMs is the amount of tokens added to the bucket per millisecond
+func (r Rule) ratePerMs() float64 {
+	return float64(r.Limit) / float64(r.Period*1000)
+}
+
+// Result is the outcome of taking a token from a bucket
+type Result struct {
+	Allowed    bool          // Request is allowed
+	Limit      int           // Size of the bucket
+	Remaining  int           // Tokens left in the bucket
+	Reset      time.Duration // Time until the bucket is full
+	RetryAfter time.Duration // Time until a token is available (if not allowed)
+}
+
+// Store takes a token from the bucket for the key
+type Store interface {
+	Take(ctx context.Context, key string, rule Rule) (*Result, error)
+}
+
+// tokenBucketScript refills and takes a token atomically (works across all instances)
+//
+// The time comes from redis (TIME) so clock skew between the instances does not change the refill
+var tokenBucketScript = redis.NewScript(1, `
+if redis.replicate_commands then
+	redis.replicate_commands()
+end
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1]) or capacity
+local ts = tonumber(bucket[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / rate)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(capacity / rate))
+return {allowed, math.floor(tokens), retry, math.ceil((capacity - tokens) / rate)}
+`)
+
+// RedisStore uses the redis client (limits hold across all instances)
+type RedisStore struct {
+	Client *cache.Client
+}
+
+// Take takes a token from the bucket using the atomic script
+func (s *RedisStore) Take(ctx context.Context, key string, rule Rule) (result *Result, err error) {
+
+	// Start the span
+	ctx, span := tracing.StartSpan(ctx, "redis ratelimit")
+	defer func() {
+		tracing.EndSpan(span, err)
+	}()
+
+	// Get a connection
+	var conn redis.Conn
+	if conn, err = s.Client.GetConnectionWithContext(ctx); err != nil {
+		return
+	}
+	defer s.Client.CloseConnection(conn)
+
+	// Run the script
+	var values []int64
+	if values, err = redis.Int64s(tokenBucketScript.DoContext(
+		ctx, conn, keyPrefix+key, rule.Limit, rule.ratePerMs(),
+	)); err != nil {
+		return
+	}
+
+	result = &Result{
+		Allowed:    values[0] == 1,
+		Limit:      rule.Limit,
+		Remaining:  int(values[1]),
+		RetryAfter: time.Duration(values[2]) * time.Millisecond,
+		Reset:      time.Duration(values[3]) * time.Millisecond,
+	}
+	return
+}
+
+// bucket is a token bucket stored in memory
+type bucket struct {
+	timestamp time.Time
+	tokens    float64
+}
+
+// MemoryStore uses the local memory store (limits are per instance)
+type MemoryStore struct {
+	MemStore *mcache.CacheDriver
+	mutex    sync.Mutex
+}
+
+// Take takes a token from the bucket (refilled based on the time since the last request)
+func (s *MemoryStore) Take(_ context.Context, key string, rule Rule) (*Result, error) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Get the existing bucket (or start a full one)
+	now := time.Now()
+	rate := rule.ratePerMs()
+	b := &bucket{timestamp: now, tokens: float64(rule.Limit)}
+	if value, ok := s.MemStore.Get(keyPrefix + key); ok {
+		if existing, isBucket := value.(*bucket); isBucket {
+			b = existing
+		}
+	}
+
+	// Refill the bucket
+	elapsed := float64(now.Sub(b.timestamp).Milliseconds())
+	b.tokens = math.Min(float64(rule.Limit), b.tokens+math.Max(0, elapsed)*rate)
+	b.timestamp = now
+
+	// Take a token
+	result := &Result{Limit: rule.Limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration(math.Ceil((1-b.tokens)/rate)) * time.Millisecond
+	}
+	result.Remaining = int(math.Floor(b.tokens))
+	result.Reset = time.Duration(math.Ceil((float64(rule.Limit)-b.tokens)/rate)) * time.Millisecond
+
+	// Store the bucket until it would be full again
+	if err := s.MemStore.Set(keyPrefix+key, b, time.Duration(rule.Period)*time.Second); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
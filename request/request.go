@@ -0,0 +1,105 @@
+/*
+Package request stores and retrieves request scoped values on the context (route, request ID, principal, etc)
+*/
+package request
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/mrz1836/go-api/tracing"
+	"github.com/mrz1836/go-logger"
+)
+
+// contextKey is used for storing values on the context
+type contextKey string
+
+// Context keys
+const (
+	ipAddressKey contextKey = "ip_address"
+	principalKey contextKey = "principal"
+	requestIDKey contextKey = "request_id"
+	routeKey     contextKey = "route"
+)
+
+// Request constants
+const (
+	HeaderRequestID    = "X-Request-ID"
+	MaxRequestIDLength = 128
+	RouteUnmatched     = "unmatched"
+)
+
+// WithRoute returns a new context with the route template (IE: /persons/:id)
+func WithRoute(ctx context.Context, route string) context.Context {
+	return context.WithValue(ctx, routeKey, route)
+}
+
+// Route returns the route template from the context (if found)
+func Route(ctx context.Context) string {
+	if route, ok := ctx.Value(routeKey).(string); ok {
+		return route
+	}
+	return RouteUnmatched
+}
+
+// WithID returns a new context with the request ID
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// ID returns the request ID from the context (empty if not found)
+func ID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// NewID generates a new random request ID (32 hex characters)
+func NewID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ValidID checks an incoming request ID (length and safe characters only)
+func ValidID(id string) bool {
+	if len(id) == 0 || len(id) > MaxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '-' && c != '_' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+// WithPrincipal returns a new context with the authenticated principal (IE: basic auth user)
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey, principal)
+}
+
+// Principal returns the authenticated principal from the context (empty if not found)
+func Principal(ctx context.Context) string {
+	principal, _ := ctx.Value(principalKey).(string)
+	return principal
+}
+
+// WithIPAddress returns a new context with the client IP address
+func WithIPAddress(ctx context.Context, ipAddress string) context.Context {
+	return context.WithValue(ctx, ipAddressKey, ipAddress)
+}
+
+// IPAddress returns the client IP address from the context (empty if not found)
+func IPAddress(ctx context.Context) string {
+	ipAddress, _ := ctx.Value(ipAddressKey).(string)
+	return ipAddress
+}
+
+// LogParameters returns the request ID and trace ID as log parameters (use with logger.Data)
+func LogParameters(ctx context.Context) (params []logger.KeyValue) {
+	if id := ID(ctx); len(id) > 0 {
+		params = append(params, logger.MakeParameter(string(requestIDKey), id))
+	}
+	return append(params, tracing.LogParameters(ctx)...)
+}
@@ -0,0 +1,85 @@
+/*
+Package responsecache stores complete http responses for GET routes (opt-in per route) with tag based purging
+*/
+package responsecache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mrz1836/go-api/caching"
+	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-api/request"
+)
+
+// Response cache constants
+const (
+	keyPrefix = "response:"
+	TagAll    = "responses" // Every cached response has this tag (used for flushing)
+)
+
+// Rule is how a route is cached
+type Rule struct {
+	Tags            []string      `json:"tags" mapstructure:"tags"`                           // persons (purged when any person changes)
+	TTL             time.Duration `json:"ttl" mapstructure:"ttl"`                             // 1m
+	VaryByPrincipal bool          `json:"vary_by_principal" mapstructure:"vary_by_principal"` // true (separate entries per authenticated user, otherwise only anonymous requests are cached)
+}
+
+// Response is a cached http response
+type Response struct {
+	Body     []byte      `json:"body"`
+	Header   http.Header `json:"header"`
+	Status   int         `json:"status"`
+	StoredAt time.Time   `json:"stored_at"`
+}
+
+// RuleFor returns the rule for the method and route template (false if the route is not cached)
+func RuleFor(method, route string) (Rule, bool) {
+	if !config.Values.ResponseCache.Enabled || method != http.MethodGet {
+		return Rule{}, false
+	}
+	conf, found := config.Values.ResponseCache.Routes[strings.ToLower(method+" "+route)]
+	return Rule(conf), found
+}
+
+// Key returns the cache key for the request (method, path, query, origin and principal if varied)
+func Key(req *http.Request, route string, rule Rule) string {
+
+	// Sorted query string (the same query in any order is the same entry)
+	parts := []string{req.Method, req.URL.Path, req.URL.Query().Encode(), req.Header.Get("Origin")}
+	if rule.VaryByPrincipal {
+		parts = append(parts, request.Principal(req.Context()))
+	}
+
+	hash := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return keyPrefix + req.Method + ":" + route + ":" + hex.EncodeToString(hash[:])
+}
+
+// Get returns the cached response (found is false if not cached)
+func Get(ctx context.Context, key string) (*Response, bool, error) {
+	return caching.Lookup[Response](ctx, key)
+}
+
+// Set caches the response with the tags from the rule
+func Set(ctx context.Context, key string, response *Response, rule Rule) error {
+	localTTL := config.Values.ResponseCache.LocalTTL
+	if rule.TTL < localTTL {
+		localTTL = rule.TTL
+	}
+	tags := append([]string{TagAll}, rule.Tags...)
+	return caching.Store(ctx, key, response, caching.Options{LocalTTL: localTTL, TTL: rule.TTL}, tags...)
+}
+
+// Purge removes all cached responses with any of the tags (on all instances)
+func Purge(ctx context.Context, tags ...string) error {
+	return caching.Invalidate(ctx, tags...)
+}
+
+// Flush removes all cached responses (on all instances)
+func Flush(ctx context.Context) error {
+	return Purge(ctx, TagAll)
+}
@@ -0,0 +1,138 @@
+package router
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-api/request"
+	"github.com/mrz1836/go-api/tracing"
+	"github.com/mrz1836/go-logger"
+)
+
+// redactedValue replaces the value of any sensitive query param
+const redactedValue = "REDACTED"
+
+// accessLogEntry is a structured (json) access log line
+type accessLogEntry struct {
+	Bytes     int     `json:"bytes"`
+	ClientIP  string  `json:"client_ip"`
+	LatencyMs float64 `json:"latency_ms"`
+	Method    string  `json:"method"`
+	Path      string  `json:"path"`
+	Principal string  `json:"principal,omitempty"`
+	Query     string  `json:"query,omitempty"`
+	RequestID string  `json:"request_id"`
+	Route     string  `json:"route"`
+	Status    int     `json:"status"`
+	Time      string  `json:"time"`
+	TraceID   string  `json:"trace_id,omitempty"`
+	Type      string  `json:"type"`
+	UserAgent string  `json:"user_agent,omitempty"`
+}
+
+// requestIDMiddleware generates (or propagates) the X-Request-ID and stores the request values on the context
+func requestIDMiddleware(proxies []*net.IPNet) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+
+			// Use the incoming ID if valid, otherwise generate a new one
+			id := req.Header.Get(request.HeaderRequestID)
+			if !request.ValidID(id) {
+				id = request.NewID()
+			}
+			w.Header().Set(request.HeaderRequestID, id)
+
+			// Store the request values (models and jobs can use these)
+			ctx := request.WithID(req.Context(), id)
+			ctx = request.WithIPAddress(ctx, clientIPAddress(req, proxies))
+			if user, password, ok := req.BasicAuth(); ok && validBasicAuth(user, password) {
+				ctx = request.WithPrincipal(ctx, user)
+			}
+
+			next.ServeHTTP(w, req.WithContext(ctx))
+		})
+	}
+}
+
+// validBasicAuth checks the credentials against the configured basic auth user
+func validBasicAuth(user, password string) bool {
+	return subtle.ConstantTimeCompare([]byte(user), []byte(config.Values.BasicAuth.User)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(password), []byte(config.Values.BasicAuth.Password)) == 1
+}
+
+// accessLogMiddleware writes a structured (json) access log for every sampled request
+func accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+
+		// Serve the request
+		start := time.Now()
+		writer := newResponseWriter(w)
+		next.ServeHTTP(writer, req)
+
+		// Always log server errors, otherwise use the sample rate
+		if !config.Values.AccessLog.Enabled ||
+			(writer.status < http.StatusInternalServerError && !sampled(config.Values.AccessLog.SampleRate)) {
+			return
+		}
+
+		ctx := req.Context()
+		entry := &accessLogEntry{
+			Bytes:     writer.bytes,
+			ClientIP:  request.IPAddress(ctx),
+			LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			Method:    req.Method,
+			Path:      req.URL.Path,
+			Principal: request.Principal(ctx),
+			Query:     redactQuery(req.URL.Query(), config.Values.AccessLog.RedactParams),
+			RequestID: request.ID(ctx),
+			Route:     request.Route(ctx),
+			Status:    writer.status,
+			Time:      start.UTC().Format(time.RFC3339Nano),
+			TraceID:   tracing.TraceID(ctx),
+			Type:      "access",
+			UserAgent: req.UserAgent(),
+		}
+
+		b, err := json.Marshal(entry)
+		if err != nil {
+			logger.Data(2, logger.ERROR, "error encoding access log: "+err.Error(), request.LogParameters(ctx)...)
+			return
+		}
+		logger.NoFilePrintln(string(b))
+	})
+}
+
+// sampled returns true if the request should be logged (1 = all, 0 = none)
+func sampled(rate float64) bool {
+	if rate >= 1 {
+		return true
+	} else if rate <= 0 {
+		return false
+	}
+	return rand.Float64() < rate //nolint:gosec // sampling does not need a secure random
+}
+
+// redactQuery encodes the query with the values of any sensitive param replaced
+func redactQuery(values url.Values, sensitive []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	for key := range values {
+		for _, param := range sensitive {
+			if strings.EqualFold(key, param) {
+				for i := range values[key] {
+					values[key][i] = redactedValue
+				}
+				break
+			}
+		}
+	}
+	return values.Encode()
+}
@@ -0,0 +1,72 @@
+package router
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/mrz1836/go-logger"
+)
+
+// Forwarded ip headers (set by proxies, but a client can send them as well)
+const (
+	headerForwardedFor = "X-Forwarded-For"
+	headerRealIP       = "X-Real-IP"
+)
+
+// parseProxies returns the trusted proxy ranges (config trusted_proxies, invalid ranges fail the config validation)
+func parseProxies(cidrs []string) (proxies []*net.IPNet) {
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			logger.Data(2, logger.ERROR, "invalid trusted proxy: "+cidr)
+			continue
+		}
+		proxies = append(proxies, network)
+	}
+	return
+}
+
+// clientIPAddress returns the address of the connection, or the forwarded address if the connection is a trusted proxy
+//
+// X-Forwarded-For is read from the right, skipping the trusted proxies (the client can prepend any value)
+func clientIPAddress(req *http.Request, proxies []*net.IPNet) string {
+	remote, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		remote = req.RemoteAddr
+	}
+	if !trustedProxy(remote, proxies) {
+		return remote
+	}
+
+	// Behind a trusted proxy
+	if forwarded := req.Header.Get(headerForwardedFor); len(forwarded) > 0 {
+		addresses := strings.Split(forwarded, ",")
+		for i := len(addresses) - 1; i >= 0; i-- {
+			address := strings.TrimSpace(addresses[i])
+			if net.ParseIP(address) == nil {
+				break
+			}
+			if !trustedProxy(address, proxies) || i == 0 {
+				return address
+			}
+		}
+	} else if realIP := strings.TrimSpace(req.Header.Get(headerRealIP)); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	return remote
+}
+
+// trustedProxy returns true if the address is in one of the trusted proxy ranges
+func trustedProxy(address string, proxies []*net.IPNet) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range proxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
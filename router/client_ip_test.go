@@ -0,0 +1,45 @@
+package router
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+// TestClientIPAddress tests the forwarded headers are only used from a trusted proxy
+func TestClientIPAddress(t *testing.T) {
+	proxies := parseProxies([]string{"10.0.0.0/8"})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		realIP     string
+		expected   string
+	}{
+		{"direct", "203.0.113.10:5000", "", "", "203.0.113.10"},
+		{"direct ignores forwarded", "203.0.113.10:5000", "198.51.100.1", "", "203.0.113.10"},
+		{"direct ignores real ip", "203.0.113.10:5000", "", "198.51.100.1", "203.0.113.10"},
+		{"trusted proxy", "10.0.0.2:5000", "198.51.100.1", "", "198.51.100.1"},
+		{"trusted proxy real ip", "10.0.0.2:5000", "", "198.51.100.1", "198.51.100.1"},
+		{"spoofed client value", "10.0.0.2:5000", "1.2.3.4, 198.51.100.1", "", "198.51.100.1"},
+		{"proxy chain", "10.0.0.2:5000", "198.51.100.1, 10.0.0.3", "", "198.51.100.1"},
+		{"only proxies", "10.0.0.2:5000", "10.0.0.4, 10.0.0.3", "", "10.0.0.4"},
+		{"invalid forwarded", "10.0.0.2:5000", "not-an-ip", "", "10.0.0.2"},
+		{"no port", "203.0.113.10", "", "", "203.0.113.10"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = test.remoteAddr
+			if len(test.forwarded) > 0 {
+				req.Header.Set(headerForwardedFor, test.forwarded)
+			}
+			if len(test.realIP) > 0 {
+				req.Header.Set(headerRealIP, test.realIP)
+			}
+			if ip := clientIPAddress(req, proxies); ip != test.expected {
+				t.Fatalf("expected %s, got %s", test.expected, ip)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package router
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mrz1836/go-api/metrics"
+	"github.com/mrz1836/go-api/request"
+	"github.com/mrz1836/go-api/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// middleware is a standard http middleware (wraps the next handler)
+type middleware func(next http.Handler) http.Handler
+
+// chain wraps the handler with the middleware (the first middleware is the outermost)
+func chain(handler http.Handler, middlewares ...middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// routeMiddleware stores the matched route template on the request context (IE: /persons/:id)
+func routeMiddleware(r *httprouter.Router) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			next.ServeHTTP(w, req.WithContext(request.WithRoute(req.Context(), routeTemplate(r, req))))
+		})
+	}
+}
+
+// routeTemplate finds the registered route for the request (IE: /persons/:id, unmatched requests share one label)
+//
+// httprouter (v1.3.0) does not return the matched path, so the template is rebuilt from the params
+// and then confirmed by looking it up: the registered route matches its own template with every param
+// set to its own name (IE: id=":id"), any other placement of the params does not
+func routeTemplate(r *httprouter.Router, req *http.Request) string {
+
+	// Lookup the handle for this path
+	handle, params, _ := r.Lookup(req.Method, req.URL.Path)
+	if handle == nil {
+		return request.RouteUnmatched
+	}
+
+	// No params, the path is the template
+	if len(params) == 0 {
+		return req.URL.Path
+	}
+
+	// A catch-all is always last and its value is the rest of the path (IE: /files/*filepath)
+	path, catchAll := req.URL.Path, ""
+	if last := params[len(params)-1]; strings.HasPrefix(last.Value, "/") && strings.HasSuffix(path, last.Value) {
+		path, catchAll = strings.TrimSuffix(path, last.Value), "/*"+last.Key
+		params = params[:len(params)-1]
+	}
+
+	// Try each placement of the params (in order) until the router confirms the template
+	if template, ok := placeParams(r, req.Method, strings.Split(path, "/"), 0, params, catchAll); ok {
+		return template
+	}
+	return request.RouteUnmatched
+}
+
+// placeParams replaces the param values in the segments (in order, from the segment index) and returns the template the router confirms
+func placeParams(r *httprouter.Router, method string, segments []string, from int, params httprouter.Params, catchAll string) (string, bool) {
+
+	// Every param is placed, confirm the template
+	if len(params) == 0 {
+		template := strings.Join(segments, "/") + catchAll
+		return template, isTemplate(r, method, template)
+	}
+
+	// A param is always the end of a segment (after any literal prefix)
+	param := params[0]
+	for i := from; i < len(segments); i++ {
+		segment := segments[i]
+		if len(param.Value) == 0 || !strings.HasSuffix(segment, param.Value) {
+			continue
+		}
+		placed := append([]string{}, segments...)
+		placed[i] = strings.TrimSuffix(segment, param.Value) + ":" + param.Key
+		if template, ok := placeParams(r, method, placed, i+1, params[1:], catchAll); ok {
+			return template, true
+		}
+	}
+	return "", false
+}
+
+// isTemplate returns true if the template matches a route with every param set to its own name
+func isTemplate(r *httprouter.Router, method, template string) bool {
+	handle, params, _ := r.Lookup(method, template)
+	if handle == nil {
+		return false
+	}
+	for _, param := range params {
+		if param.Value != ":"+param.Key && param.Value != "/*"+param.Key {
+			return false
+		}
+	}
+	return true
+}
+
+// metricsMiddleware records the duration of every request by method, route and status
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		writer := newResponseWriter(w)
+		next.ServeHTTP(writer, req)
+		metrics.ObserveHTTPRequest(req.Method, request.Route(req.Context()), writer.status, time.Since(start))
+	})
+}
+
+// tracingMiddleware starts a server span for every request (W3C traceparent is extracted and returned)
+func tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+
+		// Continue the trace from the caller (if any)
+		ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+
+		// Start the server span using the route template
+		route := request.Route(ctx)
+		ctx, span := tracing.Tracer().Start(ctx, req.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(req.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(req.URL.Path),
+				semconv.ClientAddress(request.IPAddress(ctx)),
+				attribute.String("http.request_id", request.ID(ctx)),
+			),
+		)
+		defer span.End()
+
+		// Return the trace context to the caller
+		otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(w.Header()))
+
+		// Serve the request
+		writer := newResponseWriter(w)
+		next.ServeHTTP(writer, req.WithContext(ctx))
+
+		// Record the status
+		span.SetAttributes(semconv.HTTPResponseStatusCode(writer.status))
+		if writer.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(writer.status))
+		}
+	})
+}
@@ -0,0 +1,164 @@
+package router
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	apirouter "github.com/mrz1836/go-api-router"
+	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-api/ratelimit"
+	"github.com/mrz1836/go-api/request"
+	"github.com/mrz1836/go-api/tracing"
+	"github.com/mrz1836/go-logger"
+)
+
+// Rate limit headers
+const (
+	headerRateLimitLimit     = "RateLimit-Limit"
+	headerRateLimitRemaining = "RateLimit-Remaining"
+	headerRateLimitReset     = "RateLimit-Reset"
+	headerRetryAfter         = "Retry-After"
+)
+
+// newRateLimitStore uses redis (if enabled) so limits hold across all instances, otherwise the local memory store
+func newRateLimitStore() ratelimit.Store {
+	if config.Values.CacheEnabled {
+		return &ratelimit.RedisStore{Client: config.Values.Cache.Client}
+	}
+	return &ratelimit.MemoryStore{MemStore: config.Values.Cache.MemStore}
+}
+
+// rateLimitMiddleware limits requests per ip, per api key and per route
+func rateLimitMiddleware(store ratelimit.Store) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+
+			// Not enabled or an internal route (load balancers, metrics)
+			ctx := req.Context()
+			route := request.Route(ctx)
+			if !config.Values.RateLimit.Enabled || skipRateLimit(route) {
+				next.ServeHTTP(w, req)
+				return
+			}
+
+			// Check the limits for this request in order (a denied request does not take from the remaining buckets)
+			var result *ratelimit.Result
+			for _, limit := range rateLimitRules(req, route) {
+				current, err := store.Take(ctx, limit.key, limit.rule)
+				if err != nil { // Fail open, don't block requests if the store is down
+					logger.Data(2, logger.ERROR, "rate limit error: "+err.Error(), request.LogParameters(ctx)...)
+					continue
+				}
+				if result = mostRestrictive(result, current); !result.Allowed {
+					break
+				}
+			}
+
+			// No limits applied
+			if result == nil {
+				next.ServeHTTP(w, req)
+				return
+			}
+
+			// Set the headers
+			w.Header().Set(headerRateLimitLimit, strconv.Itoa(result.Limit))
+			w.Header().Set(headerRateLimitRemaining, strconv.Itoa(result.Remaining))
+			w.Header().Set(headerRateLimitReset, strconv.Itoa(int(math.Ceil(result.Reset.Seconds()))))
+
+			// Limit exceeded
+			if !result.Allowed {
+				w.Header().Set(headerRetryAfter, strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+				apiError := apirouter.ErrorFromRequest(req, fmt.Sprintf("rate limit exceeded: %s %s", req.Method, route), "too many requests, please try again later", http.StatusTooManyRequests, http.StatusTooManyRequests, tracing.ErrorData(ctx))
+				apirouter.ReturnResponse(w, req, apiError.Code, apiError)
+				return
+			}
+
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// skipRateLimit returns true for routes that are never limited
+func skipRateLimit(route string) bool {
+	return route == request.RouteUnmatched ||
+		strings.HasPrefix(route, "/"+config.HealthRequestPath) ||
+		route == "/"+config.MetricsRequestPath
+}
+
+// rateLimit is a bucket key and the rule that applies to it
+type rateLimit struct {
+	key  string
+	rule ratelimit.Rule
+}
+
+// rateLimitRules returns all the keys and rules that apply to the request (ip, api key, then route)
+func rateLimitRules(req *http.Request, route string) (rules []rateLimit) {
+	conf := config.Values.RateLimit
+
+	// Per ip address (all routes)
+	ipAddress := request.IPAddress(req.Context())
+	identity := "ip:" + ipAddress
+	if rule := ratelimit.Rule(conf.IP); rule.Enabled() {
+		rules = append(rules, rateLimit{key: identity, rule: rule})
+	}
+
+	// Per api key (all routes) if the key is known, falls back to the authenticated principal (otherwise the ip address)
+	apiKey := req.Header.Get(conf.APIKeyHeader)
+	if !knownAPIKey(apiKey, conf.APIKeys) {
+		apiKey = request.Principal(req.Context())
+	}
+	if len(apiKey) > 0 {
+		hash := sha256.Sum256([]byte(apiKey))
+		identity = "key:" + hex.EncodeToString(hash[:8])
+		if rule := ratelimit.Rule(conf.APIKey); rule.Enabled() {
+			rules = append(rules, rateLimit{key: identity, rule: rule})
+		}
+	}
+
+	// Per route (by api key if found, otherwise ip address)
+	if rule, ok := conf.Routes[strings.ToLower(req.Method+" "+route)]; ok {
+		if r := ratelimit.Rule(rule); r.Enabled() {
+			rules = append(rules, rateLimit{key: "route:" + req.Method + ":" + route + ":" + identity, rule: r})
+		}
+	}
+
+	return rules
+}
+
+// knownAPIKey returns true if the key is one of the configured keys (unknown keys could be used to get a new bucket per request)
+func knownAPIKey(apiKey string, keys []string) bool {
+	if len(apiKey) == 0 {
+		return false
+	}
+	known := false
+	for _, key := range keys {
+		if subtle.ConstantTimeCompare([]byte(apiKey), []byte(key)) == 1 {
+			known = true
+		}
+	}
+	return known
+}
+
+// mostRestrictive returns the result to report (denied first, then the least remaining)
+func mostRestrictive(current, next *ratelimit.Result) *ratelimit.Result {
+	switch {
+	case current == nil:
+		return next
+	case current.Allowed != next.Allowed:
+		if !next.Allowed {
+			return next
+		}
+		return current
+	case !next.Allowed && next.RetryAfter > current.RetryAfter:
+		return next
+	case next.Allowed && next.Remaining < current.Remaining:
+		return next
+	}
+	return current
+}
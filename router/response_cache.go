@@ -0,0 +1,186 @@
+package router
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mrz1836/go-api/metrics"
+	"github.com/mrz1836/go-api/request"
+	"github.com/mrz1836/go-api/responsecache"
+	"github.com/mrz1836/go-logger"
+)
+
+// Response cache headers and limits
+const (
+	cacheNameResponse   = "response"
+	headerAge           = "Age"
+	headerAuthorization = "Authorization"
+	headerCacheControl  = "Cache-Control"
+	headerVary          = "Vary"
+	headerXCache        = "X-Cache"
+	maxCachedBodyBytes  = 1 << 20 // 1MB (larger responses are not cached)
+)
+
+// responseCacheMiddleware serves GET responses from the cache for routes that opt in (see config response_cache.routes)
+func responseCacheMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+
+		// Not a cached route
+		ctx := req.Context()
+		route := request.Route(ctx)
+		rule, ok := responsecache.RuleFor(req.Method, route)
+		if !ok {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		// Shared entries (not varied by principal) are only for anonymous requests, the cache runs before
+		// the route's auth so a response behind auth is never stored where another caller could get it
+		if !rule.VaryByPrincipal && hasCredentials(req) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		key := responsecache.Key(req, route, rule)
+
+		// Serve from the cache (unless the client asks for a fresh response)
+		if !skipCachedResponse(req) {
+			cached, found, err := responsecache.Get(ctx, key)
+			if err != nil {
+				logger.Data(2, logger.ERROR, "response cache error: "+err.Error(), request.LogParameters(ctx)...)
+			} else if found {
+				metrics.CacheHit(cacheNameResponse)
+				writeCachedResponse(w, cached, rule)
+				return
+			}
+		}
+		metrics.CacheMiss(cacheNameResponse)
+
+		// Record the response while writing it
+		recorder := &cacheRecorder{
+			ResponseWriter: w,
+			existing:       headerNames(w.Header()),
+			rule:           rule,
+			status:         http.StatusOK,
+		}
+		next.ServeHTTP(recorder, req)
+
+		// Only successful and complete responses are cached
+		if recorder.status != http.StatusOK || recorder.overflow {
+			return
+		}
+		if err := responsecache.Set(ctx, key, recorder.response(), rule); err != nil {
+			logger.Data(2, logger.ERROR, "response cache error: "+err.Error(), request.LogParameters(ctx)...)
+		}
+	})
+}
+
+// hasCredentials returns true if the request is authenticated or sends any credentials
+func hasCredentials(req *http.Request) bool {
+	return len(request.Principal(req.Context())) > 0 || len(req.Header.Get(headerAuthorization)) > 0
+}
+
+// skipCachedResponse returns true if the client asked for a fresh response
+func skipCachedResponse(req *http.Request) bool {
+	cacheControl := strings.ToLower(req.Header.Get(headerCacheControl))
+	return strings.Contains(cacheControl, "no-cache") || strings.Contains(cacheControl, "no-store")
+}
+
+// writeCachedResponse writes the cached response with the cache headers
+func writeCachedResponse(w http.ResponseWriter, cached *responsecache.Response, rule responsecache.Rule) {
+	for name, values := range cached.Header {
+		w.Header()[name] = values
+	}
+	setCacheHeaders(w.Header(), rule, time.Since(cached.StoredAt), "HIT")
+	w.WriteHeader(cached.Status)
+	_, _ = w.Write(cached.Body)
+}
+
+// setCacheHeaders sets Cache-Control (max-age is the TTL), Age, Vary and X-Cache
+func setCacheHeaders(header http.Header, rule responsecache.Rule, age time.Duration, result string) {
+	visibility, vary := "public", "Origin"
+	if rule.VaryByPrincipal {
+		visibility, vary = "private", "Origin, Authorization"
+	}
+	header.Set(headerCacheControl, visibility+", max-age="+strconv.Itoa(int(rule.TTL.Seconds())))
+	header.Set(headerAge, strconv.Itoa(int(age.Seconds())))
+	header.Set(headerVary, vary)
+	header.Set(headerXCache, result)
+}
+
+// headerNames returns the names of the headers already set (IE: by other middleware)
+func headerNames(header http.Header) map[string]struct{} {
+	names := make(map[string]struct{}, len(header))
+	for name := range header {
+		names[name] = struct{}{}
+	}
+	return names
+}
+
+// cacheRecorder writes the response and keeps a copy for the cache
+type cacheRecorder struct {
+	http.ResponseWriter
+	body        bytes.Buffer
+	existing    map[string]struct{}
+	overflow    bool
+	rule        responsecache.Rule
+	status      int
+	wroteHeader bool
+}
+
+// WriteHeader records the status code (cache headers are only set for cacheable responses)
+func (c *cacheRecorder) WriteHeader(statusCode int) {
+	if c.wroteHeader {
+		return
+	}
+	c.status = statusCode
+	c.wroteHeader = true
+	if statusCode == http.StatusOK {
+		setCacheHeaders(c.Header(), c.rule, 0, "MISS")
+	}
+	c.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write records the body (up to the limit)
+func (c *cacheRecorder) Write(b []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	if !c.overflow {
+		if c.body.Len()+len(b) > maxCachedBodyBytes {
+			c.overflow = true
+			c.body.Reset()
+		} else {
+			c.body.Write(b)
+		}
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the original writer (used by http.ResponseController)
+func (c *cacheRecorder) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// response returns the recorded response (without headers from other middleware, cookies or cache headers)
+func (c *cacheRecorder) response() *responsecache.Response {
+	header := make(http.Header)
+	for name, values := range c.Header() {
+		if _, ok := c.existing[name]; ok {
+			continue
+		}
+		switch name {
+		case headerAge, headerCacheControl, headerVary, headerXCache, "Set-Cookie":
+			continue
+		}
+		header[name] = append([]string(nil), values...)
+	}
+	return &responsecache.Response{
+		Body:     append([]byte(nil), c.body.Bytes()...),
+		Header:   header,
+		Status:   c.status,
+		StoredAt: time.Now().UTC(),
+	}
+}
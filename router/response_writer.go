@@ -0,0 +1,48 @@
+package router
+
+import "net/http"
+
+// responseWriter records the status code and bytes written for the middleware
+type responseWriter struct {
+	http.ResponseWriter
+	bytes       int
+	status      int
+	wroteHeader bool
+}
+
+// newResponseWriter wraps the writer (default status is 200)
+func newResponseWriter(w http.ResponseWriter) *responseWriter {
+	if rw, ok := w.(*responseWriter); ok {
+		return rw
+	}
+	return &responseWriter{ResponseWriter: w, status: http.StatusOK}
+}
+
+// WriteHeader records the status code
+func (w *responseWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.status = statusCode
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write records the bytes written
+func (w *responseWriter) Write(b []byte) (n int, err error) {
+	w.wroteHeader = true
+	n, err = w.ResponseWriter.Write(b)
+	w.bytes += n
+	return
+}
+
+// Flush passes through to the underlying writer (if supported)
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the original writer (used by http.ResponseController)
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -2,15 +2,20 @@
 package router
 
 import (
-	"github.com/julienschmidt/httprouter"
+	"net/http"
+
 	apirouter "github.com/mrz1836/go-api-router"
 	"github.com/mrz1836/go-api/actions/api"
+	"github.com/mrz1836/go-api/actions/emails"
+	"github.com/mrz1836/go-api/actions/jobs"
 	"github.com/mrz1836/go-api/actions/persons"
+	"github.com/mrz1836/go-api/actions/tasks"
+	"github.com/mrz1836/go-api/actions/webhooks"
 	"github.com/mrz1836/go-api/config"
 )
 
 // Handlers isolated the handlers / router for API (helps with testing)
-func Handlers() *httprouter.Router {
+func Handlers() http.Handler {
 
 	// Create a new router
 	r := apirouter.New()
@@ -30,10 +35,23 @@ func Handlers() *httprouter.Router {
 		// s.Use(passThrough)
 
 		api.RegisterRoutes(r)
+		emails.RegisterRoutes(r)
+		jobs.RegisterRoutes(r)
 		persons.RegisterRoutes(r)
+		tasks.RegisterRoutes(r)
+		webhooks.RegisterRoutes(r)
 
 	} // else (another service mode?)
 
-	// Return the router
-	return r.HTTPRouter.Router
+	// Return the router wrapped in the http middleware
+	return chain(
+		r.HTTPRouter.Router,
+		routeMiddleware(r.HTTPRouter.Router),
+		requestIDMiddleware(parseProxies(config.Values.TrustedProxies)),
+		tracingMiddleware,
+		accessLogMiddleware,
+		metricsMiddleware,
+		rateLimitMiddleware(newRateLimitStore()),
+		responseCacheMiddleware,
+	)
 }
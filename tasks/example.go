@@ -0,0 +1,26 @@
+package tasks
+
+import (
+	"context"
+
+	"github.com/mrz1836/go-api/request"
+	"github.com/mrz1836/go-logger"
+)
+
+// ExamplePayload is the payload for the example task
+type ExamplePayload struct {
+	Message string `json:"message"`
+}
+
+// ExampleTask is an example task (IE: ExampleTask.Enqueue(ctx, &ExamplePayload{Message: "hello"}, Delay(time.Minute)))
+var ExampleTask = Define("example", 3, exampleTask)
+
+// exampleTask is the handler for the example task
+func exampleTask(ctx context.Context, payload *ExamplePayload) error {
+
+	logger.Data(2, logger.DEBUG, "example task: "+payload.Message, request.LogParameters(ctx)...)
+
+	// Do something (pass the ctx to any models)
+
+	return nil
+}
@@ -0,0 +1,181 @@
+/*
+Package tasks is a durable background task queue stored in MySQL (claimed with FOR UPDATE SKIP LOCKED)
+
+Tasks have typed payloads, are retried with backoff and move to the dead-letter list (status dead)
+after the last attempt. Tasks can be delayed or scheduled for a specific time.
+*/
+package tasks
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mrz1836/go-api/database"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/queries"
+)
+
+// Task statuses
+const (
+	StatusDead    = "dead"
+	StatusPending = "pending"
+	StatusRunning = "running"
+)
+
+// ErrNotFound is when a dead task is not found (IE: requeue)
+var ErrNotFound = errors.New("dead task not found")
+
+// Configuration is the task queue configuration
+type Configuration struct {
+	Enabled           bool          `json:"enabled" mapstructure:"enabled"`                       // true
+	MaxBackoff        time.Duration `json:"max_backoff" mapstructure:"max_backoff"`               // 1h (longest wait between attempts)
+	PollInterval      time.Duration `json:"poll_interval" mapstructure:"poll_interval"`           // 1s (wait when the queue is empty)
+	RetryBackoff      time.Duration `json:"retry_backoff" mapstructure:"retry_backoff"`           // 10s (doubles after each attempt)
+	RunInAPI          bool          `json:"run_in_api" mapstructure:"run_in_api"`                 // false (also run workers in the api service mode)
+	Timeout           time.Duration `json:"timeout" mapstructure:"timeout"`                       // 4m (handler timeout)
+	VisibilityTimeout time.Duration `json:"visibility_timeout" mapstructure:"visibility_timeout"` // 5m (claim length, then retried by another worker - longer than the timeout plus a margin)
+	Workers           int           `json:"workers" mapstructure:"workers"`                       // 5 (worker pool size)
+}
+
+// Task is a queued task (tasks table)
+type Task struct {
+	Attempts    int             `boil:"attempts" json:"attempts"`
+	CreatedAt   time.Time       `boil:"created_at" json:"created_at"`
+	ID          uint64          `boil:"id" json:"id"`
+	LastError   string          `boil:"last_error" json:"last_error,omitempty"`
+	LockedBy    string          `boil:"locked_by" json:"locked_by,omitempty"`
+	LockedUntil null.Time       `boil:"locked_until" json:"locked_until,omitempty"`
+	MaxAttempts int             `boil:"max_attempts" json:"max_attempts"`
+	ModifiedAt  time.Time       `boil:"modified_at" json:"modified_at"`
+	Payload     json.RawMessage `boil:"payload" json:"payload"`
+	RunAt       time.Time       `boil:"run_at" json:"run_at"`
+	Status      string          `boil:"status" json:"status"`
+	Type        string          `boil:"type" json:"type"`
+}
+
+// handlerFunc decodes the payload and runs the typed handler
+type handlerFunc func(ctx context.Context, payload json.RawMessage) error
+
+// registered handlers by task type
+var (
+	handlers     = make(map[string]handlerFunc)
+	handlerMutex sync.RWMutex
+)
+
+// Definition is a task type with a typed payload
+type Definition[T any] struct {
+	MaxAttempts int    // Attempts before the task is dead
+	Name        string // Unique task type
+}
+
+// Define registers the handler for the task type (call at start up, in every service mode)
+func Define[T any](name string, maxAttempts int, handler func(ctx context.Context, payload *T) error) *Definition[T] {
+	handlerMutex.Lock()
+	defer handlerMutex.Unlock()
+	if _, ok := handlers[name]; ok {
+		panic("task type already defined: " + name)
+	}
+	handlers[name] = func(ctx context.Context, raw json.RawMessage) error {
+		payload := new(T)
+		if err := json.Unmarshal(raw, payload); err != nil {
+			return fmt.Errorf("error decoding %s payload: %w", name, err)
+		}
+		return handler(ctx, payload)
+	}
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &Definition[T]{MaxAttempts: maxAttempts, Name: name}
+}
+
+// Option changes when a task runs
+type Option func(runAt *time.Time)
+
+// Delay runs the task after the duration
+func Delay(duration time.Duration) Option {
+	return func(runAt *time.Time) {
+		*runAt = time.Now().UTC().Add(duration)
+	}
+}
+
+// At runs the task at the scheduled time
+func At(scheduled time.Time) Option {
+	return func(runAt *time.Time) {
+		*runAt = scheduled.UTC()
+	}
+}
+
+// executor is a database or a transaction
+type executor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Enqueue adds the task to the queue
+func (d *Definition[T]) Enqueue(ctx context.Context, payload *T, options ...Option) (uint64, error) {
+	return d.enqueue(ctx, database.WriteDatabase, payload, options)
+}
+
+// EnqueueTx adds the task in the transaction (only queued if the transaction commits)
+func (d *Definition[T]) EnqueueTx(ctx context.Context, tx *sql.Tx, payload *T, options ...Option) (uint64, error) {
+	return d.enqueue(ctx, tx, payload, options)
+}
+
+// enqueue inserts the task
+func (d *Definition[T]) enqueue(ctx context.Context, exec executor, payload *T, options []Option) (id uint64, err error) {
+
+	// Encode the payload
+	var data []byte
+	if data, err = json.Marshal(payload); err != nil {
+		return
+	}
+
+	// When to run
+	runAt := time.Now().UTC()
+	for _, option := range options {
+		option(&runAt)
+	}
+
+	// Insert the record
+	var result sql.Result
+	if result, err = exec.ExecContext(ctx,
+		"INSERT INTO `tasks` (`type`, `payload`, `status`, `max_attempts`, `run_at`, `last_error`) VALUES (?, ?, ?, ?, ?, '')",
+		d.Name, data, StatusPending, d.MaxAttempts, runAt,
+	); err != nil {
+		return
+	}
+
+	var lastID int64
+	if lastID, err = result.LastInsertId(); err != nil {
+		return
+	}
+	return uint64(lastID), nil
+}
+
+// DeadLetters returns the dead tasks (newest first)
+func DeadLetters(ctx context.Context, limit int) (list []*Task, err error) {
+	err = queries.Raw(
+		"SELECT * FROM `tasks` WHERE `status` = ? ORDER BY `id` DESC LIMIT ?", StatusDead, limit,
+	).Bind(ctx, database.ReadDatabase, &list)
+	return
+}
+
+// Requeue moves a dead task back to the queue (attempts are reset)
+func Requeue(ctx context.Context, id uint64) (err error) {
+	var result sql.Result
+	if result, err = database.WriteDatabase.ExecContext(ctx,
+		"UPDATE `tasks` SET `status` = ?, `attempts` = 0, `run_at` = ?, `locked_by` = '', `locked_until` = NULL WHERE `id` = ? AND `status` = ?",
+		StatusPending, time.Now().UTC(), id, StatusDead,
+	); err != nil {
+		return
+	}
+	var affected int64
+	if affected, err = result.RowsAffected(); err == nil && affected == 0 {
+		err = fmt.Errorf("%w: %d", ErrNotFound, id)
+	}
+	return
+}
@@ -0,0 +1,220 @@
+package tasks
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mrz1836/go-api/database"
+	"github.com/mrz1836/go-api/locks"
+	"github.com/mrz1836/go-api/metrics"
+	"github.com/mrz1836/go-api/request"
+	"github.com/mrz1836/go-logger"
+)
+
+// Task results (used for metrics)
+const (
+	resultAbandoned = "abandoned"
+	resultDead      = "dead"
+	resultRetry     = "retry"
+	resultSuccess   = "success"
+)
+
+// stopTimeout is how long a handler has to return after its timeout (less than config.TaskVisibilityMargin)
+const stopTimeout = 10 * time.Second
+
+// errStillRunning is when a handler ignores the timeout (the task is left for another worker once the claim expires)
+var errStillRunning = errors.New("task handler is still running after the timeout")
+
+// Run starts the worker pool and blocks until the ctx is done (in-flight tasks are finished first)
+func Run(ctx context.Context, conf Configuration) {
+
+	logger.Data(2, logger.INFO, "task workers: starting", logger.MakeParameter("workers", conf.Workers))
+
+	var wg sync.WaitGroup
+	for i := 1; i <= conf.Workers; i++ {
+		wg.Add(1)
+		go func(workerID string) {
+			defer wg.Done()
+			work(ctx, conf, workerID)
+		}(locks.InstanceOwner() + ":" + strconv.Itoa(i))
+	}
+	wg.Wait()
+
+	logger.Data(2, logger.INFO, "task workers: stopped")
+}
+
+// work claims and processes tasks until the ctx is done (waits for the poll interval if the queue is empty)
+func work(ctx context.Context, conf Configuration, workerID string) {
+	for ctx.Err() == nil {
+		task, err := claim(ctx, workerID, conf.VisibilityTimeout)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			logger.Data(2, logger.ERROR, "task workers: error claiming task: "+err.Error())
+		}
+		if task == nil {
+			select {
+			case <-ctx.Done():
+			case <-time.After(conf.PollInterval):
+			}
+			continue
+		}
+		process(task, conf, workerID)
+	}
+}
+
+// claim locks the next due task (or an abandoned task) for this worker
+func claim(ctx context.Context, workerID string, visibility time.Duration) (task *Task, err error) {
+
+	// Start a transaction (rows are only locked until the claim is committed)
+	var tx *sql.Tx
+	if tx, err = database.WriteDatabase.BeginTx(ctx, nil); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	// Next due task, skipping tasks claimed by other workers
+	now := time.Now().UTC()
+	task = new(Task)
+	if err = tx.QueryRowContext(ctx,
+		"SELECT `id`, `type`, `payload`, `attempts`, `max_attempts` FROM `tasks` "+
+			"WHERE (`status` = ? AND `run_at` <= ?) OR (`status` = ? AND `locked_until` < ?) "+
+			"ORDER BY `run_at` LIMIT 1 FOR UPDATE SKIP LOCKED",
+		StatusPending, now, StatusRunning, now,
+	).Scan(&task.ID, &task.Type, &task.Payload, &task.Attempts, &task.MaxAttempts); err != nil {
+		task = nil
+		if errors.Is(err, sql.ErrNoRows) {
+			err = nil
+			_ = tx.Rollback()
+		}
+		return
+	}
+
+	// Claim it
+	task.Attempts++
+	task.LockedBy = workerID
+	task.Status = StatusRunning
+	if _, err = tx.ExecContext(ctx,
+		"UPDATE `tasks` SET `status` = ?, `attempts` = ?, `locked_by` = ?, `locked_until` = ? WHERE `id` = ?",
+		task.Status, task.Attempts, task.LockedBy, now.Add(visibility), task.ID,
+	); err != nil {
+		task = nil
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		task = nil
+	}
+	return
+}
+
+// process runs the handler and completes, retries or kills the task
+func process(task *Task, conf Configuration, workerID string) {
+
+	// Each task has its own request ID (log correlation)
+	ctx := request.WithPrincipal(request.WithID(context.Background(), request.NewID()), "task:"+task.Type)
+	start := time.Now()
+
+	// Run the handler (gives up before the claim expires, a handler that ignores the ctx is left running)
+	err := execute(ctx, task, conf.Timeout)
+	if errors.Is(err, errStillRunning) {
+		metrics.ObserveTask(task.Type, resultAbandoned, time.Since(start))
+		logger.Data(2, logger.ERROR, fmt.Sprintf("task %s %d abandoned (attempt %d of %d), reclaimed once the claim expires: %s", task.Type, task.ID, task.Attempts, task.MaxAttempts, err.Error()),
+			request.LogParameters(ctx)...,
+		)
+		return
+	}
+
+	// Complete (remove), retry with backoff or move to the dead-letter list
+	result := resultSuccess
+	var query string
+	var args []interface{}
+	switch {
+	case err == nil:
+		query, args = "DELETE FROM `tasks` WHERE `id` = ? AND `locked_by` = ?", []interface{}{task.ID, workerID}
+	case task.Attempts >= task.MaxAttempts:
+		result = resultDead
+		query = "UPDATE `tasks` SET `status` = ?, `last_error` = ?, `locked_by` = '', `locked_until` = NULL WHERE `id` = ? AND `locked_by` = ?"
+		args = []interface{}{StatusDead, err.Error(), task.ID, workerID}
+	default:
+		result = resultRetry
+		runAt := time.Now().UTC().Add(retryBackoff(conf, task.Attempts))
+		query = "UPDATE `tasks` SET `status` = ?, `last_error` = ?, `run_at` = ?, `locked_by` = '', `locked_until` = NULL WHERE `id` = ? AND `locked_by` = ?"
+		args = []interface{}{StatusPending, err.Error(), runAt, task.ID, workerID}
+	}
+	metrics.ObserveTask(task.Type, result, time.Since(start))
+
+	if err != nil {
+		logger.Data(2, logger.ERROR, fmt.Sprintf("task %s %d failed (attempt %d of %d): %s", task.Type, task.ID, task.Attempts, task.MaxAttempts, err.Error()),
+			request.LogParameters(ctx)...,
+		)
+	}
+
+	// Update the task (not using the worker ctx, it is cancelled during shutdown)
+	if _, updateErr := database.WriteDatabase.ExecContext(ctx, query, args...); updateErr != nil {
+		logger.Data(2, logger.ERROR, fmt.Sprintf("task %s %d error updating: %s", task.Type, task.ID, updateErr.Error()), request.LogParameters(ctx)...)
+	}
+}
+
+// retryBackoff returns the wait before the next attempt (doubles after each attempt, up to the max backoff)
+func retryBackoff(conf Configuration, attempts int) time.Duration {
+	backoff := conf.RetryBackoff
+	for i := 1; i < attempts && backoff < conf.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > conf.MaxBackoff {
+		backoff = conf.MaxBackoff
+	}
+	return backoff
+}
+
+// execute runs the handler with the timeout (recovers from panics)
+//
+// Waits for the handler to return for up to the stop timeout after its timeout, then returns errStillRunning
+func execute(ctx context.Context, task *Task, timeout time.Duration) (err error) {
+
+	// Find the handler
+	handlerMutex.RLock()
+	handler, ok := handlers[task.Type]
+	handlerMutex.RUnlock()
+	if !ok {
+		return fmt.Errorf("no handler defined for task type: %s", task.Type)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// Run the handler in a routine so a handler that ignores the ctx can't hold the worker past the claim
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("task panicked: %v\n%s", r, debug.Stack())
+			}
+		}()
+		done <- handler(ctx, task.Payload)
+	}()
+
+	// Wait for the handler or the timeout
+	select {
+	case err = <-done:
+		return
+	case <-ctx.Done():
+	}
+
+	// Give the canceled handler time to stop
+	select {
+	case err = <-done:
+	case <-time.After(stopTimeout):
+		err = fmt.Errorf("%w: %w", errStillRunning, ctx.Err())
+	}
+	return
+}
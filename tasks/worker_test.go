@@ -0,0 +1,28 @@
+package tasks
+
+import (
+	"testing"
+	"time"
+)
+
+// TestRetryBackoff tests the backoff doubles after each attempt up to the max backoff
+func TestRetryBackoff(t *testing.T) {
+	conf := Configuration{MaxBackoff: time.Hour, RetryBackoff: 10 * time.Second}
+
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{9, 2560 * time.Second},
+		{10, time.Hour},
+		{100, time.Hour}, // Would overflow without the cap
+	}
+	for _, test := range tests {
+		if backoff := retryBackoff(conf, test.attempts); backoff != test.expected {
+			t.Fatalf("attempt %d: expected %s, got %s", test.attempts, test.expected, backoff)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+/*
+Package tracing provides OpenTelemetry distributed tracing (http, database, cache, email)
+*/
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mrz1836/go-logger"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporter and sampler constants
+const (
+	ExporterFile    = "file"
+	ExporterNone    = "none"
+	ExporterOTLP    = "otlp"
+	ExporterStdout  = "stdout"
+	SamplerAlways   = "always"
+	SamplerNever    = "never"
+	SamplerRatio    = "ratio"
+	instrumentation = "github.com/mrz1836/go-api"
+	traceIDKey      = "trace_id"
+)
+
+// Configuration is the tracing configuration
+type Configuration struct {
+	Enabled     bool    `json:"enabled" mapstructure:"enabled"`           // true
+	Endpoint    string  `json:"endpoint" mapstructure:"endpoint"`         // localhost:4318 (otlp http)
+	Exporter    string  `json:"exporter" mapstructure:"exporter"`         // otlp, stdout, file or none
+	FilePath    string  `json:"file_path" mapstructure:"file_path"`       // traces.json (file exporter)
+	Insecure    bool    `json:"insecure" mapstructure:"insecure"`         // true (no TLS for the otlp endpoint)
+	Sampler     string  `json:"sampler" mapstructure:"sampler"`           // always, never or ratio
+	SampleRatio float64 `json:"sample_ratio" mapstructure:"sample_ratio"` // 0.1 (ratio sampler)
+	ServiceName string  `json:"service_name" mapstructure:"service_name"` // go-api
+}
+
+// provider is the current tracer provider (nil if tracing is disabled)
+var provider *sdktrace.TracerProvider
+
+// traceFile is the file of the file exporter (closed on shutdown)
+var traceFile *os.File
+
+// StartUp will create the exporter and set the global tracer provider and propagator
+func StartUp(ctx context.Context, conf Configuration, environment string) (err error) {
+
+	// Always propagate W3C trace context (even if not exporting)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	// Tracing is disabled
+	if !conf.Enabled || conf.Exporter == ExporterNone || len(conf.Exporter) == 0 {
+		logger.Data(2, logger.INFO, "tracing: disabled")
+		return
+	}
+
+	// Create the exporter
+	var exporter sdktrace.SpanExporter
+	if exporter, err = newExporter(ctx, conf); err != nil {
+		return
+	}
+
+	// Describe the service
+	var res *resource.Resource
+	if res, err = resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(conf.ServiceName),
+		semconv.DeploymentEnvironment(environment),
+	)); err != nil {
+		return
+	}
+
+	// Create and set the provider
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(newSampler(conf)),
+	)
+	otel.SetTracerProvider(provider)
+
+	logger.Data(2, logger.INFO, "tracing: enabled",
+		logger.MakeParameter("exporter", conf.Exporter),
+		logger.MakeParameter("sampler", conf.Sampler),
+	)
+
+	return
+}
+
+// Shutdown flushes any remaining spans, stops the provider and closes the trace file
+func Shutdown(ctx context.Context) (err error) {
+	if provider != nil {
+		err = provider.Shutdown(ctx)
+	}
+	if traceFile != nil {
+		if closeErr := traceFile.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		traceFile = nil
+	}
+	return
+}
+
+// newExporter creates the span exporter from the configuration
+func newExporter(ctx context.Context, conf Configuration) (sdktrace.SpanExporter, error) {
+	switch conf.Exporter {
+	case ExporterOTLP:
+		options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(conf.Endpoint)}
+		if conf.Insecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.New(ctx, options...)
+	case ExporterStdout:
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterFile:
+		file, err := os.OpenFile(conf.FilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("error opening trace file: %w", err)
+		}
+		traceFile = file
+		return stdouttrace.New(stdouttrace.WithWriter(io.Writer(file)))
+	}
+	return nil, fmt.Errorf("unknown tracing exporter: %s", conf.Exporter)
+}
+
+// newSampler creates the sampler (parent based, so upstream decisions are respected)
+func newSampler(conf Configuration) sdktrace.Sampler {
+	switch conf.Sampler {
+	case SamplerNever:
+		return sdktrace.ParentBased(sdktrace.NeverSample())
+	case SamplerRatio:
+		return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(conf.SampleRatio))
+	}
+	return sdktrace.ParentBased(sdktrace.AlwaysSample())
+}
+
+// Tracer returns the tracer for the API
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentation)
+}
+
+// StartSpan starts a new (child) span
+func StartSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// EndSpan records the error (if any) and ends the span
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// TraceID returns the trace ID from the context (empty if no valid span)
+func TraceID(ctx context.Context) string {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.HasTraceID() {
+		return ""
+	}
+	return spanContext.TraceID().String()
+}
+
+// LogParameters returns the trace ID as a log parameter (use with logger.Data)
+func LogParameters(ctx context.Context) (params []logger.KeyValue) {
+	if traceID := TraceID(ctx); len(traceID) > 0 {
+		params = append(params, logger.MakeParameter(traceIDKey, traceID))
+	}
+	return
+}
+
+// ErrorData returns the trace ID for the data field of an apirouter error
+func ErrorData(ctx context.Context) interface{} {
+	if traceID := TraceID(ctx); len(traceID) > 0 {
+		return map[string]string{traceIDKey: traceID}
+	}
+	return ""
+}
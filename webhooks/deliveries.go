@@ -0,0 +1,417 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-api/database"
+	"github.com/mrz1836/go-api/request"
+	"github.com/mrz1836/go-logger"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/queries"
+)
+
+// Delivery statuses
+const (
+	DeliveryStatusDelivered = "delivered"
+	DeliveryStatusFailed    = "failed"
+	DeliveryStatusPending   = "pending"
+	DeliveryStatusSending   = "sending"
+)
+
+// Delivery headers (the signature is over "timestamp.body")
+const (
+	HeaderDelivery  = "Webhook-Delivery"
+	HeaderEvent     = "Webhook-Event"
+	HeaderID        = "Webhook-ID"
+	HeaderSignature = "Webhook-Signature" // v1=<hex HMAC-SHA256>
+	HeaderTimestamp = "Webhook-Timestamp" // Unix seconds
+)
+
+// responseBodyLimit is how much of the endpoint response is kept in the delivery log
+const responseBodyLimit = 1000
+
+var (
+	// ErrDeliveryNotFound is when the delivery does not exist
+	ErrDeliveryNotFound = errors.New("webhook delivery not found")
+
+	// ErrInvalidSignature is when the signature header is missing, expired or does not match
+	ErrInvalidSignature = errors.New("webhook signature is not valid")
+)
+
+// Event is the body of every delivery
+type Event struct {
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+	ID        string      `json:"id"` // The same for every subscription and replay (receivers can dedupe)
+	Type      string      `json:"type"`
+}
+
+// Delivery is an event queued for a subscription (webhook_deliveries table)
+type Delivery struct {
+	Attempts       int             `boil:"attempts" json:"attempts"`
+	CreatedAt      time.Time       `boil:"created_at" json:"created_at"`
+	DeliveredAt    null.Time       `boil:"delivered_at" json:"delivered_at,omitempty"`
+	EventID        string          `boil:"event_id" json:"event_id"`
+	EventType      string          `boil:"event_type" json:"event_type"`
+	ID             uint64          `boil:"id" json:"id"`
+	LastError      string          `boil:"last_error" json:"last_error,omitempty"`
+	LockedUntil    null.Time       `boil:"locked_until" json:"locked_until,omitempty"`
+	MaxAttempts    int             `boil:"max_attempts" json:"max_attempts"`
+	ModifiedAt     time.Time       `boil:"modified_at" json:"modified_at"`
+	NextAttemptAt  time.Time       `boil:"next_attempt_at" json:"next_attempt_at"`
+	Payload        json.RawMessage `boil:"payload" json:"payload"`
+	ResponseStatus int             `boil:"response_status" json:"response_status,omitempty"`
+	Status         string          `boil:"status" json:"status"`
+	SubscriptionID uint64          `boil:"subscription_id" json:"subscription_id"`
+}
+
+// Attempt is a single attempt to deliver (webhook_attempts table)
+type Attempt struct {
+	Attempt        int       `boil:"attempt" json:"attempt"`
+	CreatedAt      time.Time `boil:"created_at" json:"created_at"`
+	DeliveryID     uint64    `boil:"delivery_id" json:"delivery_id"`
+	DurationMs     int64     `boil:"duration_ms" json:"duration_ms"`
+	Error          string    `boil:"error" json:"error,omitempty"`
+	ID             uint64    `boil:"id" json:"id"`
+	ResponseBody   string    `boil:"response_body" json:"response_body,omitempty"`
+	ResponseStatus int       `boil:"response_status" json:"response_status,omitempty"`
+	Status         string    `boil:"status" json:"status"`
+}
+
+// queue adds a delivery of the event for every active subscription to its type (once per subscription and event ID)
+func queue(ctx context.Context, event *Event) (queued int64, err error) {
+	var payload []byte
+	if payload, err = json.Marshal(event); err != nil {
+		return
+	}
+
+	// One delivery per subscription (skipped if the event was already queued, IE: relayed again)
+	var result sql.Result
+	if result, err = database.WriteDatabase.ExecContext(ctx,
+		"INSERT INTO `webhook_deliveries` (`subscription_id`, `event_id`, `event_type`, `payload`, `status`, `max_attempts`, `next_attempt_at`, `last_error`) "+
+			"SELECT `s`.`id`, ?, ?, ?, ?, ?, ?, '' FROM `webhook_subscriptions` `s` WHERE `s`.`is_active` = 1 AND FIND_IN_SET(?, `s`.`event_types`) > 0 "+
+			"AND NOT EXISTS (SELECT 1 FROM `webhook_deliveries` `d` WHERE `d`.`subscription_id` = `s`.`id` AND `d`.`event_id` = ?)",
+		event.ID, event.Type, payload, DeliveryStatusPending, config.Values.Webhooks.MaxAttempts, event.CreatedAt, event.Type, event.ID,
+	); err != nil {
+		return
+	}
+	return result.RowsAffected()
+}
+
+// Dispatch delivers the due events in batches until none are left (the webhook-deliveries job)
+//
+// Each batch is delivered in order within its claim (config webhooks.visibility_timeout covers batch_size x timeout)
+func Dispatch(ctx context.Context) error {
+	conf := config.Values.Webhooks
+	client := NewClient(conf.Timeout, conf.AllowInsecure)
+	for {
+		deliveries, err := claimDeliveries(ctx, conf.BatchSize, conf.VisibilityTimeout)
+		if err != nil {
+			return fmt.Errorf("error claiming webhook deliveries: %w", err)
+		}
+		for _, delivery := range deliveries {
+			deliver(ctx, client, delivery)
+		}
+		if len(deliveries) < conf.BatchSize || ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// Replay queues the event of the delivery again as a new delivery (same event ID, the original log is kept)
+func Replay(ctx context.Context, deliveryID uint64) (id uint64, err error) {
+	var result sql.Result
+	if result, err = database.WriteDatabase.ExecContext(ctx,
+		"INSERT INTO `webhook_deliveries` (`subscription_id`, `event_id`, `event_type`, `payload`, `status`, `max_attempts`, `next_attempt_at`, `last_error`) "+
+			"SELECT `d`.`subscription_id`, `d`.`event_id`, `d`.`event_type`, `d`.`payload`, ?, ?, ?, '' FROM `webhook_deliveries` `d` "+
+			"INNER JOIN `webhook_subscriptions` `s` ON `s`.`id` = `d`.`subscription_id` WHERE `d`.`id` = ?",
+		DeliveryStatusPending, config.Values.Webhooks.MaxAttempts, time.Now().UTC(), deliveryID,
+	); err != nil {
+		return
+	}
+	var rows, lastID int64
+	if rows, err = result.RowsAffected(); err != nil {
+		return
+	} else if rows == 0 {
+		return 0, fmt.Errorf("%w (or its subscription was deleted): %d", ErrDeliveryNotFound, deliveryID)
+	}
+	if lastID, err = result.LastInsertId(); err != nil {
+		return
+	}
+	return uint64(lastID), nil
+}
+
+// GetDeliveries gets the latest deliveries (newest first, subscription and status are optional filters)
+func GetDeliveries(ctx context.Context, subscriptionID uint64, status string, limit int) (deliveries []*Delivery, err error) {
+	query := "SELECT * FROM `webhook_deliveries` WHERE 1 = 1"
+	var args []interface{}
+	if subscriptionID > 0 {
+		query += " AND `subscription_id` = ?"
+		args = append(args, subscriptionID)
+	}
+	if len(status) > 0 {
+		query += " AND `status` = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY `id` DESC LIMIT ?"
+	args = append(args, limit)
+
+	if err = queries.Raw(query, args...).Bind(ctx, database.ReadDatabase, &deliveries); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// GetDelivery gets a delivery by ID
+func GetDelivery(ctx context.Context, id uint64) (delivery *Delivery, err error) {
+	delivery = new(Delivery)
+	if err = queries.Raw(
+		"SELECT * FROM `webhook_deliveries` WHERE `id` = ?", id,
+	).Bind(ctx, database.ReadDatabase, delivery); errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %d", ErrDeliveryNotFound, id)
+	} else if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// GetAttempts gets the delivery log of a delivery (oldest first)
+func GetAttempts(ctx context.Context, deliveryID uint64) (attempts []*Attempt, err error) {
+	if err = queries.Raw(
+		"SELECT * FROM `webhook_attempts` WHERE `delivery_id` = ? ORDER BY `id`", deliveryID,
+	).Bind(ctx, database.ReadDatabase, &attempts); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return attempts, nil
+}
+
+// Sign returns the signature header value for the body (v1=hex HMAC-SHA256 of "timestamp.body")
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	_, _ = mac.Write(body)
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks the signature and timestamp headers of a delivery (for receivers, tolerance limits replays)
+func VerifySignature(secret, signature, timestamp string, body []byte, tolerance time.Duration) error {
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: timestamp", ErrInvalidSignature)
+	}
+	if age := time.Since(time.Unix(unix, 0)); tolerance > 0 && (age > tolerance || age < -tolerance) {
+		return fmt.Errorf("%w: timestamp is outside the tolerance", ErrInvalidSignature)
+	}
+	if !hmac.Equal([]byte(signature), []byte(Sign(secret, unix, body))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// claimDeliveries locks the next due deliveries (or abandoned deliveries) for this dispatcher
+func claimDeliveries(ctx context.Context, limit int, visibility time.Duration) (deliveries []*Delivery, err error) {
+
+	// Start a transaction (rows are only locked until the claim is committed)
+	var tx *sql.Tx
+	if tx, err = database.WriteDatabase.BeginTx(ctx, nil); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	// Next due deliveries, skipping deliveries claimed by other dispatchers
+	now := time.Now().UTC()
+	if err = queries.Raw(
+		"SELECT * FROM `webhook_deliveries` "+
+			"WHERE (`status` = ? AND `next_attempt_at` <= ?) OR (`status` = ? AND `locked_until` < ?) "+
+			"ORDER BY `next_attempt_at` LIMIT ? FOR UPDATE SKIP LOCKED",
+		DeliveryStatusPending, now, DeliveryStatusSending, now, limit,
+	).Bind(ctx, tx, &deliveries); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+	if len(deliveries) == 0 {
+		return nil, tx.Rollback()
+	}
+
+	// Claim them
+	lockedUntil := null.TimeFrom(now.Add(visibility))
+	for _, delivery := range deliveries {
+		delivery.Attempts++
+		delivery.LockedUntil = lockedUntil
+		delivery.Status = DeliveryStatusSending
+		if _, err = tx.ExecContext(ctx,
+			"UPDATE `webhook_deliveries` SET `status` = ?, `attempts` = ?, `locked_until` = ? WHERE `id` = ?",
+			delivery.Status, delivery.Attempts, delivery.LockedUntil, delivery.ID,
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	return
+}
+
+// deliver posts the signed event and records the attempt (delivered, retried with backoff or failed)
+func deliver(ctx context.Context, client *http.Client, delivery *Delivery) {
+
+	// Post the event (the subscription may have been deleted or paused since it was queued)
+	start := time.Now()
+	attempt := &Attempt{Attempt: delivery.Attempts, DeliveryID: delivery.ID, Status: DeliveryStatusFailed}
+	subscription, err := getSubscriptionWithSecret(ctx, delivery.SubscriptionID)
+	permanent := errors.Is(err, ErrSubscriptionNotFound)
+	if err == nil && !subscription.IsActive {
+		err, permanent = errors.New("subscription is not active"), true
+	}
+	if err == nil {
+		attempt.ResponseStatus, attempt.ResponseBody, err = post(ctx, client, subscription, delivery)
+	}
+	attempt.DurationMs = time.Since(start).Milliseconds()
+
+	// Delivered, retried with backoff, or failed (no subscription or the last attempt)
+	now := time.Now().UTC()
+	delivery.ResponseStatus = attempt.ResponseStatus
+	switch {
+	case err == nil:
+		attempt.Status = DeliveryStatusDelivered
+		delivery.DeliveredAt = null.TimeFrom(now)
+		delivery.LastError = ""
+		delivery.Status = DeliveryStatusDelivered
+	case permanent || delivery.Attempts >= delivery.MaxAttempts:
+		attempt.Error = err.Error()
+		delivery.LastError = err.Error()
+		delivery.Status = DeliveryStatusFailed
+	default:
+		attempt.Error = err.Error()
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = now.Add(backoff(delivery.Attempts))
+		delivery.Status = DeliveryStatusPending
+	}
+
+	if err != nil {
+		logger.Data(2, logger.WARN, fmt.Sprintf("webhook delivery %d failed (attempt %d of %d): %s", delivery.ID, delivery.Attempts, delivery.MaxAttempts, err.Error()),
+			request.LogParameters(ctx)...,
+		)
+	}
+
+	// Update the delivery and the delivery log
+	if _, updateErr := database.WriteDatabase.ExecContext(ctx,
+		"UPDATE `webhook_deliveries` SET `status` = ?, `response_status` = ?, `last_error` = ?, `next_attempt_at` = ?, `delivered_at` = ?, `locked_until` = NULL WHERE `id` = ?",
+		delivery.Status, delivery.ResponseStatus, delivery.LastError, delivery.NextAttemptAt, delivery.DeliveredAt, delivery.ID,
+	); updateErr != nil {
+		logger.Data(2, logger.ERROR, fmt.Sprintf("webhook delivery %d error updating: %s", delivery.ID, updateErr.Error()), request.LogParameters(ctx)...)
+	}
+	if _, logErr := database.WriteDatabase.ExecContext(ctx,
+		"INSERT INTO `webhook_attempts` (`delivery_id`, `attempt`, `status`, `response_status`, `response_body`, `error`, `duration_ms`) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		attempt.DeliveryID, attempt.Attempt, attempt.Status, attempt.ResponseStatus, attempt.ResponseBody, attempt.Error, attempt.DurationMs,
+	); logErr != nil {
+		logger.Data(2, logger.ERROR, fmt.Sprintf("webhook delivery %d error logging attempt: %s", delivery.ID, logErr.Error()), request.LogParameters(ctx)...)
+	}
+}
+
+// post signs and sends the payload (any 2xx is delivered, redirects are not followed)
+func post(ctx context.Context, client *http.Client, subscription *Subscription, delivery *Delivery) (status int, body string, err error) {
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(delivery.Payload)); err != nil {
+		return
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-api-webhooks")
+	req.Header.Set(HeaderDelivery, strconv.FormatUint(delivery.ID, 10))
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderID, delivery.EventID)
+	req.Header.Set(HeaderSignature, Sign(subscription.Secret, timestamp, delivery.Payload))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+
+	var resp *http.Response
+	if resp, err = client.Do(req); err != nil {
+		return 0, "", fmt.Errorf("error posting webhook: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	// Keep the start of the response (for the delivery log)
+	read, _ := io.ReadAll(io.LimitReader(resp.Body, responseBodyLimit))
+	status, body = resp.StatusCode, strings.ToValidUTF8(string(read), "")
+	if status < 200 || status > 299 {
+		return status, body, fmt.Errorf("webhook endpoint returned status %d", status)
+	}
+	return status, body, nil
+}
+
+// getSubscriptionWithSecret gets the subscription including the secret (for signing)
+func getSubscriptionWithSecret(ctx context.Context, id uint64) (subscription *Subscription, err error) {
+	subscription = new(Subscription)
+	if err = queries.Raw(
+		"SELECT * FROM `webhook_subscriptions` WHERE `id` = ?", id,
+	).Bind(ctx, database.ReadDatabase, subscription); errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %d", ErrSubscriptionNotFound, id)
+	} else if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// backoff returns the wait before the next attempt (doubles after each attempt, up to the max)
+func backoff(attempts int) time.Duration {
+	conf := config.Values.Webhooks
+	wait := conf.RetryBackoff
+	for i := 1; i < attempts && wait < conf.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > conf.MaxBackoff {
+		wait = conf.MaxBackoff
+	}
+	return wait
+}
+
+// NewClient returns the client for the subscriber endpoints (only public addresses unless insecure, redirects are not followed)
+//
+// Also used for the web push endpoints (the browser supplies the url)
+func NewClient(timeout time.Duration, insecure bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !insecure {
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return fmt.Errorf("address %s is not public", host)
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	if !insecure {
+		transport.Proxy = nil // The address check applies to the endpoint, not a proxy
+	}
+	return &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+		Timeout:   timeout,
+		Transport: transport,
+	}
+}
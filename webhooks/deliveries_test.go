@@ -0,0 +1,81 @@
+package webhooks
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mrz1836/go-api/config"
+)
+
+// TestSign tests the signature of a delivery body
+func TestSign(t *testing.T) {
+	const expected = "v1=eba5c5e1bab2de502c4b3cbe29328011dd0782a4fb97bf01598df1da5105a8f6"
+	if signature := Sign("whsec_test", 1700000000, []byte(`{"type":"person.created"}`)); signature != expected {
+		t.Fatalf("expected %s, got %s", expected, signature)
+	}
+}
+
+// TestVerifySignature tests checking the signature and timestamp of a delivery
+func TestVerifySignature(t *testing.T) {
+	body := []byte(`{"type":"person.created"}`)
+	now := time.Now().Unix()
+	signature := Sign("whsec_test", now, body)
+
+	tests := []struct {
+		name      string
+		secret    string
+		signature string
+		timestamp string
+		body      []byte
+		tolerance time.Duration
+		valid     bool
+	}{
+		{"known good", "whsec_test", "v1=eba5c5e1bab2de502c4b3cbe29328011dd0782a4fb97bf01598df1da5105a8f6", "1700000000", body, 0, true},
+		{"current", "whsec_test", signature, strconv.FormatInt(now, 10), body, 5 * time.Minute, true},
+		{"tampered body", "whsec_test", signature, strconv.FormatInt(now, 10), []byte(`{"type":"person.deleted"}`), 5 * time.Minute, false},
+		{"tampered timestamp", "whsec_test", signature, strconv.FormatInt(now+1, 10), body, 5 * time.Minute, false},
+		{"wrong secret", "whsec_other", signature, strconv.FormatInt(now, 10), body, 5 * time.Minute, false},
+		{"no signature", "whsec_test", "", strconv.FormatInt(now, 10), body, 5 * time.Minute, false},
+		{"timestamp not a number", "whsec_test", signature, "now", body, 5 * time.Minute, false},
+		{"replayed", "whsec_test", "v1=eba5c5e1bab2de502c4b3cbe29328011dd0782a4fb97bf01598df1da5105a8f6", "1700000000", body, 5 * time.Minute, false},
+		{"from the future", "whsec_test", Sign("whsec_test", now+3600, body), strconv.FormatInt(now+3600, 10), body, 5 * time.Minute, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := VerifySignature(test.secret, test.signature, test.timestamp, test.body, test.tolerance)
+			if test.valid && err != nil {
+				t.Fatalf("expected a valid signature, got %s", err.Error())
+			} else if !test.valid && !errors.Is(err, ErrInvalidSignature) {
+				t.Fatalf("expected %s, got %v", ErrInvalidSignature, err)
+			}
+		})
+	}
+}
+
+// TestBackoff tests the wait between delivery attempts
+func TestBackoff(t *testing.T) {
+	config.Values.Webhooks.RetryBackoff = 30 * time.Second
+	config.Values.Webhooks.MaxBackoff = 6 * time.Hour
+
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{10, 4*time.Hour + 16*time.Minute},
+		{11, 6 * time.Hour},
+		{1000, 6 * time.Hour},
+	}
+	for _, test := range tests {
+		t.Run(strconv.Itoa(test.attempts), func(t *testing.T) {
+			if wait := backoff(test.attempts); wait != test.expected {
+				t.Fatalf("expected %s, got %s", test.expected, wait)
+			}
+		})
+	}
+}
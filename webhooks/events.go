@@ -0,0 +1,32 @@
+package webhooks
+
+import (
+	"context"
+
+	"github.com/mrz1836/go-api/events"
+)
+
+// consumerName is the webhooks consumer of the event outbox (event_offsets table)
+const consumerName = "webhooks"
+
+// StartUp subscribes the webhooks to the events (deliveries are queued as the event-relay job publishes them)
+func StartUp() error {
+	return events.Subscribe(consumerName, queueEvent, eventTypes...)
+}
+
+// queueEvent queues the deliveries for a person event (the outbox event ID is kept, so a relayed event is queued once)
+func queueEvent(ctx context.Context, envelope *events.Envelope) (err error) {
+	var personEvent events.PersonEvent
+	if err = envelope.Decode(&personEvent); err != nil {
+		return
+	}
+
+	// The person after the change (only the ID once purged)
+	var data interface{} = map[string]interface{}{"id": personEvent.PersonID}
+	if len(personEvent.Person) > 0 {
+		data = personEvent.Person
+	}
+
+	_, err = queue(ctx, &Event{CreatedAt: envelope.CreatedAt, Data: data, ID: envelope.EventID, Type: envelope.Type})
+	return
+}
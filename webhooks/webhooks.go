@@ -0,0 +1,255 @@
+/*
+Package webhooks delivers events to the integrations' endpoints (subscriptions, signed deliveries, retries and replays)
+*/
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mrz1836/go-api/config"
+	"github.com/mrz1836/go-api/database"
+	"github.com/mrz1836/go-api/events"
+	"github.com/volatiletech/sqlboiler/v4/queries"
+)
+
+// Event types (subscriptions choose which they receive)
+const (
+	EventPersonCreated  = events.TypePersonCreated
+	EventPersonDeleted  = events.TypePersonDeleted // Soft delete (is_deleted)
+	EventPersonPurged   = events.TypePersonPurged  // Removed for good
+	EventPersonRestored = events.TypePersonRestored
+	EventPersonUpdated  = events.TypePersonUpdated
+)
+
+// secretPrefix starts every generated secret (IE: whsec_3f2a...)
+const secretPrefix = "whsec_"
+
+var (
+	// ErrInvalidSubscription is when the url, secret or event types are not valid
+	ErrInvalidSubscription = errors.New("webhook subscription is not valid")
+
+	// ErrSubscriptionNotFound is when the subscription does not exist
+	ErrSubscriptionNotFound = errors.New("webhook subscription not found")
+)
+
+// eventTypes are all the event types (in display order)
+var eventTypes = []string{
+	EventPersonCreated,
+	EventPersonUpdated,
+	EventPersonDeleted,
+	EventPersonRestored,
+	EventPersonPurged,
+}
+
+// Subscription is an endpoint that receives events (webhook_subscriptions table)
+type Subscription struct {
+	CreatedAt   time.Time `boil:"created_at" json:"created_at"`
+	Description string    `boil:"description" json:"description"`
+	EventTypes  string    `boil:"event_types" json:"-"` // Comma separated
+	Events      []string  `boil:"-" json:"event_types"`
+	ID          uint64    `boil:"id" json:"id"`
+	IsActive    bool      `boil:"is_active" json:"is_active"`
+	ModifiedAt  time.Time `boil:"modified_at" json:"modified_at"`
+	Secret      string    `boil:"secret" json:"secret,omitempty"` // Only returned when created
+	URL         string    `boil:"url" json:"url"`
+}
+
+// EventTypes returns all the event types
+func EventTypes() []string {
+	return append([]string{}, eventTypes...)
+}
+
+// CreateSubscription saves a new subscription (a secret is generated if empty, it is only returned here)
+func CreateSubscription(ctx context.Context, subscription *Subscription) (err error) {
+	if len(subscription.Secret) == 0 {
+		if subscription.Secret, err = GenerateSecret(); err != nil {
+			return
+		}
+	}
+	if err = subscription.check(); err != nil {
+		return
+	}
+
+	var result sql.Result
+	if result, err = database.WriteDatabase.ExecContext(ctx,
+		"INSERT INTO `webhook_subscriptions` (`url`, `secret`, `event_types`, `description`, `is_active`) VALUES (?, ?, ?, ?, ?)",
+		subscription.URL, subscription.Secret, strings.Join(subscription.Events, ","), subscription.Description, subscription.IsActive,
+	); err != nil {
+		return
+	}
+	var id int64
+	if id, err = result.LastInsertId(); err != nil {
+		return
+	}
+
+	// Return the stored subscription (with the secret, the only time it is shown)
+	secret := subscription.Secret
+	var stored *Subscription
+	if stored, err = GetSubscription(ctx, uint64(id)); err != nil {
+		return
+	}
+	*subscription = *stored
+	subscription.Secret = secret
+	return
+}
+
+// UpdateSubscription saves the url, event types, description and if the subscription is active (the secret is unchanged)
+func UpdateSubscription(ctx context.Context, subscription *Subscription) (err error) {
+	if err = subscription.check(); err != nil {
+		return
+	}
+	var result sql.Result
+	if result, err = database.WriteDatabase.ExecContext(ctx,
+		"UPDATE `webhook_subscriptions` SET `url` = ?, `event_types` = ?, `description` = ?, `is_active` = ? WHERE `id` = ?",
+		subscription.URL, strings.Join(subscription.Events, ","), subscription.Description, subscription.IsActive, subscription.ID,
+	); err != nil {
+		return
+	}
+	var rows int64
+	if rows, err = result.RowsAffected(); err != nil {
+		return
+	} else if rows == 0 {
+		// Nothing changed, or it does not exist
+		if _, err = GetSubscription(ctx, subscription.ID); err != nil {
+			return
+		}
+	}
+	return nil
+}
+
+// RotateSecret replaces the subscription secret (returns the new secret)
+func RotateSecret(ctx context.Context, id uint64) (secret string, err error) {
+	if secret, err = GenerateSecret(); err != nil {
+		return
+	}
+	var result sql.Result
+	if result, err = database.WriteDatabase.ExecContext(ctx,
+		"UPDATE `webhook_subscriptions` SET `secret` = ? WHERE `id` = ?", secret, id,
+	); err != nil {
+		return "", err
+	}
+	var rows int64
+	if rows, err = result.RowsAffected(); err != nil {
+		return "", err
+	} else if rows == 0 {
+		return "", fmt.Errorf("%w: %d", ErrSubscriptionNotFound, id)
+	}
+	return
+}
+
+// DeleteSubscription removes the subscription (pending deliveries are failed)
+func DeleteSubscription(ctx context.Context, id uint64) error {
+	result, err := database.WriteDatabase.ExecContext(ctx,
+		"DELETE FROM `webhook_subscriptions` WHERE `id` = ?", id,
+	)
+	if err != nil {
+		return err
+	}
+	var rows int64
+	if rows, err = result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return fmt.Errorf("%w: %d", ErrSubscriptionNotFound, id)
+	}
+	_, err = database.WriteDatabase.ExecContext(ctx,
+		"UPDATE `webhook_deliveries` SET `status` = ?, `last_error` = 'subscription deleted', `locked_until` = NULL WHERE `subscription_id` = ? AND `status` = ?",
+		DeliveryStatusFailed, id, DeliveryStatusPending,
+	)
+	return err
+}
+
+// GetSubscriptions gets every subscription (newest first, without the secrets)
+func GetSubscriptions(ctx context.Context) (subscriptions []*Subscription, err error) {
+	if err = queries.Raw(
+		"SELECT * FROM `webhook_subscriptions` ORDER BY `id` DESC",
+	).Bind(ctx, database.ReadDatabase, &subscriptions); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	for _, subscription := range subscriptions {
+		subscription.loaded()
+	}
+	return subscriptions, nil
+}
+
+// GetSubscription gets a subscription by ID (without the secret)
+func GetSubscription(ctx context.Context, id uint64) (subscription *Subscription, err error) {
+	subscription = new(Subscription)
+	if err = queries.Raw(
+		"SELECT * FROM `webhook_subscriptions` WHERE `id` = ?", id,
+	).Bind(ctx, database.ReadDatabase, subscription); errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %d", ErrSubscriptionNotFound, id)
+	} else if err != nil {
+		return nil, err
+	}
+	subscription.loaded()
+	return
+}
+
+// GenerateSecret returns a new random signing secret
+func GenerateSecret() (string, error) {
+	random := make([]byte, 24)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return secretPrefix + hex.EncodeToString(random), nil
+}
+
+// loaded splits the stored event types and hides the secret
+func (s *Subscription) loaded() {
+	s.Events = strings.Split(s.EventTypes, ",")
+	s.Secret = ""
+}
+
+// check returns an error if the url is not allowed, the secret is short or an event type is unknown
+func (s *Subscription) check() error {
+
+	// Url (https and a public host, unless config webhooks.allow_insecure)
+	parsed, err := url.Parse(s.URL)
+	if err != nil || len(parsed.Hostname()) == 0 || len(s.URL) > 500 {
+		return fmt.Errorf("%w: url is not valid", ErrInvalidSubscription)
+	}
+	if !config.Values.Webhooks.AllowInsecure {
+		if parsed.Scheme != "https" {
+			return fmt.Errorf("%w: url must be https", ErrInvalidSubscription)
+		} else if ip := net.ParseIP(parsed.Hostname()); ip != nil && !publicIP(ip) {
+			return fmt.Errorf("%w: url must be a public address", ErrInvalidSubscription)
+		}
+	} else if parsed.Scheme != "https" && parsed.Scheme != "http" {
+		return fmt.Errorf("%w: url must be http or https", ErrInvalidSubscription)
+	}
+
+	// Secret
+	if len(s.Secret) > 0 && (len(s.Secret) < 16 || len(s.Secret) > 255) {
+		return fmt.Errorf("%w: secret must be 16 to 255 characters", ErrInvalidSubscription)
+	}
+
+	// Event types (at least one)
+	if len(s.Events) == 0 {
+		return fmt.Errorf("%w: missing event types", ErrInvalidSubscription)
+	}
+	for _, eventType := range s.Events {
+		known := false
+		for _, t := range eventTypes {
+			known = known || t == eventType
+		}
+		if !known {
+			return fmt.Errorf("%w: unknown event type %s", ErrInvalidSubscription, eventType)
+		}
+	}
+	return nil
+}
+
+// publicIP returns true if the address is not loopback, private, link-local or unspecified
+func publicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsUnspecified() && !ip.IsMulticast() && !ip.IsInterfaceLocalMulticast()
+}